@@ -0,0 +1,75 @@
+package bitswap
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-bitswap"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	exchange "github.com/ipfs/go-ipfs-exchange-interface"
+)
+
+// Bitswap modes, as reported by Mode.
+const (
+	ModeFull   = "client+server"
+	ModeClient = "client"
+	ModeServer = "server"
+)
+
+// ErrClientDisabled is returned when fetching blocks with the bitswap client
+// disabled.
+var ErrClientDisabled = errors.New("bitswap client is disabled (Internal.Bitswap.ClientEnabled is false)")
+
+// ServerOnly is a bitswap exchange serving blocks but never fetching them.
+type ServerOnly struct {
+	*bitswap.Bitswap
+}
+
+var _ exchange.SessionExchange = ServerOnly{}
+
+// GetBlock returns ErrClientDisabled.
+func (ServerOnly) GetBlock(context.Context, cid.Cid) (blocks.Block, error) {
+	return nil, ErrClientDisabled
+}
+
+// GetBlocks returns ErrClientDisabled.
+func (ServerOnly) GetBlocks(context.Context, []cid.Cid) (<-chan blocks.Block, error) {
+	return nil, ErrClientDisabled
+}
+
+// NewSession returns the exchange itself, so sessions don't fetch either.
+func (e ServerOnly) NewSession(context.Context) exchange.Fetcher {
+	return e
+}
+
+// Unwrap returns the bitswap instance of an exchange created by the node,
+// or nil if it isn't bitswap.
+func Unwrap(exch exchange.Interface) *bitswap.Bitswap {
+	switch e := exch.(type) {
+	case *bitswap.Bitswap:
+		return e
+	case ServerOnly:
+		return e.Bitswap
+	default:
+		return nil
+	}
+}
+
+// Mode returns the bitswap mode of an exchange created by the node, with
+// policy the server policy of the node, if any.
+func Mode(exch exchange.Interface, policy *Policy) string {
+	client := Unwrap(exch) != nil
+	if _, ok := exch.(ServerOnly); ok {
+		client = false
+	}
+	server := policy == nil || !policy.cfg.DisableServer
+	switch {
+	case client && server:
+		return ModeFull
+	case client:
+		return ModeClient
+	default:
+		return ModeServer
+	}
+}
@@ -0,0 +1,51 @@
+package bitswap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-bitswap"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+)
+
+func TestMode(t *testing.T) {
+	bs := &bitswap.Bitswap{}
+	serverOnly := ServerOnly{Bitswap: bs}
+	clientOnly := NewPolicy(PolicyConfig{DisableServer: true})
+
+	for _, test := range []struct {
+		name     string
+		policy   *Policy
+		expected string
+	}{
+		{ModeFull, NewPolicy(PolicyConfig{}), ModeFull},
+		{"no policy", nil, ModeFull},
+		{ModeClient, clientOnly, ModeClient},
+	} {
+		if mode := Mode(bs, test.policy); mode != test.expected {
+			t.Fatalf("%s: expected mode %s, got %s", test.name, test.expected, mode)
+		}
+	}
+	if mode := Mode(serverOnly, nil); mode != ModeServer {
+		t.Fatalf("expected mode %s, got %s", ModeServer, mode)
+	}
+
+	if Unwrap(serverOnly) != bs || Unwrap(bs) != bs || Unwrap(offline.Exchange(nil)) != nil {
+		t.Fatal("unexpected unwrapped exchange")
+	}
+
+	if clientOnly.CanServe(testPeer(t)) {
+		t.Fatal("peer served with the server disabled")
+	}
+}
+
+func TestServerOnlyFetch(t *testing.T) {
+	exch := ServerOnly{Bitswap: &bitswap.Bitswap{}}
+	c := testKeys(1)[0]
+	if _, err := exch.GetBlock(context.Background(), c); err != ErrClientDisabled {
+		t.Fatalf("expected ErrClientDisabled, got %v", err)
+	}
+	if _, err := exch.NewSession(context.Background()).GetBlocks(context.Background(), testKeys(2)); err != ErrClientDisabled {
+		t.Fatalf("expected ErrClientDisabled, got %v", err)
+	}
+}
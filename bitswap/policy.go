@@ -0,0 +1,448 @@
+// Package bitswap restricts what the bitswap server does for remote peers:
+// which peers are served, how fast, and how many wants each peer can have.
+//
+// The policy wraps the bitswap network, filtering the wantlists of incoming
+// messages and throttling outgoing ones, so that it works with an unmodified
+// bitswap engine. Throttled messages wait in a queue of their peer, so that
+// they don't hold the task workers of the engine shared by all peers.
+package bitswap
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	bsnet "github.com/ipfs/go-bitswap/network"
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var log = logging.Logger("bitswap/policy")
+
+// burst is how long a peer can go over its bandwidth cap after being idle.
+const burst = time.Second
+
+// maxQueueDelay bounds the messages waiting in the queue of a peer to that
+// much time of its bandwidth cap. Further messages are dropped: the peer
+// wants their blocks again when it rebroadcasts its wantlist.
+var maxQueueDelay = 10 * time.Second
+
+// PolicyConfig configures a Policy. Zero values don't restrict anything.
+type PolicyConfig struct {
+	// AllowList, when not empty, is the only peers served.
+	AllowList []peer.ID
+	// DenyList is peers never served.
+	DenyList []peer.ID
+	// MaxBytesPerSecondPerPeer caps the upload bandwidth to each peer.
+	MaxBytesPerSecondPerPeer int64
+	// MaxWantlistSize is the maximum number of wants kept for each peer.
+	// Further wants are ignored until the peer cancels some.
+	MaxWantlistSize int
+	// DisableServer ignores the wants of all peers.
+	DisableServer bool
+}
+
+// Policy applies a PolicyConfig to the bitswap server.
+type Policy struct {
+	cfg   PolicyConfig
+	allow map[peer.ID]struct{}
+	deny  map[peer.ID]struct{}
+
+	lk    sync.Mutex
+	peers map[peer.ID]*peerState
+
+	deniedWants     uint64
+	rejectedWants   uint64
+	droppedMessages uint64
+	throttled       time.Duration
+}
+
+type peerState struct {
+	wants *cid.Set
+
+	// tat is the theoretical arrival time of the next byte sent to the
+	// peer at the capped rate.
+	tat time.Time
+
+	// queue is the messages waiting to be sent to the peer, queued bytes
+	// long, and sending whether a goroutine is sending them.
+	queue   []queuedMessage
+	queued  int
+	sending bool
+
+	deniedWants     uint64
+	rejectedWants   uint64
+	droppedMessages uint64
+	throttled       time.Duration
+}
+
+// queuedMessage is a message waiting to be sent at a time.
+type queuedMessage struct {
+	ctx context.Context
+	msg bsmsg.BitSwapMessage
+	at  time.Time
+}
+
+// NewPolicy creates a policy from cfg.
+func NewPolicy(cfg PolicyConfig) *Policy {
+	p := &Policy{
+		cfg:   cfg,
+		deny:  make(map[peer.ID]struct{}, len(cfg.DenyList)),
+		peers: make(map[peer.ID]*peerState),
+	}
+	if len(cfg.AllowList) > 0 {
+		p.allow = make(map[peer.ID]struct{}, len(cfg.AllowList))
+		for _, pid := range cfg.AllowList {
+			p.allow[pid] = struct{}{}
+		}
+	}
+	for _, pid := range cfg.DenyList {
+		p.deny[pid] = struct{}{}
+	}
+	return p
+}
+
+// CanServe returns whether pid is served blocks.
+func (p *Policy) CanServe(pid peer.ID) bool {
+	if p.cfg.DisableServer {
+		return false
+	}
+	if _, ok := p.deny[pid]; ok {
+		return false
+	}
+	if p.allow == nil {
+		return true
+	}
+	_, ok := p.allow[pid]
+	return ok
+}
+
+// Wrap returns a network applying the policy to the messages exchanged on n.
+func (p *Policy) Wrap(n bsnet.BitSwapNetwork) bsnet.BitSwapNetwork {
+	return &network{BitSwapNetwork: n, policy: p}
+}
+
+// peer returns the state of pid, creating it if needed. p.lk must be held.
+func (p *Policy) peer(pid peer.ID) *peerState {
+	st, ok := p.peers[pid]
+	if !ok {
+		st = &peerState{wants: cid.NewSet()}
+		p.peers[pid] = st
+	}
+	return st
+}
+
+// filterIncoming removes the wants of msg that the server must not see, and
+// returns msg or a filtered copy of it.
+func (p *Policy) filterIncoming(pid peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+	wants := msg.Wantlist()
+	if len(wants) == 0 && !msg.Full() {
+		return msg
+	}
+	canServe := p.CanServe(pid)
+	if canServe && p.cfg.MaxWantlistSize <= 0 {
+		return msg
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	st := p.peer(pid)
+
+	var keep []bsmsg.Entry
+	if !canServe {
+		for _, e := range wants {
+			if !e.Cancel {
+				st.deniedWants++
+				p.deniedWants++
+			}
+		}
+	} else {
+		if msg.Full() {
+			st.wants = cid.NewSet()
+		}
+		// Cancels first, to make room for the wants of the message.
+		for _, e := range wants {
+			if e.Cancel {
+				st.wants.Remove(e.Cid)
+				keep = append(keep, e)
+			}
+		}
+		for _, e := range wants {
+			switch {
+			case e.Cancel:
+			case st.wants.Has(e.Cid) || st.wants.Len() < p.cfg.MaxWantlistSize:
+				st.wants.Add(e.Cid)
+				keep = append(keep, e)
+			default:
+				st.rejectedWants++
+				p.rejectedWants++
+			}
+		}
+		if len(keep) == len(wants) {
+			return msg
+		}
+	}
+
+	// Blocks and presences are for the client, and are kept as is.
+	out := bsmsg.New(msg.Full())
+	for _, e := range keep {
+		if e.Cancel {
+			out.Cancel(e.Cid)
+		} else {
+			out.AddEntry(e.Cid, e.Priority, e.WantType, e.SendDontHave)
+		}
+	}
+	for _, b := range msg.Blocks() {
+		out.AddBlock(b)
+	}
+	for _, bp := range msg.BlockPresences() {
+		out.AddBlockPresence(bp.Cid, bp.Type)
+	}
+	out.SetPendingBytes(msg.PendingBytes())
+	return out
+}
+
+// sent records a server message sent to pid, and returns how long to wait
+// before sending it to stay under the bandwidth cap.
+func (p *Policy) sent(pid peer.ID, msg bsmsg.BitSwapMessage) time.Duration {
+	if p.cfg.MaxWantlistSize <= 0 && p.cfg.MaxBytesPerSecondPerPeer <= 0 {
+		return 0
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.charge(p.peer(pid), msg)
+}
+
+// charge records msg sent to the peer of st, and returns how long to wait
+// before sending it. p.lk must be held.
+func (p *Policy) charge(st *peerState, msg bsmsg.BitSwapMessage) time.Duration {
+	answered(st, msg)
+
+	rate := p.cfg.MaxBytesPerSecondPerPeer
+	if rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	if st.tat.Before(now) {
+		st.tat = now
+	}
+	st.tat = st.tat.Add(time.Duration(int64(msg.Size()) * int64(time.Second) / rate))
+	wait := st.tat.Sub(now) - burst
+	if wait <= 0 {
+		return 0
+	}
+	st.throttled += wait
+	p.throttled += wait
+	return wait
+}
+
+// answered removes the wants answered by msg from st, as they leave the
+// wantlist of the engine.
+func answered(st *peerState, msg bsmsg.BitSwapMessage) {
+	for _, b := range msg.Blocks() {
+		st.wants.Remove(b.Cid())
+	}
+	for _, bp := range msg.BlockPresences() {
+		st.wants.Remove(bp.Cid)
+	}
+}
+
+// schedule records a server message to pid, and returns whether to send it
+// now. Otherwise, it is queued, or dropped if the queue of the peer is full,
+// and start is true when a goroutine must be started to send the queue.
+func (p *Policy) schedule(ctx context.Context, pid peer.ID, msg bsmsg.BitSwapMessage) (now bool, st *peerState, start bool) {
+	if p.cfg.MaxWantlistSize <= 0 && p.cfg.MaxBytesPerSecondPerPeer <= 0 {
+		return true, nil, false
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	st = p.peer(pid)
+
+	rate := p.cfg.MaxBytesPerSecondPerPeer
+	if len(st.queue) > 0 && int64(st.queued+msg.Size()) > rate*int64(maxQueueDelay/time.Second) {
+		answered(st, msg)
+		st.droppedMessages++
+		p.droppedMessages++
+		return false, st, false
+	}
+
+	wait := p.charge(st, msg)
+	if wait <= 0 && len(st.queue) == 0 {
+		return true, st, false
+	}
+	st.queue = append(st.queue, queuedMessage{ctx: ctx, msg: msg, at: time.Now().Add(wait)})
+	st.queued += msg.Size()
+	if st.sending {
+		return false, st, false
+	}
+	st.sending = true
+	return false, st, true
+}
+
+// next pops the next message of the queue of st, or returns false when the
+// queue is empty and the goroutine sending it must stop.
+func (p *Policy) next(st *peerState) (queuedMessage, bool) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if len(st.queue) == 0 {
+		st.sending = false
+		return queuedMessage{}, false
+	}
+	qm := st.queue[0]
+	st.queue[0] = queuedMessage{}
+	st.queue = st.queue[1:]
+	st.queued -= qm.msg.Size()
+	return qm, true
+}
+
+func (p *Policy) disconnected(pid peer.ID) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if st, ok := p.peers[pid]; ok {
+		// the goroutine sending the queue stops at the next message
+		st.queue, st.queued = nil, 0
+	}
+	delete(p.peers, pid)
+}
+
+// PolicyStat describes a Policy and what it did.
+type PolicyStat struct {
+	AllowList                []string
+	DenyList                 []string
+	MaxBytesPerSecondPerPeer int64
+	MaxWantlistSize          int
+
+	// DeniedWants counts the wants ignored from peers not served.
+	DeniedWants uint64
+	// RejectedWants counts the wants ignored because the wantlist of
+	// the peer was full.
+	RejectedWants uint64
+	// DroppedMessages counts the messages not sent because too many
+	// were waiting for the bandwidth cap of their peer.
+	DroppedMessages uint64
+	// Throttled is the total time sends were delayed by bandwidth caps.
+	Throttled time.Duration
+}
+
+// Stat returns the configuration and counters of the policy.
+func (p *Policy) Stat() *PolicyStat {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return &PolicyStat{
+		AllowList:                peerStrings(p.cfg.AllowList),
+		DenyList:                 peerStrings(p.cfg.DenyList),
+		MaxBytesPerSecondPerPeer: p.cfg.MaxBytesPerSecondPerPeer,
+		MaxWantlistSize:          p.cfg.MaxWantlistSize,
+		DeniedWants:              p.deniedWants,
+		RejectedWants:            p.rejectedWants,
+		DroppedMessages:          p.droppedMessages,
+		Throttled:                p.throttled,
+	}
+}
+
+// PeerPolicyStat describes how the policy applies to a peer.
+type PeerPolicyStat struct {
+	Served                   bool
+	MaxBytesPerSecondPerPeer int64
+	MaxWantlistSize          int
+	// Wants is the number of wants tracked for the peer, only counted
+	// when the wantlist size is capped.
+	Wants           int
+	DeniedWants     uint64
+	RejectedWants   uint64
+	DroppedMessages uint64
+	Throttled       time.Duration
+}
+
+// PeerStat returns how the policy applies to pid. Counters are reset when
+// the peer disconnects.
+func (p *Policy) PeerStat(pid peer.ID) *PeerPolicyStat {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	out := &PeerPolicyStat{
+		Served:                   p.CanServe(pid),
+		MaxBytesPerSecondPerPeer: p.cfg.MaxBytesPerSecondPerPeer,
+		MaxWantlistSize:          p.cfg.MaxWantlistSize,
+	}
+	if st, ok := p.peers[pid]; ok {
+		out.Wants = st.wants.Len()
+		out.DeniedWants = st.deniedWants
+		out.RejectedWants = st.rejectedWants
+		out.DroppedMessages = st.droppedMessages
+		out.Throttled = st.throttled
+	}
+	return out
+}
+
+func peerStrings(pids []peer.ID) []string {
+	out := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		out = append(out, pid.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+// network applies a policy to a bitswap network. SendMessage is only used
+// by the server, the client goes through message senders.
+type network struct {
+	bsnet.BitSwapNetwork
+	policy *Policy
+}
+
+func (n *network) SetDelegate(r bsnet.Receiver) {
+	n.BitSwapNetwork.SetDelegate(&receiver{Receiver: r, policy: n.policy})
+}
+
+func (n *network) SendMessage(ctx context.Context, pid peer.ID, msg bsmsg.BitSwapMessage) error {
+	now, st, start := n.policy.schedule(ctx, pid, msg)
+	if start {
+		go n.sendQueue(pid, st)
+	}
+	if !now {
+		return nil
+	}
+	return n.BitSwapNetwork.SendMessage(ctx, pid, msg)
+}
+
+// sendQueue sends the messages queued for pid in st, each at its time.
+func (n *network) sendQueue(pid peer.ID, st *peerState) {
+	for {
+		qm, ok := n.policy.next(st)
+		if !ok {
+			return
+		}
+		if wait := time.Until(qm.at); wait > 0 {
+			log.Debugf("throttling message to %s for %s", pid, wait)
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-qm.ctx.Done():
+				t.Stop()
+				continue
+			}
+		}
+		if err := n.BitSwapNetwork.SendMessage(qm.ctx, pid, qm.msg); err != nil {
+			log.Debugf("failed to send throttled message to %s: %s", pid, err)
+		}
+	}
+}
+
+type receiver struct {
+	bsnet.Receiver
+	policy *Policy
+}
+
+func (r *receiver) ReceiveMessage(ctx context.Context, pid peer.ID, msg bsmsg.BitSwapMessage) {
+	r.Receiver.ReceiveMessage(ctx, pid, r.policy.filterIncoming(pid, msg))
+}
+
+func (r *receiver) PeerDisconnected(pid peer.ID) {
+	r.policy.disconnected(pid)
+	r.Receiver.PeerDisconnected(pid)
+}
@@ -0,0 +1,258 @@
+package bitswap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	pb "github.com/ipfs/go-bitswap/message/pb"
+	bsnet "github.com/ipfs/go-bitswap/network"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func testPeer(t *testing.T) peer.ID {
+	pid, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+func wantMsg(full bool, wants []cid.Cid, cancels ...cid.Cid) bsmsg.BitSwapMessage {
+	msg := bsmsg.New(full)
+	for _, c := range wants {
+		msg.AddEntry(c, 1, pb.Message_Wantlist_Block, false)
+	}
+	for _, c := range cancels {
+		msg.Cancel(c)
+	}
+	return msg
+}
+
+func wanted(msg bsmsg.BitSwapMessage) int {
+	n := 0
+	for _, e := range msg.Wantlist() {
+		if !e.Cancel {
+			n++
+		}
+	}
+	return n
+}
+
+func testBlocks(n int) []blocks.Block {
+	var out []blocks.Block
+	for i := 0; i < n; i++ {
+		out = append(out, blocks.NewBlock([]byte{byte(i)}))
+	}
+	return out
+}
+
+func testKeys(n int) []cid.Cid {
+	var out []cid.Cid
+	for _, b := range testBlocks(n) {
+		out = append(out, b.Cid())
+	}
+	return out
+}
+
+func TestPolicyAllowDeny(t *testing.T) {
+	allowed, denied, other := testPeer(t), testPeer(t), testPeer(t)
+	keys := testKeys(2)
+
+	p := NewPolicy(PolicyConfig{DenyList: []peer.ID{denied}})
+	if !p.CanServe(allowed) || !p.CanServe(other) || p.CanServe(denied) {
+		t.Fatal("deny list not applied")
+	}
+
+	p = NewPolicy(PolicyConfig{AllowList: []peer.ID{allowed, denied}, DenyList: []peer.ID{denied}})
+	if !p.CanServe(allowed) || p.CanServe(other) || p.CanServe(denied) {
+		t.Fatal("allow list not applied")
+	}
+
+	msg := wantMsg(false, keys)
+	blk := blocks.NewBlock([]byte("block"))
+	msg.AddBlock(blk)
+	if out := p.filterIncoming(allowed, msg); out != msg {
+		t.Fatal("message of an allowed peer was modified")
+	}
+	out := p.filterIncoming(other, msg)
+	if wanted(out) != 0 {
+		t.Fatal("wants of a peer not allowed were kept")
+	}
+	if len(out.Blocks()) != 1 || out.Blocks()[0].Cid() != blk.Cid() {
+		t.Fatal("blocks sent by a peer not served were dropped")
+	}
+	if st := p.PeerStat(other); st.Served || st.DeniedWants != 2 {
+		t.Fatalf("unexpected peer stats %+v", st)
+	}
+	if st := p.Stat(); st.DeniedWants != 2 || len(st.AllowList) != 2 || len(st.DenyList) != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestPolicyMaxWantlistSize(t *testing.T) {
+	pid := testPeer(t)
+	keys := testKeys(5)
+	p := NewPolicy(PolicyConfig{MaxWantlistSize: 3})
+
+	if n := wanted(p.filterIncoming(pid, wantMsg(false, keys[:2]))); n != 2 {
+		t.Fatalf("expected 2 wants, got %d", n)
+	}
+	// Wanting again a key already wanted doesn't count twice.
+	if n := wanted(p.filterIncoming(pid, wantMsg(false, keys[1:4]))); n != 2 {
+		t.Fatalf("expected 2 wants, got %d", n)
+	}
+	if st := p.PeerStat(pid); st.Wants != 3 || st.RejectedWants != 1 {
+		t.Fatalf("unexpected peer stats %+v", st)
+	}
+
+	// Cancels and answers make room.
+	out := p.filterIncoming(pid, wantMsg(false, keys[4:], keys[0]))
+	if n := wanted(out); n != 1 || len(out.Wantlist()) != 2 {
+		t.Fatalf("expected a want and a cancel, got %v", out.Wantlist())
+	}
+	resp := bsmsg.New(false)
+	resp.AddDontHave(keys[1])
+	p.sent(pid, resp)
+	if st := p.PeerStat(pid); st.Wants != 2 {
+		t.Fatalf("expected 2 wants, got %d", st.Wants)
+	}
+
+	// Full wantlists replace the previous one.
+	if n := wanted(p.filterIncoming(pid, wantMsg(true, keys))); n != 3 {
+		t.Fatalf("expected 3 wants, got %d", n)
+	}
+
+	p.disconnected(pid)
+	if st := p.PeerStat(pid); st.Wants != 0 || st.RejectedWants != 0 {
+		t.Fatalf("expected the peer state to be cleared, got %+v", st)
+	}
+}
+
+func TestPolicyBandwidth(t *testing.T) {
+	pid := testPeer(t)
+	msg := bsmsg.New(false)
+	for _, b := range testBlocks(10) {
+		msg.AddBlock(b)
+	}
+	size := int64(msg.Size())
+
+	// Two messages per second, after the burst.
+	p := NewPolicy(PolicyConfig{MaxBytesPerSecondPerPeer: 2 * size})
+	for i := 0; i < 2; i++ {
+		if wait := p.sent(pid, msg); wait != 0 {
+			t.Fatalf("message %d throttled during the burst", i)
+		}
+	}
+	wait := p.sent(pid, msg)
+	if wait < 400*time.Millisecond || wait > 600*time.Millisecond {
+		t.Fatalf("expected to wait about 500ms, got %s", wait)
+	}
+	if st := p.PeerStat(pid); st.Throttled != wait {
+		t.Fatalf("expected %s throttled, got %s", wait, st.Throttled)
+	}
+
+	if wait := NewPolicy(PolicyConfig{}).sent(pid, msg); wait != 0 {
+		t.Fatal("throttled without a bandwidth cap")
+	}
+}
+
+// sentMessage is a message sent through a mockNetwork.
+type sentMessage struct {
+	pid peer.ID
+	msg bsmsg.BitSwapMessage
+	at  time.Time
+}
+
+type mockNetwork struct {
+	bsnet.BitSwapNetwork
+	sent chan sentMessage
+}
+
+func (n *mockNetwork) SendMessage(ctx context.Context, pid peer.ID, msg bsmsg.BitSwapMessage) error {
+	n.sent <- sentMessage{pid: pid, msg: msg, at: time.Now()}
+	return nil
+}
+
+func TestPolicyThrottledPeerQueue(t *testing.T) {
+	ctx := context.Background()
+	capped, other := testPeer(t), testPeer(t)
+	msgs := make([]bsmsg.BitSwapMessage, 25)
+	for i := range msgs {
+		msgs[i] = bsmsg.New(false)
+		msgs[i].AddBlock(blocks.NewBlock([]byte{byte(i), 0, 0, 0}))
+	}
+	size := int64(msgs[0].Size())
+
+	// 20 messages per second, the first 20 in the burst.
+	mn := &mockNetwork{sent: make(chan sentMessage, 100)}
+	n := NewPolicy(PolicyConfig{MaxBytesPerSecondPerPeer: 20 * size}).Wrap(mn)
+
+	start := time.Now()
+	for _, msg := range msgs {
+		if err := n.SendMessage(ctx, capped, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.SendMessage(ctx, other, msgs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("sending to a throttled peer blocked for %s", d)
+	}
+
+	var next int
+	for i := 0; i < len(msgs)+1; i++ {
+		select {
+		case s := <-mn.sent:
+			if s.pid == other {
+				if d := s.at.Sub(start); d > 100*time.Millisecond {
+					t.Fatalf("the other peer was delayed by %s", d)
+				}
+				continue
+			}
+			if s.msg != msgs[next] {
+				t.Fatalf("expected message %d to be sent next", next)
+			}
+			next++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d messages were sent to the throttled peer", next)
+		}
+	}
+	// the last 5 messages waited for the cap
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("the throttled peer was sent all the messages in %s", d)
+	}
+}
+
+func TestPolicyThrottledPeerQueueFull(t *testing.T) {
+	defer func(d time.Duration) { maxQueueDelay = d }(maxQueueDelay)
+	maxQueueDelay = time.Second
+
+	ctx := context.Background()
+	pid := testPeer(t)
+	msg := bsmsg.New(false)
+	msg.AddBlock(blocks.NewBlock([]byte("block")))
+	size := int64(msg.Size())
+
+	// 2 messages in the burst, and 2 in the queue
+	mn := &mockNetwork{sent: make(chan sentMessage, 100)}
+	p := NewPolicy(PolicyConfig{MaxBytesPerSecondPerPeer: 2 * size})
+	n := p.Wrap(mn)
+	for i := 0; i < 6; i++ {
+		if err := n.SendMessage(ctx, pid, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := p.PeerStat(pid); st.DroppedMessages != 2 {
+		t.Fatalf("expected 2 messages dropped, got %d", st.DroppedMessages)
+	}
+	if st := p.Stat(); st.DroppedMessages != 2 {
+		t.Fatalf("expected 2 messages dropped, got %d", st.DroppedMessages)
+	}
+	p.disconnected(pid)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+
+	oldcmds "github.com/ipfs/go-ipfs/commands"
+	config "github.com/ipfs/go-ipfs/config"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coresync"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+)
+
+// fswatchlog is the logger for the filestore watcher
+var fswatchlog = logging.Logger("filestore/watch")
+
+// startFilestoreWatch adds the directories of Experimental.FilestoreWatch to
+// the filestore, and re-adds them as their files change, until the daemon
+// stops.
+func startFilestoreWatch(cctx *oldcmds.Context, cfg *config.Config, node *core.IpfsNode) error {
+	if len(cfg.Experimental.FilestoreWatch) == 0 {
+		return nil
+	}
+	if !cfg.Experimental.FilestoreEnabled {
+		return fmt.Errorf("Experimental.FilestoreWatch requires Experimental.FilestoreEnabled")
+	}
+	root, err := fsrepo.FilestoreRoot(cctx.ConfigRoot)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range cfg.Experimental.FilestoreWatch {
+		s, err := coresync.New(node, w.Path, coresync.Options{
+			MFSPath:       w.MFSPath,
+			IPNSKey:       w.IPNSKey,
+			Nocopy:        true,
+			FilestoreRoot: root,
+		})
+		if err != nil {
+			return fmt.Errorf("watching %s: %w", w.Path, err)
+		}
+		go watchFilestoreDir(cctx, w.Path, s)
+	}
+	return nil
+}
+
+func watchFilestoreDir(cctx *oldcmds.Context, dir string, s *coresync.Syncer) {
+	ctx := cctx.Context()
+	// the files may have changed while the daemon was not running, the
+	// directory is added again over the previous root, whose objects of the
+	// changed files are removed
+	if _, err := s.Resume(ctx); err != nil {
+		fswatchlog.Errorf("watching %s: %s", dir, err)
+		return
+	}
+	nd, err := s.Sync(ctx)
+	if err != nil {
+		fswatchlog.Errorf("adding %s: %s", dir, err)
+		return
+	}
+	fswatchlog.Infof("added %s: %s", dir, nd.Cid())
+
+	err = s.Watch(ctx, func(nd ipld.Node, err error) {
+		if err != nil {
+			fswatchlog.Errorf("updating %s: %s", dir, err)
+			return
+		}
+		fswatchlog.Infof("updated %s: %s", dir, nd.Cid())
+	})
+	if err != nil {
+		fswatchlog.Errorf("watching %s: %s", dir, err)
+	}
+}
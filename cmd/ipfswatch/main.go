@@ -14,13 +14,12 @@ import (
 
 	commands "github.com/ipfs/go-ipfs/commands"
 	core "github.com/ipfs/go-ipfs/core"
-	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
 	corehttp "github.com/ipfs/go-ipfs/core/corehttp"
+	coresync "github.com/ipfs/go-ipfs/core/coresync"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
-	fsnotify "github.com/fsnotify/fsnotify"
-	files "github.com/ipfs/go-ipfs-files"
 	config "github.com/ipfs/go-ipfs/config"
+	ipld "github.com/ipfs/go-ipld-format"
 	process "github.com/jbenet/goprocess"
 	homedir "github.com/mitchellh/go-homedir"
 )
@@ -28,6 +27,8 @@ import (
 var http = flag.Bool("http", false, "expose IPFS HTTP API")
 var repoPath = flag.String("repo", os.Getenv("IPFS_PATH"), "IPFS_PATH to use")
 var watchPath = flag.String("path", ".", "the path to watch")
+var mfsPath = flag.String("mfs", "", "the MFS path to mirror the watched path to, instead of pinning it")
+var ipnsKey = flag.String("key", "", "the name of the key to publish the watched path to IPNS with")
 
 func main() {
 	flag.Parse()
@@ -61,15 +62,6 @@ func run(ipfsPath, watchPath string) error {
 	if err != nil {
 		return err
 	}
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return err
-	}
-	defer watcher.Close()
-
-	if err := addTree(watcher, watchPath); err != nil {
-		return err
-	}
 
 	r, err := fsrepo.Open(ipfsPath)
 	if err != nil {
@@ -87,11 +79,6 @@ func run(ipfsPath, watchPath string) error {
 	}
 	defer node.Close()
 
-	api, err := coreapi.NewCoreAPI(node)
-	if err != nil {
-		return err
-	}
-
 	if *http {
 		addr := "/ip4/127.0.0.1/tcp/5001"
 		var opts = []corehttp.ServeOption{
@@ -106,101 +93,36 @@ func run(ipfsPath, watchPath string) error {
 		})
 	}
 
+	s, err := coresync.New(node, watchPath, coresync.Options{
+		MFSPath: *mfsPath,
+		IPNSKey: *ipnsKey,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(node.Context())
+	defer cancel()
+
 	interrupts := make(chan os.Signal, 1)
 	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		cancel()
+	}()
 
-	for {
-		select {
-		case <-interrupts:
-			return nil
-		case e := <-watcher.Events:
-			log.Printf("received event: %s", e)
-			isDir, err := IsDirectory(e.Name)
-			if err != nil {
-				continue
-			}
-			switch e.Op {
-			case fsnotify.Remove:
-				if isDir {
-					if err := watcher.Remove(e.Name); err != nil {
-						return err
-					}
-				}
-			default:
-				// all events except for Remove result in an IPFS.Add, but only
-				// directory creation triggers a new watch
-				switch e.Op {
-				case fsnotify.Create:
-					if isDir {
-						if err := addTree(watcher, e.Name); err != nil {
-							return err
-						}
-					}
-				}
-				proc.Go(func(p process.Process) {
-					file, err := os.Open(e.Name)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-					defer file.Close()
-
-					st, err := file.Stat()
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					f, err := files.NewReaderPathFile(e.Name, file, st)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					k, err := api.Unixfs().Add(node.Context(), f)
-					if err != nil {
-						log.Println(err)
-					}
-					log.Printf("added %s... key: %s", e.Name, k)
-				})
-			}
-		case err := <-watcher.Errors:
-			log.Println(err)
-		}
+	root, err := s.Sync(ctx)
+	if err != nil {
+		return err
 	}
-}
+	log.Printf("added %s... key: %s", watchPath, root.Cid())
 
-func addTree(w *fsnotify.Watcher, root string) error {
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	return s.Watch(ctx, func(root ipld.Node, err error) {
 		if err != nil {
 			log.Println(err)
-			return nil
+			return
 		}
-		isDir, err := IsDirectory(path)
-		if err != nil {
-			log.Println(err)
-			return nil
-		}
-		switch {
-		case isDir && IsHidden(path):
-			log.Println(path)
-			return filepath.SkipDir
-		case isDir:
-			log.Println(path)
-			if err := w.Add(path); err != nil {
-				return err
-			}
-		default:
-			return nil
-		}
-		return nil
+		log.Printf("updated %s... key: %s", watchPath, root.Cid())
 	})
-	return err
-}
-
-func IsDirectory(path string) (bool, error) {
-	fileInfo, err := os.Stat(path)
-	return fileInfo.IsDir(), err
 }
 
 func IsHidden(path string) bool {
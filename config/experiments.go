@@ -9,4 +9,25 @@ type Experiments struct {
 	P2pHttpProxy         bool
 	StrategicProviding   bool
 	AcceleratedDHTClient bool
+
+	// UrlstoreHeaderEnv are the environment variables of the daemon the
+	// headers of the urlstore may reference, by name or by a prefix
+	// followed by '*', such as IPFS_URLSTORE_*.
+	UrlstoreHeaderEnv []string `json:",omitempty"`
+
+	// FilestoreWatch are the local directories the daemon adds with
+	// --nocopy, and re-adds as their files change.
+	FilestoreWatch []FilestoreWatch `json:",omitempty"`
+}
+
+// FilestoreWatch is a local directory watched by the daemon.
+type FilestoreWatch struct {
+	// Path is the local path of the directory.
+	Path string
+	// MFSPath is the MFS path pointed at the root of the directory. When it
+	// is not set, the root is pinned.
+	MFSPath string `json:",omitempty"`
+	// IPNSKey is the name of the key the root of the directory is published
+	// with, if set.
+	IPNSKey string `json:",omitempty"`
 }
@@ -18,6 +18,11 @@ type GatewaySpec struct {
 	// NoDNSLink configures this gateway to _not_ resolve DNSLink for the FQDN
 	// provided in `Host` HTTP header.
 	NoDNSLink bool
+
+	// Trustless configures this gateway to only return responses that can
+	// be verified by the client (raw blocks and CARs), never deserialized
+	// UnixFS content. It is always enabled when Gateway.Trustless is set.
+	Trustless bool
 }
 
 // Gateway contains options for the HTTP gateway server.
@@ -65,6 +70,15 @@ type Gateway struct {
 	// This flag can be overriden per FQDN in PublicGateways.
 	NoDNSLink bool
 
+	// Trustless configures the gateway to only return responses that can be
+	// verified by the client, such as raw blocks and CARs. Requests for
+	// deserialized content (files, directory listings) are rejected.
+	Trustless bool
+
+	// CacheSize limits the memory used to cache resolved immutable /ipfs/
+	// paths and generated directory listings. Set to "0" to disable.
+	CacheSize *OptionalString `json:",omitempty"`
+
 	// PublicGateways configures behavior of known public gateways.
 	// Each key is a fully qualified domain name (FQDN).
 	PublicGateways map[string]*GatewaySpec
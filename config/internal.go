@@ -12,4 +12,15 @@ type InternalBitswap struct {
 	EngineBlockstoreWorkerCount OptionalInteger
 	EngineTaskWorkerCount       OptionalInteger
 	MaxOutstandingBytesPerPeer  OptionalInteger
+
+	// Server policies, see docs/config.md.
+	ServeAllowList           []string `json:",omitempty"`
+	ServeDenyList            []string `json:",omitempty"`
+	MaxBytesPerSecondPerPeer OptionalInteger
+	MaxWantlistSizePerPeer   OptionalInteger
+
+	// ClientEnabled and ServerEnabled turn fetching and serving blocks
+	// on or off, see docs/config.md.
+	ClientEnabled Flag `json:",omitempty"`
+	ServerEnabled Flag `json:",omitempty"`
 }
@@ -1,9 +1,128 @@
 package config
 
+import (
+	"fmt"
+	"time"
+)
+
 // Routing defines configuration options for libp2p routing
 type Routing struct {
 	// Type sets default daemon routing mode.
 	//
-	// Can be one of "dht", "dhtclient", "dhtserver", "none", or unset.
+	// Can be one of "dht", "dhtclient", "dhtserver", "none", "custom" or
+	// unset. With "custom", all routers, including the DHT, are declared in
+	// Routers.
 	Type string
+
+	// Routers are additional routers used alongside the one selected by
+	// Type, keyed by name.
+	Routers map[string]Router `json:",omitempty"`
+
+	// Methods configures how the routers serving each routing method are
+	// composed, keyed by method name.
+	Methods map[string]Method `json:",omitempty"`
+}
+
+// Router configures a single additional router.
+type Router struct {
+	// Type is the kind of router: "http" (delegated routing over HTTP) or
+	// "dht" (only with the "custom" routing type).
+	Type string
+
+	// Enabled can be used to turn a router off without removing it.
+	Enabled Flag `json:",omitempty"`
+
+	// Parameters are specific to the router type. "http" routers require
+	// an "Endpoint". "dht" routers accept a "Mode" ("auto", "client" or
+	// "server") and "AcceleratedDHTClient" ("true" or "false").
+	Parameters map[string]string `json:",omitempty"`
+
+	// Priority orders routers: lower values are queried first and routers
+	// sharing a priority are queried in parallel. The pubsub IPNS router
+	// has priority 100 and the DHT 1000.
+	Priority *OptionalInteger `json:",omitempty"`
+
+	// Timeout bounds every request made to the router.
+	Timeout *OptionalDuration `json:",omitempty"`
+
+	// Methods lists the routing methods served by the router. All methods
+	// the router supports are served when empty.
+	Methods []string `json:",omitempty"`
+}
+
+// Method configures a routing method.
+type Method struct {
+	// Parallel queries all the routers serving the method at once, instead
+	// of one priority at a time.
+	Parallel Flag `json:",omitempty"`
+}
+
+const (
+	// RoutingTypeCustom builds routing from Routers only.
+	RoutingTypeCustom = "custom"
+
+	// RouterTypeHTTP is a delegated routing endpoint spoken to over HTTP.
+	RouterTypeHTTP = "http"
+
+	// RouterTypeDHT is the IPFS DHT.
+	RouterTypeDHT = "dht"
+
+	// DefaultRouterPriority places additional routers after the DHT.
+	DefaultRouterPriority = 2000
+
+	// DefaultDHTRouterPriority is the priority of the DHT.
+	DefaultDHTRouterPriority = 1000
+
+	// DefaultRouterTimeout bounds requests to additional routers.
+	DefaultRouterTimeout = 30 * time.Second
+)
+
+// Routing methods, as used in Router.Methods and Routing.Methods.
+const (
+	MethodFindProviders = "find-providers"
+	MethodProvide       = "provide"
+	MethodFindPeers     = "find-peers"
+	MethodGetIPNS       = "get-ipns"
+	MethodPutIPNS       = "put-ipns"
+)
+
+// RoutingMethods lists all routing methods.
+var RoutingMethods = []string{
+	MethodFindProviders,
+	MethodProvide,
+	MethodFindPeers,
+	MethodGetIPNS,
+	MethodPutIPNS,
+}
+
+// DHTRouter returns the enabled router of type "dht", if any. At most one
+// can be declared.
+func (r *Routing) DHTRouter() (string, *Router, error) {
+	var name string
+	var dht *Router
+	for n, router := range r.Routers {
+		if router.Type != RouterTypeDHT || !router.Enabled.WithDefault(true) {
+			continue
+		}
+		if dht != nil {
+			return "", nil, fmt.Errorf("Routing.Routers: only one dht router can be declared, found %q and %q", name, n)
+		}
+		router := router
+		name, dht = n, &router
+	}
+	return name, dht, nil
+}
+
+// AcceleratedDHTClient returns whether the DHT uses the experimental
+// accelerated client, either as set in Experimental or as a parameter of the
+// custom dht router.
+func (c *Config) AcceleratedDHTClient() bool {
+	if c.Experimental.AcceleratedDHTClient {
+		return true
+	}
+	if c.Routing.Type != RoutingTypeCustom {
+		return false
+	}
+	_, dht, err := c.Routing.DHTRouter()
+	return err == nil && dht != nil && dht.Parameters["AcceleratedDHTClient"] == "true"
 }
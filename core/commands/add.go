@@ -6,11 +6,15 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/urlstore"
 
 	"github.com/cheggaaa/pb"
+	humanize "github.com/dustin/go-humanize"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	files "github.com/ipfs/go-ipfs-files"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
@@ -23,28 +27,37 @@ var ErrDepthLimitExceeded = fmt.Errorf("depth limit exceeded")
 
 type AddEvent struct {
 	Name  string
-	Hash  string `json:",omitempty"`
-	Bytes int64  `json:",omitempty"`
-	Size  string `json:",omitempty"`
+	Hash  string                `json:",omitempty"`
+	Bytes int64                 `json:",omitempty"`
+	Size  string                `json:",omitempty"`
+	Dedup *coreunix.DedupReport `json:",omitempty"`
 }
 
 const (
-	quietOptionName       = "quiet"
-	quieterOptionName     = "quieter"
-	silentOptionName      = "silent"
-	progressOptionName    = "progress"
-	trickleOptionName     = "trickle"
-	wrapOptionName        = "wrap-with-directory"
-	onlyHashOptionName    = "only-hash"
-	chunkerOptionName     = "chunker"
-	pinOptionName         = "pin"
-	rawLeavesOptionName   = "raw-leaves"
-	noCopyOptionName      = "nocopy"
-	fstoreCacheOptionName = "fscache"
-	cidVersionOptionName  = "cid-version"
-	hashOptionName        = "hash"
-	inlineOptionName      = "inline"
-	inlineLimitOptionName = "inline-limit"
+	quietOptionName         = "quiet"
+	quieterOptionName       = "quieter"
+	silentOptionName        = "silent"
+	progressOptionName      = "progress"
+	trickleOptionName       = "trickle"
+	wrapOptionName          = "wrap-with-directory"
+	onlyHashOptionName      = "only-hash"
+	chunkerOptionName       = "chunker"
+	pinOptionName           = "pin"
+	rawLeavesOptionName     = "raw-leaves"
+	noCopyOptionName        = "nocopy"
+	fstoreCacheOptionName   = "fscache"
+	cidVersionOptionName    = "cid-version"
+	hashOptionName          = "hash"
+	inlineOptionName        = "inline"
+	inlineLimitOptionName   = "inline-limit"
+	resumeOptionName        = "resume"
+	preserveModeOptionName  = "preserve-mode"
+	preserveMtimeOptionName = "preserve-mtime"
+	dedupReportOptionName   = "dedup-report"
+	dedupLocalOptionName    = "dedup-local"
+	parallelOptionName      = "parallel"
+	ipfsignoreOptionName    = "ipfsignore"
+	dryRunOptionName        = "dry-run"
 )
 
 const adderOutChanSize = 8
@@ -107,6 +120,44 @@ You can now check what blocks have been created by:
   QmerURi9k4XzKCaaPbsK6BL5pMEjF7PGphjDvkkjDtsVf3 868
   QmQB28iwSriSUSMqG2nXDTLtdPHgWb4rebBrU7Q1j4vxPv 338
 
+With '--resume', the CID of every file added from the local filesystem is
+recorded in the repo. If the add is interrupted, running it again with
+'--resume' skips the files that were completed, as long as their size and
+modification time did not change, and rebuilds the directories from them.
+The records are removed when the add completes, and by 'ipfs repo gc', which
+removes the blocks of interrupted adds.
+
+'--preserve-mode' and '--preserve-mtime' record the permissions and the
+modification time of the files and directories in their UnixFS nodes, which
+'ipfs get' restores. This changes the hashes of the files. As only their
+content is sent to the daemon, they are only preserved when adding without a
+running daemon, and the add fails otherwise.
+
+'--dedup-report' hashes the files without writing them, like '--only-hash',
+and reports how many of their chunks are duplicates, to compare the chunkers
+on a dataset. '--dedup-local' also reports the chunks already in the repo.
+
+  > ipfs add -r --dedup-report --chunker=buzhash dataset
+  ...
+  Chunks: 1024 (120 duplicates)
+  Total: 250 MB
+  Unique: 221 MB (88.40%)
+
+'.ipfsignore' files hold gitignore-style rules excluding files from a
+recursive add, matched against the paths relative to their directory, in it
+and its subdirectories. They are read even when the hidden files are not
+added, and '--ipfsignore=false' disables them. '--dry-run' lists the files
+that would be added.
+
+  > cat dir/.ipfsignore
+  *.log
+  build/
+  > ipfs add -r --dry-run dir
+
+'--parallel' chunks and hashes several files at once, and splits the chunks
+of a file ahead of its hashing, to use more cores. The hashes and the output
+are the same as with a sequential add.
+
 Finally, a note on hash determinism. While not guaranteed, adding the same
 file/directory with the same flags will almost always result in the same output
 hash. However, almost all of the flags provided by this command (other than pin,
@@ -140,15 +191,31 @@ only-hash, and progress/status related flags) will change the final hash.
 		cmds.StringOption(hashOptionName, "Hash function to use. Implies CIDv1 if not sha2-256. (experimental)").WithDefault("sha2-256"),
 		cmds.BoolOption(inlineOptionName, "Inline small blocks into CIDs. (experimental)"),
 		cmds.IntOption(inlineLimitOptionName, "Maximum block size to inline. (experimental)").WithDefault(32),
+		cmds.BoolOption(resumeOptionName, "Record the files completed, and skip the ones completed by a previous interrupted add of the same files with the same options. (experimental)"),
+		cmds.BoolOption(preserveModeOptionName, "Record the permissions of the files in UnixFS. (experimental)"),
+		cmds.BoolOption(preserveMtimeOptionName, "Record the modification time of the files in UnixFS. (experimental)"),
+		cmds.BoolOption(dedupReportOptionName, "Report the duplicate chunks of the files. Implies --only-hash. (experimental)"),
+		cmds.BoolOption(dedupLocalOptionName, "With --dedup-report, also report the chunks already in the repo. (experimental)"),
+		cmds.IntOption(parallelOptionName, "Number of files chunked and hashed at once. (experimental)").WithDefault(1),
+		cmds.BoolOption(ipfsignoreOptionName, "Exclude the files matched by the .ipfsignore files of the directories.").WithDefault(true),
+		cmds.BoolOption(dryRunOptionName, "List the files that would be added, without adding them."),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		// the ignore files are applied while walking the local files, as
+		// the hidden ones are not sent to the daemon
+		ipfsignore, _ := req.Options[ipfsignoreOptionName].(bool)
+		if ipfsignore && req.Files != nil {
+			req.Files = coreunix.FilterIgnored(req.Files)
+		}
+
 		quiet, _ := req.Options[quietOptionName].(bool)
 		quieter, _ := req.Options[quieterOptionName].(bool)
 		quiet = quiet || quieter
 
 		silent, _ := req.Options[silentOptionName].(bool)
+		dryRun, _ := req.Options[dryRunOptionName].(bool)
 
-		if quiet || silent {
+		if quiet || silent || dryRun {
 			return nil
 		}
 
@@ -180,6 +247,14 @@ only-hash, and progress/status related flags) will change the final hash.
 		hashFunStr, _ := req.Options[hashOptionName].(string)
 		inline, _ := req.Options[inlineOptionName].(bool)
 		inlineLimit, _ := req.Options[inlineLimitOptionName].(int)
+		resume, _ := req.Options[resumeOptionName].(bool)
+		preserveMode, _ := req.Options[preserveModeOptionName].(bool)
+		preserveMtime, _ := req.Options[preserveMtimeOptionName].(bool)
+		dedupReport, _ := req.Options[dedupReportOptionName].(bool)
+		dedupLocal, _ := req.Options[dedupLocalOptionName].(bool)
+		parallel, _ := req.Options[parallelOptionName].(int)
+		ipfsignore, _ := req.Options[ipfsignoreOptionName].(bool)
+		dryRun, _ := req.Options[dryRunOptionName].(bool)
 
 		hashFunCode, ok := mh.Names[strings.ToLower(hashFunStr)]
 		if !ok {
@@ -191,10 +266,48 @@ only-hash, and progress/status related flags) will change the final hash.
 			return err
 		}
 
+		if dedupLocal && !dedupReport {
+			return fmt.Errorf("--%s requires --%s", dedupLocalOptionName, dedupReportOptionName)
+		}
+		if dedupReport {
+			hash = true
+		}
+		if parallel < 1 {
+			return fmt.Errorf("--%s must be at least 1", parallelOptionName)
+		}
+
 		toadd := req.Files
+		if ipfsignore {
+			toadd = coreunix.FilterIgnored(toadd)
+		}
+		if dryRun {
+			return files.Walk(toadd, func(fpath string, nd files.Node) error {
+				if f, ok := nd.(files.File); ok {
+					f.Close()
+				}
+				if fpath == "" {
+					return nil
+				}
+				return res.Emit(&AddEvent{Name: filepath.ToSlash(fpath)})
+			})
+		}
+		if nocopy {
+			n, err := cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			if n.Filestore != nil && n.Filestore.FileManager().AllowUrls {
+				// the origins of the URLs are recorded before they are read
+				store, err := urlStore(n)
+				if err != nil {
+					return err
+				}
+				toadd = urlstore.RecordOrigins(req.Context, store, toadd)
+			}
+		}
 		if wrap {
 			toadd = files.NewSliceDirectory([]files.DirEntry{
-				files.FileEntry("", req.Files),
+				files.FileEntry("", toadd),
 			})
 		}
 
@@ -229,6 +342,26 @@ only-hash, and progress/status related flags) will change the final hash.
 
 		opts = append(opts, nil) // events option placeholder
 
+		ctx := req.Context
+		if resume {
+			ctx = coreunix.WithResume(ctx)
+		}
+		if preserveMode || preserveMtime {
+			ctx = coreunix.WithPreserve(ctx, coreunix.Preserve{
+				Mode:    preserveMode,
+				Mtime:   preserveMtime,
+				Wrapped: wrap,
+			})
+		}
+		if parallel > 1 {
+			ctx = coreunix.WithConcurrency(ctx, parallel)
+		}
+		var report *coreunix.DedupReport
+		if dedupReport {
+			report = new(coreunix.DedupReport)
+			ctx = coreunix.WithDedupReport(ctx, report, dedupLocal)
+		}
+
 		var added int
 		addit := toadd.Entries()
 		for addit.Next() {
@@ -240,7 +373,7 @@ only-hash, and progress/status related flags) will change the final hash.
 			go func() {
 				var err error
 				defer close(events)
-				_, err = api.Unixfs().Add(req.Context, addit.Node(), opts...)
+				_, err = api.Unixfs().Add(ctx, addit.Node(), opts...)
 				errCh <- err
 			}()
 
@@ -285,6 +418,9 @@ only-hash, and progress/status related flags) will change the final hash.
 			return fmt.Errorf("expected a file argument")
 		}
 
+		if report != nil {
+			return res.Emit(&AddEvent{Dedup: report})
+		}
 		return nil
 	},
 	PostRun: cmds.PostRunMap{
@@ -313,6 +449,7 @@ only-hash, and progress/status related flags) will change the final hash.
 				quiet = quiet || quieter
 
 				progress, _ := req.Options[progressOptionName].(bool)
+				dryRun, _ := req.Options[dryRunOptionName].(bool)
 
 				var bar *pb.ProgressBar
 				if progress {
@@ -340,6 +477,17 @@ only-hash, and progress/status related flags) will change the final hash.
 							break LOOP
 						}
 						output := out.(*AddEvent)
+						if output.Dedup != nil {
+							if progress {
+								fmt.Fprintf(os.Stderr, "\033[2K\r")
+							}
+							printDedupReport(os.Stdout, output.Dedup)
+							continue
+						}
+						if dryRun {
+							fmt.Fprintln(os.Stdout, cmdenv.EscNonPrint(output.Name))
+							continue
+						}
 						if len(output.Hash) > 0 {
 							lastHash = output.Hash
 							if quieter {
@@ -429,3 +577,16 @@ only-hash, and progress/status related flags) will change the final hash.
 	},
 	Type: AddEvent{},
 }
+
+func printDedupReport(w io.Writer, r *coreunix.DedupReport) {
+	fmt.Fprintf(w, "Chunks: %d (%d duplicates)\n", r.Chunks, r.DuplicateChunks)
+	fmt.Fprintf(w, "Total: %s\n", humanize.Bytes(r.TotalBytes))
+	var ratio float64
+	if r.TotalBytes > 0 {
+		ratio = 100 * float64(r.UniqueBytes) / float64(r.TotalBytes)
+	}
+	fmt.Fprintf(w, "Unique: %s (%.2f%%)\n", humanize.Bytes(r.UniqueBytes), ratio)
+	if r.LocalChunks > 0 {
+		fmt.Fprintf(w, "In repo: %s in %d chunks\n", humanize.Bytes(r.LocalBytes), r.LocalChunks)
+	}
+}
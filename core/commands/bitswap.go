@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 
+	ibitswap "github.com/ipfs/go-ipfs/bitswap"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
 
@@ -53,8 +54,8 @@ Print out all blocks currently on the bitswap wantlist for the local peer.`,
 			return ErrNotOnline
 		}
 
-		bs, ok := nd.Exchange.(*bitswap.Bitswap)
-		if !ok {
+		bs := ibitswap.Unwrap(nd.Exchange)
+		if bs == nil {
 			return e.TypeErr(bs, nd.Exchange)
 		}
 
@@ -92,6 +93,13 @@ const (
 	bitswapHumanOptionName   = "human"
 )
 
+// bitswapStat is bitswap.Stat with the mode and server policy.
+type bitswapStat struct {
+	*bitswap.Stat
+	Mode   string
+	Policy *ibitswap.PolicyStat `json:",omitempty"`
+}
+
 var bitswapStatCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Show some diagnostic information on the bitswap agent.",
@@ -101,7 +109,7 @@ var bitswapStatCmd = &cmds.Command{
 		cmds.BoolOption(bitswapVerboseOptionName, "v", "Print extra information"),
 		cmds.BoolOption(bitswapHumanOptionName, "Print sizes in human readable format (e.g., 1K 234M 2G)"),
 	},
-	Type: bitswap.Stat{},
+	Type: bitswapStat{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
 		if err != nil {
@@ -112,8 +120,8 @@ var bitswapStatCmd = &cmds.Command{
 			return cmds.Errorf(cmds.ErrClient, ErrNotOnline.Error())
 		}
 
-		bs, ok := nd.Exchange.(*bitswap.Bitswap)
-		if !ok {
+		bs := ibitswap.Unwrap(nd.Exchange)
+		if bs == nil {
 			return e.TypeErr(bs, nd.Exchange)
 		}
 
@@ -122,10 +130,14 @@ var bitswapStatCmd = &cmds.Command{
 			return err
 		}
 
-		return cmds.EmitOnce(res, st)
+		out := &bitswapStat{Stat: st, Mode: ibitswap.Mode(nd.Exchange, nd.BitswapPolicy)}
+		if nd.BitswapPolicy != nil {
+			out.Policy = nd.BitswapPolicy.Stat()
+		}
+		return cmds.EmitOnce(res, out)
 	},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *bitswap.Stat) error {
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *bitswapStat) error {
 			enc, err := cmdenv.GetLowLevelCidEncoder(req)
 			if err != nil {
 				return err
@@ -134,6 +146,7 @@ var bitswapStatCmd = &cmds.Command{
 			human, _ := req.Options[bitswapHumanOptionName].(bool)
 
 			fmt.Fprintln(w, "bitswap status")
+			fmt.Fprintf(w, "\tmode: %s\n", s.Mode)
 			fmt.Fprintf(w, "\tprovides buffer: %d / %d\n", s.ProvideBufLen, bitswap.HasBlockBufferSize)
 			fmt.Fprintf(w, "\tblocks received: %d\n", s.BlocksReceived)
 			fmt.Fprintf(w, "\tblocks sent: %d\n", s.BlocksSent)
@@ -162,11 +175,57 @@ var bitswapStatCmd = &cmds.Command{
 				}
 			}
 
+			if p := s.Policy; verbose && p != nil {
+				fmt.Fprintln(w, "\tserver policy")
+				if len(p.AllowList) > 0 {
+					fmt.Fprintf(w, "\t\tserve allow list [%d]\n", len(p.AllowList))
+					for _, pid := range p.AllowList {
+						fmt.Fprintf(w, "\t\t\t%s\n", pid)
+					}
+				} else {
+					fmt.Fprintln(w, "\t\tserve allow list: all peers")
+				}
+				fmt.Fprintf(w, "\t\tserve deny list [%d]\n", len(p.DenyList))
+				for _, pid := range p.DenyList {
+					fmt.Fprintf(w, "\t\t\t%s\n", pid)
+				}
+				fmt.Fprintf(w, "\t\tmax bandwidth per peer: %s\n", bandwidthLimit(p.MaxBytesPerSecondPerPeer, human))
+				fmt.Fprintf(w, "\t\tmax wantlist size per peer: %s\n", countLimit(p.MaxWantlistSize))
+				fmt.Fprintf(w, "\t\tdenied wants: %d\n", p.DeniedWants)
+				fmt.Fprintf(w, "\t\trejected wants: %d\n", p.RejectedWants)
+				fmt.Fprintf(w, "\t\tdropped messages: %d\n", p.DroppedMessages)
+				fmt.Fprintf(w, "\t\tthrottled: %s\n", p.Throttled)
+			}
+
 			return nil
 		}),
 	},
 }
 
+// ledger is a bitswap ledger with the server policy applied to the peer.
+type ledger struct {
+	*decision.Receipt
+	Policy *ibitswap.PeerPolicyStat `json:",omitempty"`
+}
+
+func bandwidthLimit(bytesPerSecond int64, human bool) string {
+	switch {
+	case bytesPerSecond <= 0:
+		return "unlimited"
+	case human:
+		return humanize.Bytes(uint64(bytesPerSecond)) + "/s"
+	default:
+		return fmt.Sprintf("%d B/s", bytesPerSecond)
+	}
+}
+
+func countLimit(n int) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(n)
+}
+
 var ledgerCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Show the current ledger for a peer.",
@@ -179,7 +238,7 @@ prints the ledger associated with a given peer.
 	Arguments: []cmds.Argument{
 		cmds.StringArg("peer", true, false, "The PeerID (B58) of the ledger to inspect."),
 	},
-	Type: decision.Receipt{},
+	Type: ledger{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
 		if err != nil {
@@ -190,8 +249,8 @@ prints the ledger associated with a given peer.
 			return ErrNotOnline
 		}
 
-		bs, ok := nd.Exchange.(*bitswap.Bitswap)
-		if !ok {
+		bs := ibitswap.Unwrap(nd.Exchange)
+		if bs == nil {
 			return e.TypeErr(bs, nd.Exchange)
 		}
 
@@ -200,17 +259,34 @@ prints the ledger associated with a given peer.
 			return err
 		}
 
-		return cmds.EmitOnce(res, bs.LedgerForPeer(partner))
+		out := &ledger{Receipt: bs.LedgerForPeer(partner)}
+		if nd.BitswapPolicy != nil {
+			out.Policy = nd.BitswapPolicy.PeerStat(partner)
+		}
+		return cmds.EmitOnce(res, out)
 	},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *decision.Receipt) error {
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *ledger) error {
 			fmt.Fprintf(w, "Ledger for %s\n"+
 				"Debt ratio:\t%f\n"+
 				"Exchanges:\t%d\n"+
 				"Bytes sent:\t%d\n"+
-				"Bytes received:\t%d\n\n",
+				"Bytes received:\t%d\n",
 				out.Peer, out.Value, out.Exchanged,
 				out.Sent, out.Recv)
+			if p := out.Policy; p != nil {
+				fmt.Fprintf(w, "Served:\t%t\n"+
+					"Max bandwidth:\t%s\n"+
+					"Wantlist size:\t%d / %s\n"+
+					"Denied wants:\t%d\n"+
+					"Rejected wants:\t%d\n"+
+					"Dropped messages:\t%d\n"+
+					"Throttled:\t%s\n",
+					p.Served, bandwidthLimit(p.MaxBytesPerSecondPerPeer, false),
+					p.Wants, countLimit(p.MaxWantlistSize),
+					p.DeniedWants, p.RejectedWants, p.DroppedMessages, p.Throttled)
+			}
+			fmt.Fprintln(w)
 			return nil
 		}),
 	},
@@ -0,0 +1,59 @@
+package cmdenv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/go-ipfs/fetch"
+)
+
+// FetchOptionName is the name of the option selecting how DAGs are fetched.
+const FetchOptionName = "fetch"
+
+// FetchOption selects how the commands working on whole DAGs fetch them.
+var FetchOption = cmds.StringOption(FetchOptionName, "How to fetch missing blocks: 'bitswap', or 'graphsync' to fetch the whole DAG from a provider first, falling back to bitswap. Requires Experimental.GraphsyncEnabled for graphsync.").WithDefault(fetch.Bitswap)
+
+// Prefetcher fetches the DAG under a root before a command walks it.
+type Prefetcher func(ctx context.Context, root cid.Cid) error
+
+// GetPrefetcher returns the Prefetcher selected by the fetch option. It
+// never fails the command when fetching fails: the blocks still missing are
+// fetched with bitswap as usual.
+func GetPrefetcher(req *cmds.Request, env cmds.Environment) (Prefetcher, error) {
+	method, _ := req.Options[FetchOptionName].(string)
+	switch method {
+	case "", fetch.Bitswap:
+		return func(context.Context, cid.Cid) error { return nil }, nil
+	case fetch.Graphsync:
+	default:
+		return nil, cmds.Errorf(cmds.ErrClient, "unknown fetch method %q, expected %q or %q", method, fetch.Bitswap, fetch.Graphsync)
+	}
+
+	nd, err := GetNode(env)
+	if err != nil {
+		return nil, err
+	}
+	if offline, _ := req.Options["offline"].(bool); offline || !nd.IsOnline {
+		return func(context.Context, cid.Cid) error { return nil }, nil
+	}
+	if nd.GraphExchange == nil {
+		return nil, fmt.Errorf("--%s=%s requires Experimental.GraphsyncEnabled", FetchOptionName, fetch.Graphsync)
+	}
+
+	f := &fetch.GraphsyncFetcher{
+		Exchange: nd.GraphExchange,
+		Host:     nd.PeerHost,
+		Routing:  nd.Routing,
+	}
+	return func(ctx context.Context, root cid.Cid) error {
+		err := f.DAG(ctx, root, nil)
+		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			log.Warnf("graphsync fetch of %s failed, falling back to bitswap: %s", root, err)
+			return nil
+		}
+		return err
+	}, nil
+}
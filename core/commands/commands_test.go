@@ -130,6 +130,7 @@ func TestCommands(t *testing.T) {
 		"/file/ls",
 		"/files",
 		"/files/chcid",
+		"/files/chmod",
 		"/files/cp",
 		"/files/flush",
 		"/files/ls",
@@ -138,10 +139,14 @@ func TestCommands(t *testing.T) {
 		"/files/read",
 		"/files/rm",
 		"/files/stat",
+		"/files/sync",
+		"/files/touch",
 		"/files/write",
 		"/filestore",
 		"/filestore/dups",
+		"/filestore/gc",
 		"/filestore/ls",
+		"/filestore/relocate",
 		"/filestore/verify",
 		"/get",
 		"/id",
@@ -221,6 +226,13 @@ func TestCommands(t *testing.T) {
 		"/repo/verify",
 		"/repo/version",
 		"/resolve",
+		"/routing",
+		"/routing/findpeer",
+		"/routing/findprovs",
+		"/routing/get",
+		"/routing/provide",
+		"/routing/put",
+		"/routing/reprovide",
 		"/shutdown",
 		"/stats",
 		"/stats/bitswap",
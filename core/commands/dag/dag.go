@@ -269,6 +269,7 @@ The output of blocks happens in strict DAG-traversal, first-seen, order.
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(progressOptionName, "p", "Display progress on CLI. Defaults to true when STDERR is a TTY."),
+		cmdenv.FetchOption,
 	},
 	Run: dagExport,
 	PostRun: cmds.PostRunMap{
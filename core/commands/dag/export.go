@@ -34,6 +34,14 @@ func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	prefetch, err := cmdenv.GetPrefetcher(req, env)
+	if err != nil {
+		return err
+	}
+	if err := prefetch(req.Context, c); err != nil {
+		return err
+	}
+
 	pipeR, pipeW := io.Pipe()
 
 	errCh := make(chan error, 2) // we only report the 1st error
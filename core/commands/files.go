@@ -7,12 +7,17 @@ import (
 	"io"
 	"os"
 	gopath "path"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coresync"
+	"github.com/ipfs/go-ipfs/core/coreunix/metadata"
 
 	bservice "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
@@ -80,6 +85,9 @@ operations.
 		"rm":    filesRmCmd,
 		"flush": filesFlushCmd,
 		"chcid": filesChcidCmd,
+		"chmod": filesChmodCmd,
+		"touch": filesTouchCmd,
+		"sync":  filesSyncCmd,
 	},
 }
 
@@ -99,6 +107,8 @@ type statOutput struct {
 	CumulativeSize uint64
 	Blocks         int
 	Type           string
+	Mode           string `json:",omitempty"`
+	Mtime          string `json:",omitempty"`
 	WithLocality   bool   `json:",omitempty"`
 	Local          bool   `json:",omitempty"`
 	SizeLocal      uint64 `json:",omitempty"`
@@ -125,7 +135,7 @@ var filesStatCmd = &cmds.Command{
 	},
 	Options: []cmds.Option{
 		cmds.StringOption(filesFormatOptionName, "Print statistics in given format. Allowed tokens: "+
-			"<hash> <size> <cumulsize> <type> <childs>. Conflicts with other format options.").WithDefault(defaultStatFormat),
+			"<hash> <size> <cumulsize> <type> <childs> <mode> <mtime>. Conflicts with other format options.").WithDefault(defaultStatFormat),
 		cmds.BoolOption(filesHashOptionName, "Print only hash. Implies '--format=<hash>'. Conflicts with other format options."),
 		cmds.BoolOption(filesSizeOptionName, "Print only size. Implies '--format=<cumulsize>'. Conflicts with other format options."),
 		cmds.BoolOption(filesWithLocalOptionName, "Compute the amount of the dag that is local, and if possible the total size"),
@@ -198,14 +208,26 @@ var filesStatCmd = &cmds.Command{
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *statOutput) error {
 			s, _ := statGetFormatOptions(req)
+			defaultFormat := s == defaultStatFormat
 			s = strings.Replace(s, "<hash>", out.Hash, -1)
 			s = strings.Replace(s, "<size>", fmt.Sprintf("%d", out.Size), -1)
 			s = strings.Replace(s, "<cumulsize>", fmt.Sprintf("%d", out.CumulativeSize), -1)
 			s = strings.Replace(s, "<childs>", fmt.Sprintf("%d", out.Blocks), -1)
 			s = strings.Replace(s, "<type>", out.Type, -1)
+			s = strings.Replace(s, "<mode>", orDash(out.Mode), -1)
+			s = strings.Replace(s, "<mtime>", orDash(out.Mtime), -1)
 
 			fmt.Fprintln(w, s)
 
+			if defaultFormat {
+				if out.Mode != "" {
+					fmt.Fprintf(w, "Mode: %s\n", out.Mode)
+				}
+				if out.Mtime != "" {
+					fmt.Fprintf(w, "Mtime: %s\n", out.Mtime)
+				}
+			}
+
 			if out.WithLocality {
 				fmt.Fprintf(w, "Local: %s of %s (%.2f%%)\n",
 					humanize.Bytes(out.SizeLocal),
@@ -268,12 +290,20 @@ func statNode(nd ipld.Node, enc cidenc.Encoder) (*statOutput, error) {
 			return nil, fmt.Errorf("unrecognized node type: %s", d.Type())
 		}
 
+		md, err := metadata.Read(n)
+		if err != nil {
+			return nil, err
+		}
+		mode, mtime := formatMetadata(md)
+
 		return &statOutput{
 			Hash:           enc.Encode(c),
 			Blocks:         len(nd.Links()),
 			Size:           d.FileSize(),
 			CumulativeSize: cumulsize,
 			Type:           ndtype,
+			Mode:           mode,
+			Mtime:          mtime,
 		}, nil
 	case *dag.RawNode:
 		return &statOutput{
@@ -288,6 +318,18 @@ func statNode(nd ipld.Node, enc cidenc.Encoder) (*statOutput, error) {
 	}
 }
 
+// formatMetadata returns the mode and the modification time of md as
+// output by the commands, empty when they are not set.
+func formatMetadata(md metadata.FileMetadata) (mode, mtime string) {
+	if md.HasMode {
+		mode = fmt.Sprintf("%04o", metadata.PosixMode(md.Mode))
+	}
+	if !md.ModTime.IsZero() {
+		mtime = md.ModTime.UTC().Format(time.RFC3339Nano)
+	}
+	return mode, mtime
+}
+
 func walkBlock(ctx context.Context, dagserv ipld.DAGService, nd ipld.Node) (bool, uint64, error) {
 	// Start with the block data size
 	sizeLocal := uint64(len(nd.RawData()))
@@ -957,6 +999,143 @@ are run with the '--flush=false'.
 	Type: flushRes{},
 }
 
+const (
+	filesSyncWatchOptionName  = "watch"
+	filesSyncKeyOptionName    = "key"
+	filesSyncNocopyOptionName = "nocopy"
+	filesSyncHiddenOptionName = "hidden"
+	filesSyncDelayOptionName  = "delay"
+)
+
+type syncRes struct {
+	Cid   string
+	Error string `json:",omitempty"`
+}
+
+var filesSyncCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Mirror a local directory into MFS.",
+		ShortDescription: `
+Add the local directory <local-dir> of the daemon, and replace <mfs-path> with
+it. The files ignored by the .ipfsignore files of the directory are not added,
+nor the hidden ones unless '--hidden' is set.
+
+With '--watch', the command keeps running, and the files created, changed,
+renamed and removed in the directory are mirrored into <mfs-path> once no
+change happened for '--delay'. The new root is printed after every change.
+
+With '--key', the root is published to the IPNS name of the key after every
+change.
+
+    $ ipfs files sync --watch --key=site ./site /site
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("local-dir", true, false, "Local directory to mirror."),
+		cmds.StringArg("mfs-path", true, false, "MFS path to mirror it to."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(filesSyncWatchOptionName, "w", "Keep mirroring the changes of the directory."),
+		cmds.StringOption(filesSyncKeyOptionName, "k", "Name of the key to publish the root to IPNS with."),
+		cmds.BoolOption(filesSyncNocopyOptionName, "Add the files with the filestore. (experimental)"),
+		cmds.BoolOption(filesSyncHiddenOptionName, "H", "Include files that are hidden."),
+		cmds.StringOption(filesSyncDelayOptionName, "How long the changes have to settle before they are mirrored.").WithDefault("1s"),
+	},
+	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		// the path is relative to the working directory of the client
+		abs, err := filepath.Abs(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		req.Arguments[0] = abs
+		return nil
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		mfsPath, err := checkPath(req.Arguments[1])
+		if err != nil {
+			return err
+		}
+		if mfsPath == "/" {
+			return fmt.Errorf("cannot replace the root")
+		}
+
+		watch, _ := req.Options[filesSyncWatchOptionName].(bool)
+		nocopy, _ := req.Options[filesSyncNocopyOptionName].(bool)
+		hidden, _ := req.Options[filesSyncHiddenOptionName].(bool)
+		key, _ := req.Options[filesSyncKeyOptionName].(string)
+		delayStr, _ := req.Options[filesSyncDelayOptionName].(string)
+		delay, err := time.ParseDuration(delayStr)
+		if err != nil {
+			return err
+		}
+		if delay <= 0 {
+			return fmt.Errorf("the delay must be positive")
+		}
+
+		opts := coresync.Options{
+			MFSPath: mfsPath,
+			IPNSKey: key,
+			Nocopy:  nocopy,
+			Hidden:  hidden,
+			Delay:   delay,
+		}
+		if nocopy {
+			if opts.FilestoreRoot, err = filestoreRoot(env); err != nil {
+				return err
+			}
+		}
+		s, err := coresync.New(nd, req.Arguments[0], opts)
+		if err != nil {
+			return err
+		}
+
+		root, err := s.Sync(req.Context)
+		if err != nil {
+			return err
+		}
+		if err := res.Emit(&syncRes{Cid: enc.Encode(root.Cid())}); err != nil || !watch {
+			return err
+		}
+
+		var emitErr error
+		err = s.Watch(req.Context, func(root ipld.Node, err error) {
+			if emitErr != nil {
+				return
+			}
+			if err != nil {
+				emitErr = res.Emit(&syncRes{Error: err.Error()})
+				return
+			}
+			emitErr = res.Emit(&syncRes{Cid: enc.Encode(root.Cid())})
+		})
+		if err != nil {
+			return err
+		}
+		return emitErr
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *syncRes) error {
+			if out.Error != "" {
+				_, err := fmt.Fprintf(w, "error: %s\n", out.Error)
+				return err
+			}
+			_, err := fmt.Fprintln(w, out.Cid)
+			return err
+		}),
+	},
+	Type: syncRes{},
+}
+
 var filesChcidCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Change the CID version or hash function of the root node of a given path.",
@@ -1017,6 +1196,131 @@ func updatePath(rt *mfs.Root, pth string, builder cid.Builder) error {
 	return nil
 }
 
+var filesChmodCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Change the mode of a file or directory.",
+		ShortDescription: `
+Record the given octal mode in the UnixFS node of the file or directory,
+which 'ipfs get' restores.
+
+    $ ipfs files chmod 0755 /bin/script.sh
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("mode", true, false, "Octal mode, e.g. 0644."),
+		cmds.StringArg("path", true, false, "Path to change."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		mode, err := strconv.ParseUint(req.Arguments[0], 8, 32)
+		if err != nil || mode > 07777 {
+			return fmt.Errorf("invalid mode %q", req.Arguments[0])
+		}
+		path, err := checkPath(req.Arguments[1])
+		if err != nil {
+			return err
+		}
+
+		flush, _ := req.Options[filesFlushOptionName].(bool)
+
+		err = updateMetadata(nd.FilesRoot, path, func(md *metadata.FileMetadata) {
+			md.Mode = metadata.FileModeFromPosix(uint32(mode))
+			md.HasMode = true
+		})
+		if err == nil && flush {
+			_, err = mfs.FlushPath(req.Context, nd.FilesRoot, path)
+		}
+		return err
+	},
+}
+
+const filesMtimeOptionName = "mtime"
+
+var filesTouchCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Change the modification time of a file or directory.",
+		ShortDescription: `
+Record the current time, or the one given with '--mtime' in seconds since the
+Unix epoch, in the UnixFS node of the file or directory, which 'ipfs get'
+restores.
+
+    $ ipfs files touch --mtime=1600000000 /notes.txt
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "Path to change."),
+	},
+	Options: []cmds.Option{
+		cmds.Int64Option(filesMtimeOptionName, "m", "Modification time in seconds since the Unix epoch. Default: now."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		path, err := checkPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		mtime := time.Now()
+		if sec, ok := req.Options[filesMtimeOptionName].(int64); ok {
+			mtime = time.Unix(sec, 0)
+		}
+		flush, _ := req.Options[filesFlushOptionName].(bool)
+
+		err = updateMetadata(nd.FilesRoot, path, func(md *metadata.FileMetadata) {
+			md.ModTime = mtime
+		})
+		if err == nil && flush {
+			_, err = mfs.FlushPath(req.Context, nd.FilesRoot, path)
+		}
+		return err
+	},
+}
+
+// updateMetadata replaces the node at pth with a copy whose metadata was
+// changed by update.
+func updateMetadata(rt *mfs.Root, pth string, update func(*metadata.FileMetadata)) error {
+	pth = strings.TrimRight(pth, "/")
+	if pth == "" {
+		return fmt.Errorf("cannot change the metadata of the root")
+	}
+
+	dir, name := gopath.Split(pth)
+	pdir, err := getParentDir(rt, dir)
+	if err != nil {
+		return err
+	}
+	child, err := pdir.Child(name)
+	if err != nil {
+		return err
+	}
+	nd, err := child.GetNode()
+	if err != nil {
+		return err
+	}
+
+	md, err := metadata.Read(nd)
+	if err != nil {
+		return err
+	}
+	update(&md)
+	if nd, err = metadata.Set(nd, md); err != nil {
+		return err
+	}
+
+	if err := pdir.Unlink(name); err != nil {
+		return err
+	}
+	return pdir.AddChild(name, nd)
+}
+
 var filesRmCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Remove a file.",
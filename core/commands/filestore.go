@@ -5,14 +5,20 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	filestore "github.com/ipfs/go-filestore"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	"github.com/ipfs/go-ipfs/urlstore"
 
 	"github.com/ipfs/go-cid"
+	posinfo "github.com/ipfs/go-ipfs-posinfo"
+	dag "github.com/ipfs/go-merkledag"
 )
 
 var FileStoreCmd = &cmds.Command{
@@ -20,14 +26,17 @@ var FileStoreCmd = &cmds.Command{
 		Tagline: "Interact with filestore objects.",
 	},
 	Subcommands: map[string]*cmds.Command{
-		"ls":     lsFileStore,
-		"verify": verifyFileStore,
-		"dups":   dupsFileStore,
+		"ls":       lsFileStore,
+		"verify":   verifyFileStore,
+		"dups":     dupsFileStore,
+		"gc":       gcFileStore,
+		"relocate": relocateFileStore,
 	},
 }
 
 const (
-	fileOrderOptionName = "file-order"
+	fileOrderOptionName    = "file-order"
+	unpinnedOnlyOptionName = "unpinned-only"
 )
 
 var lsFileStore = &cmds.Command{
@@ -57,7 +66,7 @@ The output is:
 		}
 		args := req.Arguments
 		if len(args) > 0 {
-			return listByArgs(req.Context, res, fs, args)
+			return listByArgs(req.Context, res, fs, args, nil)
 		}
 
 		fileOrder, _ := req.Options[fileOrderOptionName].(bool)
@@ -119,6 +128,10 @@ missing:  <obj> could not be found in the filestore
 ERROR:    internal error, most likely due to a corrupt database
 
 For ERROR entries the error will also be printed to stderr.
+
+The objects backed by a URL are also 'changed' when its ETag or Last-Modified
+header is not the one seen when it was added, and are read with the headers
+they were added with.
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -128,13 +141,18 @@ For ERROR entries the error will also be printed to stderr.
 		cmds.BoolOption(fileOrderOptionName, "verify the objects based on the order of the backing file"),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
-		_, fs, err := getFilestore(env)
+		n, fs, err := getFilestore(env)
+		if err != nil {
+			return err
+		}
+		store, err := urlStore(n)
 		if err != nil {
 			return err
 		}
+		v := urlstore.NewVerifier(store)
 		args := req.Arguments
 		if len(args) > 0 {
-			return listByArgs(req.Context, res, fs, args)
+			return listByArgs(req.Context, res, fs, args, v)
 		}
 
 		fileOrder, _ := req.Options[fileOrderOptionName].(bool)
@@ -148,7 +166,7 @@ For ERROR entries the error will also be printed to stderr.
 			if r == nil {
 				break
 			}
-			if err := res.Emit(r); err != nil {
+			if err := res.Emit(v.Verify(req.Context, r)); err != nil {
 				return err
 			}
 		}
@@ -224,6 +242,225 @@ var dupsFileStore = &cmds.Command{
 	Type:     RefWrapper{},
 }
 
+var gcFileStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove the filestore objects whose backing file changed or vanished.",
+		LongDescription: `
+Remove the objects of the filestore that 'ipfs filestore verify' reports as
+'changed' or 'no-file', as their blocks can no longer be read. The objects
+whose backing file could not be read for another reason are kept.
+
+With --unpinned-only, the objects that are pinned, directly or as part of a
+pinned DAG, are kept too.
+
+The output is:
+
+removed <status> <hash> <size> <path> <offset>
+`,
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(unpinnedOnlyOptionName, "Only remove the objects that are not pinned."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, fs, err := getFilestore(env)
+		if err != nil {
+			return err
+		}
+		unpinnedOnly, _ := req.Options[unpinnedOnlyOptionName].(bool)
+
+		// no add may reference the blocks being removed
+		defer n.GCLocker.GCLock(req.Context).Unlock(req.Context)
+
+		next, err := filestore.VerifyAll(req.Context, fs, false)
+		if err != nil {
+			return err
+		}
+		var invalid []*filestore.ListRes
+		for {
+			r := next(req.Context)
+			if r == nil {
+				break
+			}
+			if r.Status == filestore.StatusFileNotFound || r.Status == filestore.StatusFileChanged {
+				invalid = append(invalid, r)
+			}
+		}
+
+		if unpinnedOnly && len(invalid) > 0 {
+			cids := make([]cid.Cid, len(invalid))
+			for i, r := range invalid {
+				cids[i] = r.Key
+			}
+			pinned, err := n.Pinning.CheckIfPinned(req.Context, cids...)
+			if err != nil {
+				return err
+			}
+			unpinned := invalid[:0]
+			for i, p := range pinned {
+				if !p.Pinned() {
+					unpinned = append(unpinned, invalid[i])
+				}
+			}
+			invalid = unpinned
+		}
+
+		for _, r := range invalid {
+			if err := fs.FileManager().DeleteBlock(req.Context, r.Key); err != nil {
+				return err
+			}
+			if err := res.Emit(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			enc, err := cmdenv.GetCidEncoder(res.Request())
+			if err != nil {
+				return err
+			}
+			return streamResult(func(v interface{}, out io.Writer) nonFatalError {
+				r := v.(*filestore.ListRes)
+				fmt.Fprintf(out, "removed %s %s\n", r.Status.Format(), r.FormatLong(enc.Encode))
+				return ""
+			})(res, re)
+		},
+	},
+	Type: filestore.ListRes{},
+}
+
+var relocateFileStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Update the backing files of filestore objects after they moved.",
+		LongDescription: `
+Rewrite the backing path of the filestore objects whose file is under
+<old-prefix> to be under <new-prefix>, when a dataset directory was moved.
+The data of every object is read from its new location and checked against
+its hash before it is relocated; the objects that do not match keep their
+old path.
+
+The output is the one of 'ipfs filestore verify', with the new path of the
+objects:
+
+<status> <hash> <size> <path> <offset>
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("old-prefix", true, false, "Former path of the backing files."),
+		cmds.StringArg("new-prefix", true, false, "Current path of the backing files."),
+	},
+	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		// the paths are relative to the working directory of the client
+		for i, arg := range req.Arguments {
+			abs, err := filepath.Abs(arg)
+			if err != nil {
+				return err
+			}
+			req.Arguments[i] = abs
+		}
+		return nil
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		_, fs, err := getFilestore(env)
+		if err != nil {
+			return err
+		}
+		root, err := filestoreRoot(env)
+		if err != nil {
+			return err
+		}
+		var prefixes [2]string
+		for i, arg := range req.Arguments {
+			rel, err := filepath.Rel(root, arg)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("%s is not a path under the filestore root %s", arg, root)
+			}
+			prefixes[i] = filepath.ToSlash(rel)
+		}
+		oldPrefix, newPrefix := prefixes[0], prefixes[1]
+
+		next, err := filestore.ListAll(req.Context, fs, true)
+		if err != nil {
+			return err
+		}
+		for {
+			r := next(req.Context)
+			if r == nil {
+				break
+			}
+			if r.Status != filestore.StatusOk || filestore.IsURL(r.FilePath) {
+				continue
+			}
+			if r.FilePath != oldPrefix && !strings.HasPrefix(r.FilePath, oldPrefix+"/") {
+				continue
+			}
+
+			r.FilePath = newPrefix + strings.TrimPrefix(r.FilePath, oldPrefix)
+			if err := relocateFilestoreObject(req.Context, fs, root, r); err != nil {
+				return err
+			}
+			if err := res.Emit(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	PostRun: verifyFileStore.PostRun,
+	Type:    filestore.ListRes{},
+}
+
+// relocateFilestoreObject points the object of r to its data at r.FilePath,
+// if it matches its hash. Otherwise the status of r is set to the problem.
+func relocateFilestoreObject(ctx context.Context, fs *filestore.Filestore, root string, r *filestore.ListRes) error {
+	fpath := filepath.Join(root, filepath.FromSlash(r.FilePath))
+	f, err := os.Open(fpath)
+	if os.IsNotExist(err) {
+		r.Status, r.ErrorMsg = filestore.StatusFileNotFound, err.Error()
+		return nil
+	} else if err != nil {
+		r.Status, r.ErrorMsg = filestore.StatusFileError, err.Error()
+		return nil
+	}
+	defer f.Close()
+
+	data := make([]byte, r.Size)
+	if _, err := f.ReadAt(data, int64(r.Offset)); err == io.EOF {
+		r.Status, r.ErrorMsg = filestore.StatusFileChanged, err.Error()
+		return nil
+	} else if err != nil {
+		r.Status, r.ErrorMsg = filestore.StatusFileError, err.Error()
+		return nil
+	}
+	nd, err := dag.NewRawNodeWPrefix(data, r.Key.Prefix())
+	if err != nil {
+		return err
+	}
+	if !nd.Cid().Equals(r.Key) {
+		r.Status = filestore.StatusFileChanged
+		r.ErrorMsg = fmt.Sprintf("data in file did not match. %s offset %d", r.FilePath, r.Offset)
+		return nil
+	}
+
+	return fs.FileManager().Put(ctx, &posinfo.FilestoreNode{
+		Node: nd,
+		PosInfo: &posinfo.PosInfo{
+			Offset:   r.Offset,
+			FullPath: fpath,
+		},
+	})
+}
+
+// filestoreRoot returns the directory the paths of the filestore are
+// relative to.
+func filestoreRoot(env cmds.Environment) (string, error) {
+	cfgRoot, err := cmdenv.GetConfigRoot(env)
+	if err != nil {
+		return "", err
+	}
+	return fsrepo.FilestoreRoot(cfgRoot)
+}
+
 func getFilestore(env cmds.Environment) (*core.IpfsNode, *filestore.Filestore, error) {
 	n, err := cmdenv.GetNode(env)
 	if err != nil {
@@ -236,7 +473,9 @@ func getFilestore(env cmds.Environment) (*core.IpfsNode, *filestore.Filestore, e
 	return n, fs, err
 }
 
-func listByArgs(ctx context.Context, res cmds.ResponseEmitter, fs *filestore.Filestore, args []string) error {
+// listByArgs emits the verification of the objects args, checked against the
+// origin of their URL by v if it is not nil.
+func listByArgs(ctx context.Context, res cmds.ResponseEmitter, fs *filestore.Filestore, args []string, v *urlstore.Verifier) error {
 	for _, arg := range args {
 		c, err := cid.Decode(arg)
 		if err != nil {
@@ -250,6 +489,9 @@ func listByArgs(ctx context.Context, res cmds.ResponseEmitter, fs *filestore.Fil
 			continue
 		}
 		r := filestore.Verify(ctx, fs, c)
+		if v != nil {
+			r = v.Verify(ctx, r)
+		}
 		if err := res.Emit(r); err != nil {
 			return err
 		}
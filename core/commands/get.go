@@ -1,22 +1,31 @@
 package commands
 
 import (
+	gotar "archive/tar"
 	"bufio"
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	gopath "path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	"github.com/ipfs/go-ipfs/core/commands/e"
+	"github.com/ipfs/go-ipfs/core/coreunix/metadata"
 
 	"github.com/cheggaaa/pb"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	uio "github.com/ipfs/go-unixfs/io"
 	"github.com/ipfs/interface-go-ipfs-core/path"
 	"github.com/ipfs/tar-utils"
 )
@@ -43,6 +52,10 @@ To output a TAR archive instead of unpacked files, use '--archive' or '-a'.
 
 To compress the output with GZIP compression, use '--compress' or '-C'. You
 may also specify the level of compression by specifying '-l=<1-9>'.
+
+The permissions and modification times recorded with 'ipfs add
+--preserve-mode --preserve-mtime' are restored on the extracted files, and
+stored in the TAR archives.
 `,
 	},
 
@@ -54,6 +67,7 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		cmds.BoolOption(archiveOptionName, "a", "Output a TAR archive."),
 		cmds.BoolOption(compressOptionName, "C", "Compress the output with GZIP compression."),
 		cmds.IntOption(compressionLevelOptionName, "l", "The level of compression (1-9)."),
+		cmdenv.FetchOption,
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
 		_, err := getCompressOptions(req)
@@ -72,7 +86,23 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 
 		p := path.New(req.Arguments[0])
 
-		file, err := api.Unixfs().Get(req.Context, p)
+		prefetch, err := cmdenv.GetPrefetcher(req, env)
+		if err != nil {
+			return err
+		}
+		rp, err := api.ResolvePath(req.Context, p)
+		if err != nil {
+			return err
+		}
+		if err := prefetch(req.Context, rp.Cid()); err != nil {
+			return err
+		}
+
+		nd, err := api.ResolveNode(req.Context, rp)
+		if err != nil {
+			return err
+		}
+		file, err := api.Unixfs().Get(req.Context, rp)
 		if err != nil {
 			return err
 		}
@@ -85,7 +115,7 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		res.SetLength(uint64(size))
 
 		archive, _ := req.Options[archiveOptionName].(bool)
-		reader, err := fileArchive(file, p.String(), archive, cmplvl)
+		reader, err := fileArchive(req.Context, file, nd, api.Dag(), p.String(), archive, cmplvl)
 		if err != nil {
 			return err
 		}
@@ -228,8 +258,127 @@ func (gw *getWriter) writeExtracted(r io.Reader, fpath string) error {
 	defer bar.Finish()
 	defer bar.Set64(gw.Size)
 
+	// the extractor puts a root file into an existing directory
+	rootInDir := false
+	if st, err := os.Lstat(fpath); err == nil && st.IsDir() {
+		rootInDir = true
+	}
+
+	// read the metadata of the entries along with the extractor
+	pr, pw := io.Pipe()
+	mdCh := make(chan []tarMetadata, 1)
+	go func() {
+		mdCh <- readTarMetadata(pr)
+	}()
+
 	extractor := &tar.Extractor{Path: fpath, Progress: bar.Add64}
-	return extractor.Extract(r)
+	err := extractor.Extract(io.TeeReader(r, pw))
+	pw.CloseWithError(err)
+	mds := <-mdCh
+	if err != nil || fpath == os.DevNull {
+		return err
+	}
+	return restoreTarMetadata(fpath, rootInDir, mds)
+}
+
+// PAX records of the metadata recorded in the UnixFS nodes, so that only that
+// metadata is restored when extracting.
+const (
+	paxModeRecord  = "IPFS.mode"
+	paxMtimeRecord = "IPFS.mtime"
+)
+
+// tarMetadata is the metadata of an entry of a tar stream.
+type tarMetadata struct {
+	name     string
+	typeflag byte
+	metadata.FileMetadata
+}
+
+// readTarMetadata returns the metadata of the entries of the tar stream r,
+// which it drains.
+func readTarMetadata(r io.Reader) []tarMetadata {
+	defer io.Copy(ioutil.Discard, r) //nolint:errcheck
+
+	var mds []tarMetadata
+	tr := gotar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return mds
+		}
+		md := tarMetadata{name: hdr.Name, typeflag: hdr.Typeflag}
+		if v, ok := hdr.PAXRecords[paxModeRecord]; ok {
+			if mode, err := strconv.ParseUint(v, 8, 32); err == nil {
+				md.Mode = metadata.FileModeFromPosix(uint32(mode))
+				md.HasMode = true
+			}
+		}
+		if v, ok := hdr.PAXRecords[paxMtimeRecord]; ok {
+			if mtime, err := parsePaxTime(v); err == nil {
+				md.ModTime = mtime
+			}
+		}
+		mds = append(mds, md)
+	}
+}
+
+func formatPaxTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+func parsePaxTime(v string) (time.Time, error) {
+	sec, nsec := v, "0"
+	if i := strings.IndexByte(v, '.'); i >= 0 {
+		sec, nsec = v[:i], v[i+1:]
+	}
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ns, err := strconv.ParseUint(nsec, 10, 32)
+	if err != nil || ns >= uint64(time.Second) {
+		return time.Time{}, fmt.Errorf("invalid time %q", v)
+	}
+	return time.Unix(s, int64(ns)), nil
+}
+
+// restoreTarMetadata applies the metadata of the entries extracted to fpath,
+// the way tar.Extractor placed them.
+func restoreTarMetadata(fpath string, rootInDir bool, mds []tarMetadata) error {
+	if len(mds) == 0 {
+		return nil
+	}
+	fpath = filepath.Clean(fpath)
+	rootName := mds[0].name
+
+	// children first, so that the directories are left as recorded
+	for i := len(mds) - 1; i >= 0; i-- {
+		md := mds[i]
+		if md.IsZero() || md.typeflag == gotar.TypeSymlink {
+			continue
+		}
+
+		target := fpath
+		switch {
+		case i > 0:
+			target = filepath.Join(fpath, filepath.FromSlash(strings.TrimPrefix(md.name, rootName+"/")))
+		case md.typeflag != gotar.TypeDir && rootInDir:
+			target = filepath.Join(fpath, rootName)
+		}
+
+		if md.HasMode {
+			if err := os.Chmod(target, md.Mode); err != nil {
+				return err
+			}
+		}
+		if !md.ModTime.IsZero() {
+			if err := os.Chtimes(target, md.ModTime, md.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func getCompressOptions(req *cmds.Request) (int, error) {
@@ -262,7 +411,8 @@ func (i *identityWriteCloser) Close() error {
 	return nil
 }
 
-func fileArchive(f files.Node, name string, archive bool, compression int) (io.Reader, error) {
+// fileArchive returns the archive of f, the file of the UnixFS node nd.
+func fileArchive(ctx context.Context, f files.Node, nd ipld.Node, dserv ipld.DAGService, name string, archive bool, compression int) (io.Reader, error) {
 	cleaned := gopath.Clean(name)
 	_, filename := gopath.Split(cleaned)
 
@@ -312,17 +462,16 @@ func fileArchive(f files.Node, name string, archive bool, compression int) (io.R
 		// the case for 1. archive, and 2. not archived and not compressed, in which tar is used anyway as a transport format
 
 		// construct the tar writer
-		w, err := files.NewTarWriter(maybeGzw)
-		if checkErrAndClosePipe(err) {
-			return nil, err
-		}
+		w := &unixfsTarWriter{ctx: ctx, dserv: dserv, tw: gotar.NewWriter(maybeGzw)}
 
 		go func() {
 			// write all the nodes recursively
-			if err := w.WriteFile(f, filename); checkErrAndClosePipe(err) {
+			if err := w.writeNode(nd, filename); checkErrAndClosePipe(err) {
+				return
+			}
+			if err := w.tw.Close(); checkErrAndClosePipe(err) {
 				return
 			}
-			w.Close()         // close tar writer
 			closeGzwAndPipe() // everything seems to be ok
 		}()
 	}
@@ -330,6 +479,89 @@ func fileArchive(f files.Node, name string, archive bool, compression int) (io.R
 	return piper, nil
 }
 
+// unixfsTarWriter writes UnixFS DAGs as tar archives like files.TarWriter,
+// with the mode and mtime recorded in the nodes.
+type unixfsTarWriter struct {
+	ctx   context.Context
+	dserv ipld.DAGService
+	tw    *gotar.Writer
+}
+
+func (w *unixfsTarWriter) writeNode(nd ipld.Node, fpath string) error {
+	md, err := metadata.Read(nd)
+	if err != nil {
+		return err
+	}
+	f, err := unixfile.NewUnixfsFile(w.ctx, w.dserv, nd)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr := &gotar.Header{Name: fpath, ModTime: time.Now()}
+	switch f := f.(type) {
+	case *files.Symlink:
+		hdr.Typeflag = gotar.TypeSymlink
+		hdr.Linkname = f.Target
+		hdr.Mode = 0777
+	case files.File:
+		size, err := f.Size()
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag = gotar.TypeReg
+		hdr.Size = size
+		hdr.Mode = 0644
+	case files.Directory:
+		hdr.Typeflag = gotar.TypeDir
+		hdr.Mode = 0777
+	default:
+		return fmt.Errorf("unsupported file type %T", f)
+	}
+
+	if md.HasMode {
+		mode := metadata.PosixMode(md.Mode)
+		hdr.Mode = int64(mode)
+		hdr.PAXRecords = map[string]string{paxModeRecord: strconv.FormatUint(uint64(mode), 8)}
+	}
+	if !md.ModTime.IsZero() {
+		hdr.ModTime = md.ModTime
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[paxMtimeRecord] = formatPaxTime(md.ModTime)
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	switch f := f.(type) {
+	case *files.Symlink:
+		return nil
+	case files.File:
+		_, err := io.Copy(w.tw, f)
+		return err
+	case files.Directory:
+		return w.writeChildren(nd, fpath)
+	}
+	return nil
+}
+
+// writeChildren writes the entries of the directory nd under fpath.
+func (w *unixfsTarWriter) writeChildren(nd ipld.Node, fpath string) error {
+	dir, err := uio.NewDirectoryFromNode(w.dserv, nd)
+	if err != nil {
+		return err
+	}
+	return dir.ForEachLink(w.ctx, func(l *ipld.Link) error {
+		child, err := l.GetNode(w.ctx, w.dserv)
+		if err != nil {
+			return err
+		}
+		return w.writeNode(child, gopath.Join(fpath, l.Name))
+	})
+}
+
 func newMaybeGzWriter(w io.Writer, compression int) (io.WriteCloser, error) {
 	if compression != gzip.NoCompression {
 		return gzip.NewWriterLevel(w, compression)
@@ -1,11 +1,23 @@
 package commands
 
 import (
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core/coreunix/metadata"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	dagtest "github.com/ipfs/go-merkledag/test"
+	ft "github.com/ipfs/go-unixfs"
+	unixfile "github.com/ipfs/go-unixfs/file"
 )
 
 func TestGetOutputPath(t *testing.T) {
@@ -66,3 +78,65 @@ func TestGetOutputPath(t *testing.T) {
 		})
 	}
 }
+
+func TestGetRestoresMetadata(t *testing.T) {
+	ctx := context.Background()
+	dserv := dagtest.Mock()
+
+	mtime := time.Unix(1500000000, 42)
+	file, err := metadata.Set(dag.NodeWithData(ft.FilePBData([]byte("data"), 4)),
+		metadata.FileMetadata{Mode: 0600, HasMode: true, ModTime: mtime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := dag.NodeWithData(ft.FilePBData([]byte("plain"), 5))
+	dir := ft.EmptyDirNode()
+	if err := dir.AddNodeLink("file", file); err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.AddNodeLink("plain", plain); err != nil {
+		t.Fatal(err)
+	}
+	root, err := metadata.Set(dir, metadata.FileMetadata{Mode: 0750, HasMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddMany(ctx, []ipld.Node{file, plain, root}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := unixfile.NewUnixfsFile(ctx, dserv, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := fileArchive(ctx, f, root, dserv, "root", false, gzip.NoCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	gw := getWriter{Out: ioutil.Discard, Err: ioutil.Discard}
+	if err := gw.Write(r, out); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := os.Stat(filepath.Join(out, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0600 || !st.ModTime().Equal(mtime) {
+		t.Fatalf("expected the file to be restored with mode 0600 and mtime %s, got %s %s", mtime, st.Mode(), st.ModTime())
+	}
+	if st, err = os.Stat(out); err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0750 {
+		t.Fatalf("expected the directory to be restored with mode 0750, got %s", st.Mode())
+	}
+	if st, err = os.Stat(filepath.Join(out, "plain")); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(st.ModTime()) > time.Minute {
+		t.Fatalf("expected the file without metadata to keep its extraction time, got %s", st.ModTime())
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"text/tabwriter"
 
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coreunix/metadata"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	unixfs "github.com/ipfs/go-unixfs"
@@ -23,6 +24,8 @@ type LsLink struct {
 	Size       uint64
 	Type       unixfs_pb.Data_DataType
 	Target     string
+	Mode       string `json:",omitempty"`
+	Mtime      string `json:",omitempty"`
 }
 
 // LsObject is an element of LsOutput
@@ -43,6 +46,7 @@ const (
 	lsResolveTypeOptionName = "resolve-type"
 	lsSizeOptionName        = "size"
 	lsStreamOptionName      = "stream"
+	lsLongOptionName        = "long"
 )
 
 var LsCmd = &cmds.Command{
@@ -55,6 +59,9 @@ the following format:
   <link base58 hash> <link size in bytes> <link name>
 
 The JSON output contains type information.
+
+With '--long', the mode and modification time recorded in the entries, as
+with 'ipfs add --preserve-mode --preserve-mtime', are printed first.
 `,
 	},
 
@@ -66,6 +73,7 @@ The JSON output contains type information.
 		cmds.BoolOption(lsResolveTypeOptionName, "Resolve linked objects to find out their types.").WithDefault(true),
 		cmds.BoolOption(lsSizeOptionName, "Resolve linked objects to find out their file size.").WithDefault(true),
 		cmds.BoolOption(lsStreamOptionName, "s", "Enable experimental streaming of directory entries as they are traversed."),
+		cmds.BoolOption(lsLongOptionName, "l", "Print the mode and modification time of the entries."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -76,6 +84,7 @@ The JSON output contains type information.
 		resolveType, _ := req.Options[lsResolveTypeOptionName].(bool)
 		resolveSize, _ := req.Options[lsSizeOptionName].(bool)
 		stream, _ := req.Options[lsStreamOptionName].(bool)
+		long, _ := req.Options[lsLongOptionName].(bool)
 
 		err = req.ParseBodyArgs()
 		if err != nil {
@@ -159,6 +168,17 @@ The JSON output contains type information.
 					Type:   ftype,
 					Target: link.Target,
 				}
+				if long {
+					nd, err := api.Dag().Get(req.Context, link.Cid)
+					if err != nil {
+						return err
+					}
+					md, err := metadata.Read(nd)
+					if err != nil {
+						return err
+					}
+					lsLink.Mode, lsLink.Mtime = formatMetadata(md)
+				}
 				if err := processLink(paths[i], lsLink); err != nil {
 					return err
 				}
@@ -202,6 +222,7 @@ func tabularOutput(req *cmds.Request, w io.Writer, out *LsOutput, lastObjectHash
 	headers, _ := req.Options[lsHeadersOptionNameTime].(bool)
 	stream, _ := req.Options[lsStreamOptionName].(bool)
 	size, _ := req.Options[lsSizeOptionName].(bool)
+	long, _ := req.Options[lsLongOptionName].(bool)
 	// in streaming mode we can't automatically align the tabs
 	// so we take a best guess
 	var minTabWidth int
@@ -229,6 +250,9 @@ func tabularOutput(req *cmds.Request, w io.Writer, out *LsOutput, lastObjectHash
 				if size {
 					s = "Hash\tSize\tName"
 				}
+				if long {
+					s = "Mode\tMtime\t" + s
+				}
 				fmt.Fprintln(tw, s)
 			}
 			lastObjectHash = object.Hash
@@ -251,9 +275,19 @@ func tabularOutput(req *cmds.Request, w io.Writer, out *LsOutput, lastObjectHash
 				}
 			}
 
+			if long {
+				fmt.Fprintf(tw, "%s\t%s\t", orDash(link.Mode), orDash(link.Mtime))
+			}
 			fmt.Fprintf(tw, s, link.Hash, link.Size, cmdenv.EscNonPrint(link.Name))
 		}
 	}
 	tw.Flush()
 	return lastObjectHash
 }
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -146,6 +146,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"p2p":       P2PCmd,
 	"refs":      RefsCmd,
 	"resolve":   ResolveCmd,
+	"routing":   RoutingCmd,
 	"swarm":     SwarmCmd,
 	"tar":       TarCmd,
 	"file":      unixfs.UnixFSCmd,
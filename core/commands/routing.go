@@ -0,0 +1,476 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	iprovider "github.com/ipfs/go-ipfs/provider"
+	irouting "github.com/ipfs/go-ipfs/routing"
+
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	dag "github.com/ipfs/go-merkledag"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var RoutingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Issue routing commands.",
+		ShortDescription: `
+Query the routing system of the node, composed of the DHT and the routers
+configured in Routing.Routers, and report which router produced each result
+and how long it took.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"findprovs": findProvidersRoutingCmd,
+		"findpeer":  findPeerRoutingCmd,
+		"get":       getValueRoutingCmd,
+		"put":       putValueRoutingCmd,
+		"provide":   provideRefRoutingCmd,
+		"reprovide": reprovideRoutingCmd,
+	},
+}
+
+// RoutingResult is a result of one of the routers composed in the routing
+// system. Results without a router are the outcome of the whole query.
+type RoutingResult struct {
+	Router  string        `json:",omitempty"`
+	Latency time.Duration `json:",omitempty"`
+
+	// Key is the key provided.
+	Key string `json:",omitempty"`
+	// Provider is a provider found, with the age of its provider record
+	// when it is held by the DHT of this node.
+	Provider  *peer.AddrInfo `json:",omitempty"`
+	RecordAge time.Duration  `json:",omitempty"`
+	Peer      *peer.AddrInfo `json:",omitempty"`
+	Value     []byte         `json:",omitempty"`
+	Error     string         `json:",omitempty"`
+}
+
+func routingResult(ev irouting.TraceEvent) *RoutingResult {
+	res := &RoutingResult{
+		Router:   ev.Router,
+		Latency:  ev.Latency,
+		Provider: ev.Provider,
+		Peer:     ev.Peer,
+		Value:    ev.Value,
+	}
+	if ev.Err != nil {
+		res.Error = ev.Err.Error()
+	}
+	return res
+}
+
+// traceRouting runs query, emitting the results it passes to emit. Results
+// emitted after query returned are dropped.
+func traceRouting(req *cmds.Request, re cmds.ResponseEmitter, query func(ctx context.Context, emit func(*RoutingResult)) error) error {
+	ctx, cancel := context.WithCancel(req.Context)
+	defer cancel()
+
+	results := make(chan *RoutingResult)
+	emit := func(r *RoutingResult) {
+		select {
+		case results <- r:
+		case <-ctx.Done():
+		}
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- query(ctx, emit)
+	}()
+
+	for {
+		select {
+		case r := <-results:
+			if err := re.Emit(r); err != nil {
+				return err
+			}
+		case err := <-errCh:
+			return err
+		}
+	}
+}
+
+func routingResultEncoder(req *cmds.Request, w io.Writer, r *RoutingResult) error {
+	if r.Router == "" {
+		if r.Value != nil {
+			fmt.Fprintf(w, "selected value: %s\n", base64.StdEncoding.EncodeToString(r.Value))
+		}
+		return nil
+	}
+
+	prefix := fmt.Sprintf("%s\t%s\t", r.Router, r.Latency.Round(time.Millisecond))
+	if r.Key != "" {
+		prefix += r.Key + "\t"
+	}
+	printAddrs := func(ai *peer.AddrInfo) {
+		for _, a := range ai.Addrs {
+			fmt.Fprintf(w, "\t%s\n", a)
+		}
+	}
+
+	switch {
+	case r.Error != "":
+		fmt.Fprintf(w, "%serror: %s\n", prefix, r.Error)
+	case r.Provider != nil:
+		fmt.Fprintf(w, "%s%s", prefix, r.Provider.ID)
+		if r.RecordAge > 0 {
+			fmt.Fprintf(w, "\trecord age %s", r.RecordAge.Round(time.Second))
+		}
+		fmt.Fprintln(w)
+		printAddrs(r.Provider)
+	case r.Peer != nil:
+		fmt.Fprintf(w, "%s%s\n", prefix, r.Peer.ID)
+		printAddrs(r.Peer)
+	case r.Value != nil:
+		fmt.Fprintf(w, "%svalue: %s\n", prefix, base64.StdEncoding.EncodeToString(r.Value))
+	default:
+		fmt.Fprintf(w, "%sok\n", prefix)
+	}
+	return nil
+}
+
+var findProvidersRoutingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Find peers that can provide a specific value, given a key.",
+		ShortDescription: `
+Outputs the providers found by every router, with their addresses. The age of
+the provider record is included when the DHT of this node holds it.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("key", true, false, "The key to find providers for."),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(numProvidersOptionName, "n", "The number of providers to find.").WithDefault(20),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		numProviders, _ := req.Options[numProvidersOptionName].(int)
+		if numProviders < 1 {
+			return fmt.Errorf("number of providers must be greater than 0")
+		}
+
+		c, err := cid.Parse(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		dstore := nd.Repo.Datastore()
+		return traceRouting(req, res, func(ctx context.Context, emit func(*RoutingResult)) error {
+			ctx = irouting.WithTrace(ctx, func(ev irouting.TraceEvent) {
+				r := routingResult(ev)
+				if ev.Provider != nil {
+					age, ok, err := irouting.ProviderRecordAge(ctx, dstore, c, ev.Provider.ID)
+					if err != nil {
+						log.Debugf("failed to read provider record of %s for %s: %s", ev.Provider.ID, c, err)
+					} else if ok {
+						r.RecordAge = age
+					}
+				}
+				emit(r)
+			})
+			for range nd.Routing.FindProvidersAsync(ctx, c, numProviders) {
+			}
+			return nil
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(routingResultEncoder),
+	},
+	Type: RoutingResult{},
+}
+
+var provideRefRoutingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Announce to the network that you are providing given values.",
+		ShortDescription: `
+Outputs the outcome of the announcement of every key by every router.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("key", true, true, "The key[s] to send provide records for.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(recursiveOptionName, "r", "Recursively provide entire graph."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		if len(nd.PeerHost.Network().Conns()) == 0 {
+			return errors.New("cannot provide, no connected peers")
+		}
+
+		// Needed to parse stdin args.
+		err = req.ParseBodyArgs()
+		if err != nil {
+			return err
+		}
+
+		rec, _ := req.Options[recursiveOptionName].(bool)
+
+		var cids []cid.Cid
+		for _, arg := range req.Arguments {
+			c, err := cid.Decode(arg)
+			if err != nil {
+				return err
+			}
+
+			has, err := nd.Blockstore.Has(req.Context, c)
+			if err != nil {
+				return err
+			}
+
+			if !has {
+				return fmt.Errorf("block %s not found locally, cannot provide", c)
+			}
+
+			cids = append(cids, c)
+		}
+
+		return traceRouting(req, res, func(ctx context.Context, emit func(*RoutingResult)) error {
+			if rec {
+				set := cid.NewSet()
+				for _, c := range cids {
+					if err := dag.Walk(ctx, dag.GetLinksDirect(nd.DAG), c, set.Visit); err != nil {
+						return err
+					}
+				}
+				cids = set.Keys()
+			}
+
+			for _, c := range cids {
+				c := c
+				kctx := irouting.WithTrace(ctx, func(ev irouting.TraceEvent) {
+					r := routingResult(ev)
+					r.Key = c.String()
+					emit(r)
+				})
+				if err := nd.Routing.Provide(kctx, c, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(routingResultEncoder),
+	},
+	Type: RoutingResult{},
+}
+
+var findPeerRoutingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Find the multiaddresses associated with a Peer ID.",
+		ShortDescription: `
+Outputs the addresses of the peer found by every router.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("peerID", true, false, "The ID of the peer to search for."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		pid, err := peer.Decode(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		return traceRouting(req, res, func(ctx context.Context, emit func(*RoutingResult)) error {
+			ctx = irouting.WithTrace(ctx, func(ev irouting.TraceEvent) {
+				emit(routingResult(ev))
+			})
+			_, err := nd.Routing.FindPeer(ctx, pid)
+			return err
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(routingResultEncoder),
+	},
+	Type: RoutingResult{},
+}
+
+var getValueRoutingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Given a key, query the routing system for its best value.",
+		ShortDescription: `
+Outputs the value found by every router, then the best value for the given
+key. Values are base64 encoded in the text output.
+
+For IPNS, 'best' is the record that is both valid and has the highest sequence
+number (freshest).
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("key", true, false, "The key to find a value for."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		key, err := escapeDhtKey(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		return traceRouting(req, res, func(ctx context.Context, emit func(*RoutingResult)) error {
+			tctx := irouting.WithTrace(ctx, func(ev irouting.TraceEvent) {
+				emit(routingResult(ev))
+			})
+			val, err := nd.Routing.GetValue(tctx, key)
+			if err != nil {
+				return err
+			}
+			emit(&RoutingResult{Value: val})
+			return nil
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(routingResultEncoder),
+	},
+	Type: RoutingResult{},
+}
+
+var putValueRoutingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Write a key/value pair to the routing system.",
+		ShortDescription: `
+Given a key of the form /ipns/<peer id> and a valid value for that key, this
+will write that value to every router serving it and output the outcome of
+each write. See 'ipfs dht put' for the details of keys and values.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("key", true, false, "The key to store the value at."),
+		cmds.FileArg("value-file", true, false, "A path to a file containing the value to store.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		key, err := escapeDhtKey(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		file, err := cmdenv.GetFileArg(req.Files.Entries())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return err
+		}
+
+		return traceRouting(req, res, func(ctx context.Context, emit func(*RoutingResult)) error {
+			ctx = irouting.WithTrace(ctx, func(ev irouting.TraceEvent) {
+				emit(routingResult(ev))
+			})
+			return nd.Routing.PutValue(ctx, key, data)
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(routingResultEncoder),
+	},
+	Type: RoutingResult{},
+}
+
+const reprovideStrategyOptionName = "strategy"
+
+var reprovideRoutingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Trigger reprovider.",
+		ShortDescription: `
+Trigger reprovider to announce our data to network.
+
+By default, the keys of the Reprovider.Strategy configured for the node are
+announced. Use --strategy to announce the keys of another strategy once,
+e.g. 'mfs' or 'pinned+mfs'.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(reprovideStrategyOptionName, "s", "Reprovide the keys of this strategy instead of the configured one."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		strategyStr, ok := req.Options[reprovideStrategyOptionName].(string)
+		if !ok {
+			return nd.Provider.Reprovide(req.Context)
+		}
+
+		strategy, err := iprovider.ParseStrategy(strategyStr)
+		if err != nil {
+			return cmds.Errorf(cmds.ErrClient, err.Error())
+		}
+		sys, ok := nd.Provider.(*iprovider.System)
+		if !ok {
+			return fmt.Errorf("--%s is not supported with Experimental.AcceleratedDHTClient or Experimental.StrategicProviding", reprovideStrategyOptionName)
+		}
+		return sys.ReprovideKeys(req.Context, strategy.KeyProvider(iprovider.KeySources{
+			Blockstore: nd.Blockstore,
+			Pinner:     nd.Pinning,
+			Fetcher:    nd.IPLDFetcherFactory,
+			FilesRoot:  nd.FilesRoot,
+		}))
+	},
+}
@@ -1,15 +1,20 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"text/tabwriter"
 	"time"
 
+	"github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
 	pstore "github.com/libp2p/go-libp2p-core/peerstore"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
@@ -19,13 +24,16 @@ import (
 type dhtPeerInfo struct {
 	ID            string
 	Connected     bool
+	Dialable      bool
 	AgentVersion  string
+	Protocols     []string
 	LastUsefulAt  string
 	LastQueriedAt string
 }
 
 type dhtStat struct {
 	Name    string
+	Health  dhtHealth
 	Buckets []dhtBucket
 }
 
@@ -34,11 +42,97 @@ type dhtBucket struct {
 	Peers       []dhtPeerInfo
 }
 
+// dhtHealth summarizes the state of a routing table.
+type dhtHealth struct {
+	Peers int
+	// DialableRatio is the fraction of the peers that are connected or
+	// have known addresses.
+	DialableRatio float64
+	// BucketFill is the fraction of the slots of the buckets in use. It is
+	// not reported for the accelerated DHT client, which has no buckets.
+	BucketFill float64 `json:",omitempty"`
+}
+
+// dhtSnapshot is the content of the file written by --snapshot.
+type dhtSnapshot struct {
+	Time string
+	DHTs []dhtStat
+}
+
+const (
+	dhtSnapshotOptionName = "snapshot"
+
+	// dhtBucketSize is the size of the buckets of the routing tables of
+	// the DHT, which must use the default for the IPFS protocols.
+	dhtBucketSize = 20
+)
+
+// dhtProtocols are the DHT protocols reported as supported by the peers.
+var dhtProtocols = []string{
+	string(dht.DefaultPrefix) + "/kad/1.0.0",
+	string(dht.DefaultPrefix) + "/lan/kad/1.0.0",
+}
+
+// dhtPeer returns the information about p known to the node.
+func dhtPeer(nd *core.IpfsNode, p peer.ID) dhtPeerInfo {
+	info := dhtPeerInfo{ID: p.String()}
+
+	if ver, err := nd.Peerstore.Get(p, "AgentVersion"); err == nil {
+		info.AgentVersion, _ = ver.(string)
+	} else if err == pstore.ErrNotFound {
+		// ignore
+	} else {
+		// this is a bug, usually.
+		log.Errorw(
+			"failed to get agent version from peerstore",
+			"error", err,
+		)
+	}
+
+	protos, err := nd.Peerstore.SupportsProtocols(p, dhtProtocols...)
+	if err != nil {
+		log.Errorw("failed to get protocols from peerstore", "error", err)
+	}
+	info.Protocols = protos
+
+	info.Connected = nd.PeerHost.Network().Connectedness(p) == network.Connected
+	info.Dialable = info.Connected || len(nd.Peerstore.Addrs(p)) > 0
+	return info
+}
+
+// health computes the health of the routing table. The fill of the buckets is
+// only computed when bucketSize is not 0.
+func (s *dhtStat) health(bucketSize int) dhtHealth {
+	var h dhtHealth
+	dialable := 0
+	for _, b := range s.Buckets {
+		for _, p := range b.Peers {
+			h.Peers++
+			if p.Dialable {
+				dialable++
+			}
+		}
+	}
+	if h.Peers > 0 {
+		h.DialableRatio = float64(dialable) / float64(h.Peers)
+	}
+	if bucketSize > 0 && len(s.Buckets) > 0 {
+		h.BucketFill = float64(h.Peers) / float64(len(s.Buckets)*bucketSize)
+	}
+	return h
+}
+
 var statDhtCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Returns statistics about the node's DHT(s).",
 		ShortDescription: `
-Returns statistics about the DHT(s) the node is participating in.
+Returns statistics about the DHT(s) the node is participating in: the peers
+of every bucket of the routing tables, and a summary of their health.
+
+Use --enc=json to get, for every peer, the time it was last useful, whether it
+is connected or dialable, its agent version and the DHT protocols it supports.
+Use --snapshot to also save the routing tables to a JSON file for offline
+analysis. The file is written by the command line client, not by the node.
 
 This interface is not stable and may change from release to release.
 `,
@@ -47,7 +141,9 @@ This interface is not stable and may change from release to release.
 		cmds.StringArg("dht", false, true, "The DHT whose table should be listed (wanserver, lanserver, wan, lan). "+
 			"wan and lan refer to client routing tables. When using the experimental DHT client only WAN is supported. Defaults to wan and lan."),
 	},
-	Options: []cmds.Option{},
+	Options: []cmds.Option{
+		cmds.StringOption(dhtSnapshotOptionName, "Write the routing tables to this file as JSON."),
+	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
 		if err != nil {
@@ -89,29 +185,16 @@ This interface is not stable and may change from release to release.
 					buckets := make([]dhtBucket, 1)
 					b := &dhtBucket{}
 					for _, p := range peerMap {
-						info := dhtPeerInfo{ID: p.String()}
-
-						if ver, err := nd.Peerstore.Get(p, "AgentVersion"); err == nil {
-							info.AgentVersion, _ = ver.(string)
-						} else if err == pstore.ErrNotFound {
-							// ignore
-						} else {
-							// this is a bug, usually.
-							log.Errorw(
-								"failed to get agent version from peerstore",
-								"error", err,
-							)
-						}
-
-						info.Connected = nd.PeerHost.Network().Connectedness(p) == network.Connected
-						b.Peers = append(b.Peers, info)
+						b.Peers = append(b.Peers, dhtPeer(nd, p))
 					}
 					buckets[0] = *b
 
-					if err := res.Emit(dhtStat{
+					stat := dhtStat{
 						Name:    name,
 						Buckets: buckets,
-					}); err != nil {
+					}
+					stat.Health = stat.health(0)
+					if err := res.Emit(stat); err != nil {
 						return err
 					}
 					continue dhttypeloop
@@ -140,19 +223,7 @@ This interface is not stable and may change from release to release.
 					buckets = append(buckets, make([]dhtBucket, 1+cpl-len(buckets))...)
 				}
 
-				info := dhtPeerInfo{ID: pi.Id.String()}
-
-				if ver, err := nd.Peerstore.Get(pi.Id, "AgentVersion"); err == nil {
-					info.AgentVersion, _ = ver.(string)
-				} else if err == pstore.ErrNotFound {
-					// ignore
-				} else {
-					// this is a bug, usually.
-					log.Errorw(
-						"failed to get agent version from peerstore",
-						"error", err,
-					)
-				}
+				info := dhtPeer(nd, pi.Id)
 				if !pi.LastUsefulAt.IsZero() {
 					info.LastUsefulAt = pi.LastUsefulAt.Format(time.RFC3339)
 				}
@@ -161,8 +232,6 @@ This interface is not stable and may change from release to release.
 					info.LastQueriedAt = pi.LastSuccessfulOutboundQueryAt.Format(time.RFC3339)
 				}
 
-				info.Connected = nd.PeerHost.Network().Connectedness(pi.Id) == network.Connected
-
 				buckets[cpl].Peers = append(buckets[cpl].Peers, info)
 			}
 			for i := 0; i < len(buckets) && i < len(lastRefresh); i++ {
@@ -171,16 +240,51 @@ This interface is not stable and may change from release to release.
 					buckets[i].LastRefresh = refreshTime.Format(time.RFC3339)
 				}
 			}
-			if err := res.Emit(dhtStat{
+			stat := dhtStat{
 				Name:    name,
 				Buckets: buckets,
-			}); err != nil {
+			}
+			stat.Health = stat.health(dhtBucketSize)
+			if err := res.Emit(stat); err != nil {
 				return err
 			}
 		}
-
 		return nil
 	},
+	PostRun: cmds.PostRunMap{
+		// the snapshot is written by the client, as the node must not
+		// write files its API clients name
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			snapshotPath, _ := res.Request().Options[dhtSnapshotOptionName].(string)
+			snapshot := dhtSnapshot{Time: time.Now().Format(time.RFC3339)}
+			for {
+				v, err := res.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				var stat dhtStat
+				switch out := v.(type) {
+				case *dhtStat:
+					stat = *out
+				case dhtStat:
+					stat = out
+				default:
+					return e.TypeErr(&stat, v)
+				}
+				snapshot.DHTs = append(snapshot.DHTs, stat)
+				if err := re.Emit(&stat); err != nil {
+					return err
+				}
+			}
+			if snapshotPath == "" {
+				return nil
+			}
+			return writeDhtSnapshot(snapshotPath, &snapshot)
+		},
+	},
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out dhtStat) error {
 			tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
@@ -198,7 +302,11 @@ This interface is not stable and may change from release to release.
 				count += len(bucket.Peers)
 			}
 
-			fmt.Fprintf(tw, "DHT %s (%d peers):\t\t\t\n", out.Name, count)
+			fmt.Fprintf(tw, "DHT %s (%d peers, %.0f%% dialable", out.Name, count, 100*out.Health.DialableRatio)
+			if out.Health.BucketFill > 0 {
+				fmt.Fprintf(tw, ", buckets %.0f%% full", 100*out.Health.BucketFill)
+			}
+			fmt.Fprintf(tw, "):\t\t\t\n")
 
 			for i, bucket := range out.Buckets {
 				lastRefresh := "never"
@@ -234,6 +342,8 @@ This interface is not stable and may change from release to release.
 					state := " "
 					if p.Connected {
 						state = "@"
+					} else if !p.Dialable {
+						state = "!"
 					}
 					fmt.Fprintf(tw, "  %s %s\t%s\t%s\t%s\n", state, p.ID, lastUseful, lastQueried, p.AgentVersion)
 				}
@@ -244,3 +354,18 @@ This interface is not stable and may change from release to release.
 	},
 	Type: dhtStat{},
 }
+
+// writeDhtSnapshot writes snapshot to the file at path as JSON.
+func writeDhtSnapshot(path string, snapshot *dhtSnapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snapshot); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,30 @@
+package commands
+
+import "testing"
+
+func TestDhtHealth(t *testing.T) {
+	stat := dhtStat{
+		Buckets: []dhtBucket{
+			{Peers: []dhtPeerInfo{{Dialable: true}, {Dialable: true}, {}}},
+			{Peers: []dhtPeerInfo{{Dialable: true}}},
+		},
+	}
+
+	h := stat.health(4)
+	if h.Peers != 4 {
+		t.Fatalf("expected 4 peers, got %d", h.Peers)
+	}
+	if h.DialableRatio != 0.75 {
+		t.Fatalf("expected 3/4 of the peers to be dialable, got %f", h.DialableRatio)
+	}
+	if h.BucketFill != 0.5 {
+		t.Fatalf("expected the buckets to be half full, got %f", h.BucketFill)
+	}
+
+	if h := stat.health(0); h.BucketFill != 0 {
+		t.Fatalf("expected no bucket fill without buckets, got %f", h.BucketFill)
+	}
+	if h := (&dhtStat{}).health(4); h.DialableRatio != 0 || h.BucketFill != 0 {
+		t.Fatalf("expected an empty table to have no health, got %+v", h)
+	}
+}
@@ -11,19 +11,50 @@ import (
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 
 	"github.com/ipfs/go-ipfs-provider/batched"
+	iprovider "github.com/ipfs/go-ipfs/provider"
 )
 
+const (
+	provideWatchOptionName    = "watch"
+	provideIntervalOptionName = "interval"
+	provideQueueOptionName    = "queue"
+	provideLimitOptionName    = "limit"
+)
+
+// provideStats holds the statistics of either provider system.
+type provideStats struct {
+	*batched.BatchedProviderStats
+	*iprovider.Stats
+
+	// Queue lists the keys waiting to be announced, with --queue.
+	Queue []iprovider.QueueEntry `json:",omitempty"`
+}
+
 var statProvideCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Returns statistics about the node's (re)provider system.",
 		ShortDescription: `
 Returns statistics about the content the node is advertising.
 
+With --watch, statistics are printed again at every interval, which helps
+following the progress of a reprovide.
+
+With --queue, the keys waiting to be announced are listed, from the highest
+priority to the lowest. Keys explicitly provided come first, then the roots
+of added and pinned content, then other keys, then the other blocks of added
+content. Keys waiting for a long time
+are announced first regardless of their priority.
+
 This interface is not stable and may change from release to release.
 `,
 	},
 	Arguments: []cmds.Argument{},
-	Options:   []cmds.Option{},
+	Options: []cmds.Option{
+		cmds.BoolOption(provideWatchOptionName, "w", "Print statistics at an interval."),
+		cmds.StringOption(provideIntervalOptionName, "i", "Time interval to wait between updates, if 'watch' is true.").WithDefault("1s"),
+		cmds.BoolOption(provideQueueOptionName, "q", "List the keys waiting to be announced."),
+		cmds.IntOption(provideLimitOptionName, "l", "Maximum number of queued keys to list, 0 for all.").WithDefault(100),
+	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
 		if err != nil {
@@ -34,35 +65,134 @@ This interface is not stable and may change from release to release.
 			return ErrNotOnline
 		}
 
-		sys, ok := nd.Provider.(*batched.BatchProvidingSystem)
-		if !ok {
-			return fmt.Errorf("can only return stats if Experimental.AcceleratedDHTClient is enabled")
-		}
-
-		stats, err := sys.Stat(req.Context)
+		timeS, _ := req.Options[provideIntervalOptionName].(string)
+		interval, err := time.ParseDuration(timeS)
 		if err != nil {
 			return err
 		}
 
-		if err := res.Emit(stats); err != nil {
-			return err
+		listQueue, _ := req.Options[provideQueueOptionName].(bool)
+		limit, _ := req.Options[provideLimitOptionName].(int)
+		if limit < 0 {
+			return fmt.Errorf("--%s must be positive", provideLimitOptionName)
 		}
 
-		return nil
+		var stat func() (*provideStats, error)
+		switch sys := nd.Provider.(type) {
+		case *batched.BatchProvidingSystem:
+			if listQueue {
+				return fmt.Errorf("--%s is not supported with Experimental.AcceleratedDHTClient", provideQueueOptionName)
+			}
+			stat = func() (*provideStats, error) {
+				s, err := sys.Stat(req.Context)
+				return &provideStats{BatchedProviderStats: &s}, err
+			}
+		case *iprovider.System:
+			stat = func() (*provideStats, error) {
+				s, err := sys.Stat(req.Context)
+				if err != nil {
+					return nil, err
+				}
+				stats := &provideStats{Stats: s}
+				if listQueue {
+					stats.Queue, err = sys.Pending(req.Context, limit)
+				}
+				return stats, err
+			}
+		default:
+			return fmt.Errorf("statistics are not available with Experimental.StrategicProviding")
+		}
+
+		watch, _ := req.Options[provideWatchOptionName].(bool)
+		for {
+			stats, err := stat()
+			if err != nil {
+				return err
+			}
+			if err := res.Emit(stats); err != nil {
+				return err
+			}
+			if !watch {
+				return nil
+			}
+			select {
+			case <-time.After(interval):
+			case <-req.Context.Done():
+				return req.Context.Err()
+			}
+		}
 	},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *batched.BatchedProviderStats) error {
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *provideStats) error {
 			wtr := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
 			defer wtr.Flush()
 
-			fmt.Fprintf(wtr, "TotalProvides:\t%s\n", humanNumber(s.TotalProvides))
-			fmt.Fprintf(wtr, "AvgProvideDuration:\t%s\n", humanDuration(s.AvgProvideDuration))
-			fmt.Fprintf(wtr, "LastReprovideDuration:\t%s\n", humanDuration(s.LastReprovideDuration))
-			fmt.Fprintf(wtr, "LastReprovideBatchSize:\t%s\n", humanNumber(s.LastReprovideBatchSize))
+			if watch, _ := req.Options[provideWatchOptionName].(bool); watch {
+				fmt.Fprintf(wtr, "%s\n", time.Now().Format(time.RFC3339))
+			}
+
+			if s.BatchedProviderStats != nil {
+				fmt.Fprintf(wtr, "TotalProvides:\t%s\n", humanNumber(s.TotalProvides))
+				fmt.Fprintf(wtr, "AvgProvideDuration:\t%s\n", humanDuration(s.AvgProvideDuration))
+				fmt.Fprintf(wtr, "LastReprovideDuration:\t%s\n", humanDuration(s.LastReprovideDuration))
+				fmt.Fprintf(wtr, "LastReprovideBatchSize:\t%s\n", humanNumber(s.LastReprovideBatchSize))
+			}
+
+			if st := s.Stats; st != nil {
+				fmt.Fprintf(wtr, "QueueLength:\t%s\n", humanNumber(st.QueueLength))
+				if listQueue, _ := req.Options[provideQueueOptionName].(bool); listQueue {
+					for _, p := range []iprovider.Priority{iprovider.PriorityHigh, iprovider.PriorityRoot, iprovider.PriorityNormal, iprovider.PriorityLow} {
+						fmt.Fprintf(wtr, "QueueLength[%s]:\t%s\n", p, humanNumber(st.QueueLengths[p]))
+					}
+				}
+				fmt.Fprintf(wtr, "Provides:\t%s\n", humanNumber(int(st.Provides)))
+				fmt.Fprintf(wtr, "Failures:\t%s\n", humanNumber(int(st.Failures)))
+				fmt.Fprintf(wtr, "ProvidesLastInterval:\t%s\n", humanNumber(int(st.ProvidesLastInterval)))
+				if st.ReprovideInterval == 0 {
+					fmt.Fprintf(wtr, "ReprovideInterval:\tdisabled\n")
+				} else {
+					fmt.Fprintf(wtr, "ReprovideInterval:\t%s\n", st.ReprovideInterval)
+					fmt.Fprintf(wtr, "NextReprovide:\t%s\n", humanTime(st.NextReprovide))
+				}
+				if r := st.LastReprovide; r != nil {
+					state := "finished"
+					if st.Reproviding {
+						state = "running"
+					} else if r.Error != "" {
+						state = "failed: " + r.Error
+					}
+					fmt.Fprintf(wtr, "LastReprovide:\t%s\n", state)
+					fmt.Fprintf(wtr, "LastReprovideStart:\t%s\n", humanTime(r.Start))
+					if !r.End.IsZero() {
+						fmt.Fprintf(wtr, "LastReprovideEnd:\t%s\n", humanTime(r.End))
+						fmt.Fprintf(wtr, "LastReprovideDuration:\t%s\n", humanDuration(r.End.Sub(r.Start)))
+					}
+					fmt.Fprintf(wtr, "LastReprovideProvides:\t%s\n", humanNumber(int(r.Provides)))
+					fmt.Fprintf(wtr, "LastReprovideFailures:\t%s\n", humanNumber(int(r.Failures)))
+				}
+			}
+
+			if len(s.Queue) > 0 {
+				fmt.Fprintf(wtr, "\nQueue:\n")
+				for _, e := range s.Queue {
+					fmt.Fprintf(wtr, "%s\t%s\t%s\n", e.Cid, e.Priority, humanTime(e.Enqueued))
+				}
+			}
+
+			if watch, _ := req.Options[provideWatchOptionName].(bool); watch {
+				fmt.Fprintln(wtr)
+			}
 			return nil
 		}),
 	},
-	Type: batched.BatchedProviderStats{},
+	Type: provideStats{},
+}
+
+func humanTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Truncate(time.Second).Format(time.RFC3339)
 }
 
 func humanDuration(val time.Duration) string {
@@ -1,14 +1,19 @@
 package commands
 
 import (
+	gotar "archive/tar"
+	"context"
 	"fmt"
 	"io"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coreunix/metadata"
 	tar "github.com/ipfs/go-ipfs/tar"
 
+	ipld "github.com/ipfs/go-ipld-format"
 	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
 	path "github.com/ipfs/interface-go-ipfs-core/path"
 )
 
@@ -23,18 +28,35 @@ var TarCmd = &cmds.Command{
 	},
 }
 
+const tarUnixfsOptionName = "unixfs"
+
 var tarAddCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Import a tar file into IPFS.",
 		ShortDescription: `
 'ipfs tar add' will parse a tar file and create a merkledag structure to
 represent it.
+`,
+		LongDescription: `
+'ipfs tar add' will parse a tar file and create a merkledag structure to
+represent it, keeping the headers of its entries, with their PAX records and
+extended attributes, so that 'ipfs tar cat' exports the same tar file. The
+records of the PAX global headers are kept in the entries they apply to.
+
+With --unixfs, the tar file is imported as a regular UnixFS directory instead,
+which can be browsed on the gateway and read with 'ipfs get' or 'ipfs cat'.
+Its files are chunked like with 'ipfs add', and the mode and mtime of the
+entries, and the symlinks, are kept. The other headers, and the devices and
+FIFOs, are not.
 `,
 	},
 
 	Arguments: []cmds.Argument{
 		cmds.FileArg("file", true, false, "Tar file to add.").EnableStdin(),
 	},
+	Options: []cmds.Option{
+		cmds.BoolOption(tarUnixfsOptionName, "Import the tar file as a UnixFS directory."),
+	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
@@ -52,7 +74,12 @@ represent it.
 			return err
 		}
 
-		node, err := tar.ImportTar(req.Context, file, api.Dag())
+		var node ipld.Node
+		if unixfs, _ := req.Options[tarUnixfsOptionName].(bool); unixfs {
+			node, err = tar.ImportTarUnixFS(req.Context, file, api.Dag())
+		} else {
+			node, err = tar.ImportTar(req.Context, file, api.Dag())
+		}
 		if err != nil {
 			return err
 		}
@@ -78,6 +105,8 @@ var tarCatCmd = &cmds.Command{
 		Tagline: "Export a tar file from IPFS.",
 		ShortDescription: `
 'ipfs tar cat' will export a tar file from a previously imported one in IPFS.
+A UnixFS directory, like one imported with 'ipfs tar add --unixfs', is
+exported as a standard tar file of its entries, with their mode and mtime.
 `,
 	},
 
@@ -100,6 +129,10 @@ var tarCatCmd = &cmds.Command{
 			return dag.ErrNotProtobuf
 		}
 
+		if fsn, err := ft.FSNodeFromBytes(rootpb.Data()); err == nil && fsn.IsDir() {
+			return res.Emit(unixfsDirTar(req.Context, rootpb, api.Dag()))
+		}
+
 		r, err := tar.ExportTar(req.Context, rootpb, api.Dag())
 		if err != nil {
 			return err
@@ -108,3 +141,26 @@ var tarCatCmd = &cmds.Command{
 		return res.Emit(r)
 	},
 }
+
+// unixfsDirTar returns the standard tar file of the entries of the UnixFS
+// directory nd, with nd itself as "." if it has metadata, like 'tar -C dir .'
+// would.
+func unixfsDirTar(ctx context.Context, nd ipld.Node, dserv ipld.DAGService) io.Reader {
+	piper, pipew := io.Pipe()
+	go func() {
+		w := &unixfsTarWriter{ctx: ctx, dserv: dserv, tw: gotar.NewWriter(pipew)}
+		md, err := metadata.Read(nd)
+		if err == nil {
+			if md.IsZero() {
+				err = w.writeChildren(nd, "")
+			} else {
+				err = w.writeNode(nd, ".")
+			}
+		}
+		if err == nil {
+			err = w.tw.Close()
+		}
+		pipew.CloseWithError(err)
+	}()
+	return piper
+}
@@ -3,13 +3,16 @@ package commands
 import (
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
+	"strings"
 
 	filestore "github.com/ipfs/go-filestore"
+	"github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/urlstore"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
-	files "github.com/ipfs/go-ipfs-files"
 	"github.com/ipfs/interface-go-ipfs-core/options"
 )
 
@@ -22,6 +25,8 @@ var urlStoreCmd = &cmds.Command{
 	},
 }
 
+const urlHeaderOptionName = "header"
+
 var urlAdd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Add URL via urlstore.",
@@ -35,11 +40,23 @@ control.
 
 The file is added using raw-leaves but otherwise using the default
 settings for 'ipfs add'.
+
+The headers given with '--header' are sent with every request of the URL,
+for the servers requiring them. They are stored in the repo as given, with
+the ETag and Last-Modified headers of the response, which 'ipfs filestore
+verify' compares to tell whether the content of the URL changed. To keep a
+secret out of the repo, give the name of an environment variable of the
+daemon holding it, as ${NAME}, replaced with every request. Only the
+variables allowed by Experimental.UrlstoreHeaderEnv can be referenced:
+
+    $ ipfs config --json Experimental.UrlstoreHeaderEnv '["IPFS_URLSTORE_*"]'
+    $ ipfs urlstore add --header='Authorization: Bearer ${IPFS_URLSTORE_TOKEN}' <url>
 `,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(trickleOptionName, "t", "Use trickle-dag format for dag generation."),
 		cmds.BoolOption(pinOptionName, "Pin this object when adding.").WithDefault(true),
+		cmds.StringsOption(urlHeaderOptionName, "Header to send with the requests, as 'Name: value'."),
 	},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("url", true, false, "URL to add to IPFS"),
@@ -64,6 +81,24 @@ settings for 'ipfs add'.
 			return err
 		}
 
+		header := make(http.Header)
+		headers, _ := req.Options[urlHeaderOptionName].([]string)
+		for _, h := range headers {
+			kv := strings.SplitN(h, ":", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return fmt.Errorf("invalid header %q, expected 'Name: value'", h)
+			}
+			header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if n.Filestore == nil || !n.Filestore.FileManager().AllowUrls {
+			return filestore.ErrUrlstoreNotEnabled
+		}
+
 		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
 			return err
@@ -83,7 +118,11 @@ settings for 'ipfs add'.
 			opts = append(opts, options.Unixfs.Layout(options.TrickleLayout))
 		}
 
-		file := files.NewWebFile(url)
+		store, err := urlStore(n)
+		if err != nil {
+			return err
+		}
+		file := urlstore.NewWebFile(req.Context, store, url, header)
 
 		path, err := api.Unixfs().Add(req.Context, file, opts...)
 		if err != nil {
@@ -102,3 +141,12 @@ settings for 'ipfs add'.
 		}),
 	},
 }
+
+// urlStore returns the store of the origins of the URLs of the urlstore of n.
+func urlStore(n *core.IpfsNode) (*urlstore.Store, error) {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	return urlstore.NewStore(n.Repo.Datastore(), cfg.Experimental.UrlstoreHeaderEnv), nil
+}
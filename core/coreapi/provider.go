@@ -1,7 +1,11 @@
 package coreapi
 
 import (
+	"context"
+
 	cid "github.com/ipfs/go-cid"
+	iprovider "github.com/ipfs/go-ipfs/provider"
+	ipld "github.com/ipfs/go-ipld-format"
 )
 
 // ProviderAPI brings Provider behavior to CoreAPI
@@ -9,5 +13,55 @@ type ProviderAPI CoreAPI
 
 // Provide the given cid using the current provider
 func (api *ProviderAPI) Provide(cid cid.Cid) error {
-	return api.provider.Provide(cid)
+	return (*CoreAPI)(api).provide(cid, iprovider.PriorityHigh)
+}
+
+// priorityProvider is implemented by provider systems which can announce
+// some keys before others.
+type priorityProvider interface {
+	ProvideWithPriority(cid.Cid, iprovider.Priority) error
+}
+
+// provide queues c with priority p if the provider system supports it.
+func (api *CoreAPI) provide(c cid.Cid, p iprovider.Priority) error {
+	if pp, ok := api.provider.(priorityProvider); ok {
+		return pp.ProvideWithPriority(c, p)
+	}
+	return api.provider.Provide(c)
+}
+
+// provideDAGService queues the nodes added to the wrapped DAGService to be
+// announced with the low priority, so that the blocks of a bulk add wait
+// behind its root and the keys provided explicitly.
+type provideDAGService struct {
+	ipld.DAGService
+	provider priorityProvider
+}
+
+func (ds *provideDAGService) Add(ctx context.Context, nd ipld.Node) error {
+	if err := ds.DAGService.Add(ctx, nd); err != nil {
+		return err
+	}
+	return ds.provider.ProvideWithPriority(nd.Cid(), iprovider.PriorityLow)
+}
+
+func (ds *provideDAGService) AddMany(ctx context.Context, nds []ipld.Node) error {
+	if err := ds.DAGService.AddMany(ctx, nds); err != nil {
+		return err
+	}
+	for _, nd := range nds {
+		if err := ds.provider.ProvideWithPriority(nd.Cid(), iprovider.PriorityLow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// provideAdded returns ds, queuing the nodes added to it to be announced with
+// the low priority if the provider system supports priorities.
+func (api *CoreAPI) provideAdded(ds ipld.DAGService) ipld.DAGService {
+	if pp, ok := api.provider.(priorityProvider); ok {
+		return &provideDAGService{DAGService: ds, provider: pp}
+	}
+	return ds
 }
@@ -0,0 +1,44 @@
+package coreapi
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	iprovider "github.com/ipfs/go-ipfs/provider"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+)
+
+type mockPriorityProvider struct {
+	priorities map[cid.Cid]iprovider.Priority
+}
+
+func (p *mockPriorityProvider) ProvideWithPriority(c cid.Cid, pr iprovider.Priority) error {
+	p.priorities[c] = pr
+	return nil
+}
+
+func TestProvideDAGService(t *testing.T) {
+	ctx := context.Background()
+	pp := &mockPriorityProvider{priorities: make(map[cid.Cid]iprovider.Priority)}
+	ds := &provideDAGService{DAGService: mdtest.Mock(), provider: pp}
+
+	a := dag.NewRawNode([]byte("a"))
+	b := dag.NewRawNode([]byte("b"))
+	if err := ds.Add(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.AddMany(ctx, []ipld.Node{b}); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []ipld.Node{a, b} {
+		if p, ok := pp.priorities[nd.Cid()]; !ok || p != iprovider.PriorityLow {
+			t.Fatalf("expected %s to be queued with the low priority, got %v", nd.Cid(), p)
+		}
+		if _, err := ds.Get(ctx, nd.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 
 	"github.com/ipfs/go-ipfs/core/coreunix"
+	iprovider "github.com/ipfs/go-ipfs/provider"
 
 	blockservice "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
@@ -95,7 +96,17 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 	}
 
 	bserv := blockservice.New(addblockstore, exch) // hash security 001
-	dserv := dag.NewDAGService(bserv)
+	var dserv ipld.DAGService = dag.NewDAGService(bserv)
+	if report, checkLocal := coreunix.DedupReportFrom(ctx); report != nil {
+		var local bstore.Blockstore
+		if checkLocal {
+			local = api.blockstore
+		}
+		dserv = coreunix.NewDedupDAGService(dserv, report, local)
+	}
+	if !settings.OnlyHash {
+		dserv = (*CoreAPI)(api).provideAdded(dserv)
+	}
 
 	// add a sync call to the DagService
 	// this ensures that data written to the DagService is persisted to the underlying datastore
@@ -134,6 +145,15 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 	fileAdder.RawLeaves = settings.RawLeaves
 	fileAdder.NoCopy = settings.NoCopy
 	fileAdder.CidBuilder = prefix
+	preserve := coreunix.Preserving(ctx)
+	fileAdder.PreserveMode = preserve.Mode
+	fileAdder.PreserveMtime = preserve.Mtime
+	fileAdder.Wrapped = preserve.Wrapped
+	fileAdder.Concurrency = coreunix.Concurrency(ctx)
+	if !settings.OnlyHash && coreunix.Resuming(ctx) {
+		fileAdder.Checkpoints = coreunix.NewCheckpoints(api.repo.Datastore(), addblockstore)
+		fileAdder.Resume = true
+	}
 
 	switch settings.Layout {
 	case options.BalancedLayout:
@@ -170,7 +190,7 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 	}
 
 	if !settings.OnlyHash {
-		if err := api.provider.Provide(nd.Cid()); err != nil {
+		if err := (*CoreAPI)(api).provide(nd.Cid(), iprovider.PriorityRoot); err != nil {
 			return nil, err
 		}
 	}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"sort"
 
+	humanize "github.com/dustin/go-humanize"
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
 	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
@@ -18,6 +19,14 @@ type GatewayConfig struct {
 	Headers      map[string][]string
 	Writable     bool
 	PathPrefixes []string
+
+	// Trustless restricts the gateway to responses clients can verify
+	// (raw blocks and CARs).
+	Trustless bool
+
+	// CacheSize is the memory budget, in bytes, of the cache for resolved
+	// immutable paths and directory listings. Zero disables caching.
+	CacheSize int64
 }
 
 // A helper function to clean up a set of headers:
@@ -52,6 +61,11 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 			return nil, err
 		}
 
+		cacheSize, err := humanize.ParseBytes(cfg.Gateway.CacheSize.WithDefault(defaultGatewayCacheSize))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Gateway.CacheSize: %w", err)
+		}
+
 		headers := make(map[string][]string, len(cfg.Gateway.HTTPHeaders))
 		for h, v := range cfg.Gateway.HTTPHeaders {
 			headers[http.CanonicalHeaderKey(h)] = v
@@ -91,7 +105,9 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 			Headers:      headers,
 			Writable:     writable,
 			PathPrefixes: cfg.Gateway.PathPrefixes,
-		}, api)
+			Trustless:    cfg.Gateway.Trustless,
+			CacheSize:    int64(cacheSize),
+		}, api, n.Routing)
 
 		for _, p := range paths {
 			mux.Handle(p+"/", gateway)
@@ -0,0 +1,126 @@
+package corehttp
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// defaultGatewayCacheSize is used when Gateway.CacheSize is not set.
+const defaultGatewayCacheSize = "32MiB"
+
+// cacheEntryOverhead approximates the bookkeeping cost (list element, map
+// entry, interface headers) of a single cache entry, so that caches full of
+// tiny values are still bounded.
+const cacheEntryOverhead = 128
+
+// gatewayCache is a memory-bounded LRU cache for gateway responses that can
+// never change: resolved immutable /ipfs/ paths and generated directory
+// listings keyed by the CID of the directory.
+//
+// A nil *gatewayCache is valid and caches nothing.
+type gatewayCache struct {
+	lk      sync.Mutex
+	maxSize int64
+	size    int64
+	ll      *list.List
+	items   map[string]*list.Element
+}
+
+type gatewayCacheEntry struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+// newGatewayCache returns a cache holding at most maxSize bytes, or nil if
+// maxSize is not positive.
+func newGatewayCache(maxSize int64) *gatewayCache {
+	if maxSize <= 0 {
+		return nil
+	}
+	return &gatewayCache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func (c *gatewayCache) get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*gatewayCacheEntry).value, true
+}
+
+// add stores value under key. size is the approximate memory footprint of
+// value; values larger than the whole cache are not stored.
+func (c *gatewayCache) add(key string, value interface{}, size int64) {
+	if c == nil {
+		return
+	}
+	size += int64(len(key)) + cacheEntryOverhead
+	if size > c.maxSize {
+		return
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*gatewayCacheEntry)
+		c.size += size - entry.size
+		entry.value = value
+		entry.size = size
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[key] = c.ll.PushFront(&gatewayCacheEntry{key: key, value: value, size: size})
+		c.size += size
+	}
+
+	for c.size > c.maxSize {
+		e := c.ll.Back()
+		entry := e.Value.(*gatewayCacheEntry)
+		c.ll.Remove(e)
+		delete(c.items, entry.key)
+		c.size -= entry.size
+	}
+}
+
+// resolvePath resolves p through the CoreAPI, consulting the cache first when
+// p is an immutable /ipfs/ path. Mutable /ipns/ paths are always resolved
+// (namesys has its own cache, bounded by record TTLs).
+func (i *gatewayHandler) resolvePath(ctx context.Context, p ipath.Path) (ipath.Resolved, error) {
+	if p.Namespace() != "ipfs" {
+		return i.api.ResolvePath(ctx, p)
+	}
+
+	key := "path:" + p.String()
+	if v, ok := i.cache.get(key); ok {
+		return v.(ipath.Resolved), nil
+	}
+
+	resolved, err := i.api.ResolvePath(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	i.cache.add(key, resolved, int64(len(resolved.String())+len(resolved.Remainder())))
+	return resolved, nil
+}
+
+// dirListingCacheKey returns the cache key for a rendered directory listing.
+// The HTML depends on how the directory was addressed (links and breadcrumbs
+// are relative to the requested URL), so those inputs are part of the key.
+func dirListingCacheKey(dirCid string, gwURL string, originalUrlPath string, urlPath string) string {
+	return "dir:" + dirCid + "\x00" + gwURL + "\x00" + originalUrlPath + "\x00" + urlPath
+}
@@ -0,0 +1,64 @@
+package corehttp
+
+import (
+	"testing"
+)
+
+func TestGatewayCacheEviction(t *testing.T) {
+	// room for exactly two 100-byte values with single-letter keys
+	c := newGatewayCache(2 * (100 + 1 + cacheEntryOverhead))
+
+	c.add("a", "a", 100)
+	c.add("b", "b", 100)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	// b is now the least recently used entry
+	c.add("c", "c", 100)
+	if _, ok := c.get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if v, ok := c.get(k); !ok || v.(string) != k {
+			t.Fatalf("expected %s to be cached, got %v", k, v)
+		}
+	}
+}
+
+func TestGatewayCacheTooLarge(t *testing.T) {
+	c := newGatewayCache(1024)
+	c.add("small", "small", 10)
+	c.add("huge", "huge", 4096)
+
+	if _, ok := c.get("huge"); ok {
+		t.Fatal("values larger than the cache must not be stored")
+	}
+	if _, ok := c.get("small"); !ok {
+		t.Fatal("adding an oversized value must not evict other entries")
+	}
+}
+
+func TestGatewayCacheUpdate(t *testing.T) {
+	c := newGatewayCache(1024)
+	c.add("k", "old", 10)
+	c.add("k", "new", 20)
+
+	if v, _ := c.get("k"); v.(string) != "new" {
+		t.Fatalf("expected updated value, got %v", v)
+	}
+	if expected := int64(20 + 1 + cacheEntryOverhead); c.size != expected {
+		t.Fatalf("expected size %d, got %d", expected, c.size)
+	}
+}
+
+func TestGatewayCacheDisabled(t *testing.T) {
+	c := newGatewayCache(0)
+	if c != nil {
+		t.Fatal("expected a nil cache for a zero size")
+	}
+	c.add("k", "v", 1)
+	if _, ok := c.get("k"); ok {
+		t.Fatal("a disabled cache must not return values")
+	}
+}
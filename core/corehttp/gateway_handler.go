@@ -1,6 +1,7 @@
 package corehttp
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"html/template"
@@ -36,6 +37,18 @@ const (
 	ipnsPathPrefix = "/ipns/"
 )
 
+// Media types of responses that clients can verify without trusting the
+// gateway, requested with ?format= or the Accept header.
+const (
+	rawResponseFormat        = "application/vnd.ipld.raw"
+	carResponseFormat        = "application/vnd.ipld.car"
+	ipnsRecordResponseFormat = "application/vnd.ipfs.ipns-record"
+)
+
+// errTrustlessGateway is returned for requests that would require the
+// gateway to deserialize content on behalf of the client.
+var errTrustlessGateway = fmt.Errorf("this gateway only serves verifiable responses: request raw blocks with ?format=raw (Accept: %s), CARs with ?format=car (Accept: %s) or IPNS records with ?format=ipns-record (Accept: %s)", rawResponseFormat, carResponseFormat, ipnsRecordResponseFormat)
+
 var onlyAscii = regexp.MustCompile("[[:^ascii:]]")
 
 // HTML-based redirect for errors which can be recovered from, but we want
@@ -61,8 +74,10 @@ type redirectTemplateData struct {
 // gatewayHandler is a HTTP handler that serves IPFS objects (accessible by default at /ipfs/<path>)
 // (it serves requests like GET /ipfs/QmVRzPKPzNtSrEzBFm2UZfxmPAgnaLke4DMcerbsGGSaFe/link)
 type gatewayHandler struct {
-	config GatewayConfig
-	api    coreiface.CoreAPI
+	config  GatewayConfig
+	api     coreiface.CoreAPI
+	routing routing.ValueStore
+	cache   *gatewayCache
 
 	unixfsGetMetric *prometheus.SummaryVec
 }
@@ -87,7 +102,7 @@ func (sw *statusResponseWriter) WriteHeader(code int) {
 	sw.ResponseWriter.WriteHeader(code)
 }
 
-func newGatewayHandler(c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
+func newGatewayHandler(c GatewayConfig, api coreiface.CoreAPI, vs routing.ValueStore) *gatewayHandler {
 	unixfsGetMetric := prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
 			Namespace: "ipfs",
@@ -108,6 +123,8 @@ func newGatewayHandler(c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
 	i := &gatewayHandler{
 		config:          c,
 		api:             api,
+		routing:         vs,
+		cache:           newGatewayCache(c.CacheSize),
 		unixfsGetMetric: unixfsGetMetric,
 	}
 	return i
@@ -266,6 +283,19 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	// Detect when explicit Accept header or ?format parameter are present
+	responseFormat, err := customResponseFormat(r)
+	if err != nil {
+		webError(w, "error while processing the Accept header", err, http.StatusBadRequest)
+		return
+	}
+
+	trustless := i.isTrustless(r)
+	if trustless && responseFormat == "" {
+		webError(w, "trustless gateway", errTrustlessGateway, http.StatusNotAcceptable)
+		return
+	}
+
 	parsedPath := ipath.New(urlPath)
 	if pathErr := parsedPath.IsValid(); pathErr != nil {
 		if prefix == "" && fixupSuperfluousNamespace(w, urlPath, r.URL.RawQuery) {
@@ -279,14 +309,34 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// IPNS records are served as-is, without resolving them
+	if responseFormat == ipnsRecordResponseFormat {
+		logger.Debugw("serving ipns record", "path", urlPath)
+		i.serveIpnsRecord(w, r, urlPath)
+		return
+	}
+
 	// Resolve path to the final DAG node for the ETag
-	resolvedPath, err := i.api.ResolvePath(r.Context(), parsedPath)
+	resolvedPath, err := i.resolvePath(r.Context(), parsedPath)
 	switch err {
 	case nil:
 	case coreiface.ErrOffline:
 		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusServiceUnavailable)
 		return
 	default:
+		// Custom 404 pages are website content, which doesn't belong in
+		// verifiable responses (and is never served by trustless gateways).
+		if responseFormat != "" {
+			webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusNotFound)
+			return
+		}
+
+		// _redirects rules only apply to paths that don't exist
+		if i.serveRedirectsIfPresent(w, r, urlPath) {
+			logger.Debugw("served by _redirects rule")
+			return
+		}
+
 		if i.servePretty404IfPresent(w, r, parsedPath) {
 			logger.Debugw("serve pretty 404 if present")
 			return
@@ -296,6 +346,17 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	switch responseFormat {
+	case rawResponseFormat:
+		logger.Debugw("serving raw block", "path", parsedPath)
+		i.serveRawBlock(w, r, resolvedPath, urlPath)
+		return
+	case carResponseFormat:
+		logger.Debugw("serving car stream", "path", parsedPath)
+		i.serveCar(w, r, resolvedPath, urlPath)
+		return
+	}
+
 	dr, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
 	if err != nil {
 		webError(w, "ipfs cat "+escapedURLPath, err, http.StatusNotFound)
@@ -364,7 +425,7 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		}
 
 		logger.Debugw("serving file", "name", name)
-		i.serveFile(w, r, name, modtime, f)
+		i.serveFile(w, r, resolvedPath, name, modtime, f)
 		return
 	}
 	dir, ok := dr.(files.Directory)
@@ -398,13 +459,19 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 			internalWebError(w, files.ErrNotReader)
 			return
 		}
+		resolvedIdxPath, err := i.resolvePath(r.Context(), idxPath)
+		if err != nil {
+			internalWebError(w, err)
+			return
+		}
+
 		// static index.html → no need to generate dynamic dir-index-html
 		// replace mutable DirIndex Etag with immutable dir CID
 		w.Header().Set("Etag", `"`+resolvedPath.Cid().String()+`"`)
 
 		logger.Debugw("serving index.html file", "path", idxPath)
 		// write to request
-		i.serveFile(w, r, "index.html", modtime, f)
+		i.serveFile(w, r, resolvedIdxPath, "index.html", modtime, f)
 		return
 	case resolver.ErrNoLink:
 		logger.Debugw("no index.html; noop", "path", idxPath)
@@ -431,6 +498,27 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Gateway root URL to be used when linking to other rootIDs.
+	// This will be blank unless subdomain or DNSLink resolution is being used
+	// for this request.
+	var gwURL string
+
+	// Get gateway hostname and build gateway URL.
+	if h, ok := r.Context().Value("gw-hostname").(string); ok {
+		gwURL = "//" + h
+	} else {
+		gwURL = ""
+	}
+
+	// Listings of immutable directories only need to be generated once per
+	// requested URL; serve from the cache and skip walking the DAG.
+	listingKey := dirListingCacheKey(resolvedPath.Cid().String(), gwURL, originalUrlPath, urlPath)
+	if html, ok := i.cache.get(listingKey); ok {
+		logger.Debugw("serving cached directory listing", "path", urlPath)
+		_, _ = w.Write(html.([]byte))
+		return
+	}
+
 	// storage for directory listing
 	var dirListing []directoryItem
 	dirit := dir.Entries()
@@ -441,7 +529,7 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 			size = humanize.Bytes(uint64(s))
 		}
 
-		resolved, err := i.api.ResolvePath(r.Context(), ipath.Join(resolvedPath, dirit.Name()))
+		resolved, err := i.resolvePath(r.Context(), ipath.Join(resolvedPath, dirit.Name()))
 		if err != nil {
 			internalWebError(w, err)
 			return
@@ -493,18 +581,6 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 
 	hash := resolvedPath.Cid().String()
 
-	// Gateway root URL to be used when linking to other rootIDs.
-	// This will be blank unless subdomain or DNSLink resolution is being used
-	// for this request.
-	var gwURL string
-
-	// Get gateway hostname and build gateway URL.
-	if h, ok := r.Context().Value("gw-hostname").(string); ok {
-		gwURL = "//" + h
-	} else {
-		gwURL = ""
-	}
-
 	dnslink := hasDNSLinkOrigin(gwURL, urlPath)
 
 	// See comment above where originalUrlPath is declared.
@@ -521,22 +597,27 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 
 	logger.Debugw("request processed", "tplDataDNSLink", dnslink, "tplDataSize", size, "tplDataBackLink", backLink, "tplDataHash", hash, "duration", time.Since(begin))
 
-	if err := listingTemplate.Execute(w, tplData); err != nil {
+	var buf bytes.Buffer
+	if err := listingTemplate.Execute(&buf, tplData); err != nil {
 		internalWebError(w, err)
 		return
 	}
+	html := buf.Bytes()
+	i.cache.add(listingKey, html, int64(len(html)))
+	_, _ = w.Write(html)
 }
 
-func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, file files.File) {
+func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, resolvedPath ipath.Resolved, name string, modtime time.Time, file files.File) {
 	size, err := file.Size()
 	if err != nil {
 		http.Error(w, "cannot serve files with unknown sizes", http.StatusBadGateway)
 		return
 	}
 
-	content := &lazySeeker{
-		size:   size,
-		reader: file,
+	content := i.fileContent(req, resolvedPath, file, size)
+	if rr, ok := content.(*unixfsRangeReader); ok {
+		// stops its prefetch once the response is written
+		defer rr.Close()
 	}
 
 	var ctype string
@@ -576,13 +657,38 @@ func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, nam
 	http.ServeContent(w, req, name, modtime, content)
 }
 
+// fileContent returns the seeker http.ServeContent reads the file from. Range
+// requests are served by a reader that only fetches the blocks covering the
+// requested byte ranges; everything else streams through the DagReader.
+func (i *gatewayHandler) fileContent(req *http.Request, resolvedPath ipath.Resolved, file files.File, size int64) io.ReadSeeker {
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+		if _, isSymlink := file.(*files.Symlink); !isSymlink {
+			// on error, fall back to the DagReader, which will report it
+			if root, err := i.api.Dag().Get(req.Context(), resolvedPath.Cid()); err == nil {
+				return newUnixfsRangeReader(req.Context(), i.api.Dag(), root, size, parseRangeHeader(rangeHeader, size))
+			}
+		}
+	}
+
+	return &lazySeeker{
+		size:   size,
+		reader: file,
+	}
+}
+
 func (i *gatewayHandler) servePretty404IfPresent(w http.ResponseWriter, r *http.Request, parsedPath ipath.Path) bool {
 	resolved404Path, ctype, err := i.searchUpTreeFor404(r, parsedPath)
 	if err != nil {
 		return false
 	}
 
-	dr, err := i.api.Unixfs().Get(r.Context(), resolved404Path)
+	log.Debugw("using pretty 404 file", "path", parsedPath)
+	return i.serveNotFoundFile(w, r, resolved404Path, ctype)
+}
+
+// serveNotFoundFile writes the file at p as the body of a 404 response.
+func (i *gatewayHandler) serveNotFoundFile(w http.ResponseWriter, r *http.Request, p ipath.Resolved, ctype string) bool {
+	dr, err := i.api.Unixfs().Get(r.Context(), p)
 	if err != nil {
 		return false
 	}
@@ -598,7 +704,6 @@ func (i *gatewayHandler) servePretty404IfPresent(w http.ResponseWriter, r *http.
 		return false
 	}
 
-	log.Debugw("using pretty 404 file", "path", parsedPath)
 	w.Header().Set("Content-Type", ctype)
 	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	w.WriteHeader(http.StatusNotFound)
@@ -789,6 +894,77 @@ func (i *gatewayHandler) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirectURL, http.StatusCreated)
 }
 
+// isTrustless returns true if the request must only be answered with
+// verifiable response types, either because the whole gateway is trustless or
+// because the hostname it was made to is.
+func (i *gatewayHandler) isTrustless(r *http.Request) bool {
+	if i.config.Trustless {
+		return true
+	}
+	trustless, _ := r.Context().Value("gw-trustless").(bool)
+	return trustless
+}
+
+// customResponseFormat returns the verifiable media type requested with the
+// ?format parameter or the Accept header, or "" for regular responses.
+func customResponseFormat(r *http.Request) (mediaType string, err error) {
+	if formatParam := r.URL.Query().Get("format"); formatParam != "" {
+		// translate query param to a content type
+		switch formatParam {
+		case "raw":
+			return rawResponseFormat, nil
+		case "car":
+			return carResponseFormat, nil
+		case "ipns-record":
+			return ipnsRecordResponseFormat, nil
+		default:
+			return "", fmt.Errorf("unsupported format %q", formatParam)
+		}
+	}
+	// Browsers and other user agents will send Accept header with generic types like:
+	// Accept:text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8
+	// We only care about explicit, vendor-specific content-types.
+	for _, accept := range r.Header.Values("Accept") {
+		for _, spec := range strings.Split(accept, ",") {
+			mediaType := strings.TrimSpace(strings.SplitN(spec, ";", 2)[0])
+			switch mediaType {
+			case rawResponseFormat, carResponseFormat, ipnsRecordResponseFormat:
+				return mediaType, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// prepareVerifiableResponse handles If-None-Match and sets the headers shared
+// by raw block and CAR responses. Returns false if the response is complete.
+func (i *gatewayHandler) prepareVerifiableResponse(w http.ResponseWriter, r *http.Request, urlPath string, etag string) bool {
+	if inm := r.Header.Get("If-None-Match"); inm == etag || inm == `W/`+etag {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+
+	i.addUserHeaders(w)
+	w.Header().Set("X-IPFS-Path", urlPath)
+	w.Header().Set("Etag", etag)
+	// The same URL returns different payloads depending on Accept
+	w.Header().Add("Vary", "Accept")
+	// Never let browsers guess the type of a response we did not interpret
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if rootCids, err := i.buildIpfsRootsHeader(urlPath, r); err == nil {
+		w.Header().Set("X-Ipfs-Roots", rootCids)
+	} else {
+		webError(w, "error while resolving X-Ipfs-Roots", err, http.StatusInternalServerError)
+		return false
+	}
+
+	if strings.HasPrefix(urlPath, ipfsPathPrefix) {
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+	}
+	return true
+}
+
 func (i *gatewayHandler) addUserHeaders(w http.ResponseWriter) {
 	for k, v := range i.config.Headers {
 		w.Header()[k] = v
@@ -829,7 +1005,7 @@ func (i *gatewayHandler) buildIpfsRootsHeader(contentPath string, r *http.Reques
 		}
 		sp.WriteString("/")
 		sp.WriteString(root)
-		resolvedSubPath, err := i.api.ResolvePath(r.Context(), ipath.New(sp.String()))
+		resolvedSubPath, err := i.resolvePath(r.Context(), ipath.New(sp.String()))
 		if err != nil {
 			return "", err
 		}
@@ -885,7 +1061,7 @@ func (i *gatewayHandler) searchUpTreeFor404(r *http.Request, parsedPath ipath.Pa
 		if parsed404Path.IsValid() != nil {
 			break
 		}
-		resolvedPath, err := i.api.ResolvePath(r.Context(), parsed404Path)
+		resolvedPath, err := i.resolvePath(r.Context(), parsed404Path)
 		if err != nil {
 			continue
 		}
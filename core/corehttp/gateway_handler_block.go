@@ -0,0 +1,39 @@
+package corehttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// serveRawBlock returns bytes behind a raw block
+func (i *gatewayHandler) serveRawBlock(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, urlPath string) {
+	blockCid := resolvedPath.Cid()
+	blockReader, err := i.api.Block().Get(r.Context(), resolvedPath)
+	if err != nil {
+		webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
+		return
+	}
+	block, err := ioutil.ReadAll(blockReader)
+	if err != nil {
+		webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
+		return
+	}
+
+	// Set Etag to the block CID (strong, the payload is deterministic)
+	etag := `"` + blockCid.String() + `.raw"`
+	if !i.prepareVerifiableResponse(w, r, urlPath, etag) {
+		return
+	}
+
+	name := blockCid.String() + ".bin"
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Content-Type", rawResponseFormat)
+
+	// ServeContent will take care of
+	// If-None-Match+Etag, Content-Length and range requests
+	http.ServeContent(w, r, name, time.Unix(0, 0), bytes.NewReader(block))
+}
@@ -0,0 +1,57 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+// serveCar returns a CAR stream for the DAG rooted at resolvedPath
+func (i *gatewayHandler) serveCar(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, urlPath string) {
+	rootCid := resolvedPath.Cid()
+
+	// The CAR is generated on the fly, so there is no byte-for-byte
+	// guarantee across requests: use a weak Etag.
+	etag := `W/"` + rootCid.String() + `.car"`
+	if !i.prepareVerifiableResponse(w, r, urlPath, etag) {
+		return
+	}
+
+	name := rootCid.String() + ".car"
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Content-Type", carResponseFormat+"; version=1")
+	// Errors that happen mid-stream can only be reported in a trailer.
+	w.Header().Set("Trailer", "X-Stream-Error")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	store := dagStore{dag: i.api.Dag(), ctx: r.Context()}
+	dag := gocar.Dag{Root: rootCid, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
+	// TraverseLinksOnlyOnce is safe for an exhaustive selector but won't be when we allow
+	// arbitrary selectors here
+	car := gocar.NewSelectiveCar(r.Context(), store, []gocar.Dag{dag}, gocar.TraverseLinksOnlyOnce())
+
+	w.WriteHeader(http.StatusOK)
+	if err := car.Write(w); err != nil {
+		log.Warnw("failed to write CAR response", "root", rootCid, "error", err)
+		w.Header().Set("X-Stream-Error", err.Error())
+	}
+}
+
+type dagStore struct {
+	dag coreiface.APIDagService
+	ctx context.Context
+}
+
+func (ds dagStore) Get(c cid.Cid) (blocks.Block, error) {
+	obj, err := ds.dag.Get(ds.ctx, c)
+	return obj, err
+}
@@ -0,0 +1,87 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	ipns "github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
+	namesys "github.com/ipfs/go-namesys"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+// serveIpnsRecord returns the signed IPNS record for /ipns/{key}, so clients
+// can verify name resolution themselves.
+func (i *gatewayHandler) serveIpnsRecord(w http.ResponseWriter, r *http.Request, urlPath string) {
+	key := strings.TrimSuffix(strings.TrimPrefix(urlPath, ipnsPathPrefix), "/")
+	if !strings.HasPrefix(urlPath, ipnsPathPrefix) || key == "" || strings.Contains(key, "/") {
+		webErrorWithCode(w, "ipns-record", fmt.Errorf("records are only available for /ipns/{key} paths, got %q", urlPath), http.StatusBadRequest)
+		return
+	}
+
+	pid, err := peer.Decode(key)
+	if err != nil {
+		// DNSLink names are resolved via DNS and have no signed record
+		webErrorWithCode(w, "ipns-record: "+key+" is not a valid IPNS key", err, http.StatusBadRequest)
+		return
+	}
+
+	if i.routing == nil {
+		webErrorWithCode(w, "ipns-record", fmt.Errorf("routing is not available"), http.StatusNotImplemented)
+		return
+	}
+	rawRecord, err := i.routing.GetValue(r.Context(), ipns.RecordKey(pid))
+	switch {
+	case err == routing.ErrNotFound || err == ds.ErrNotFound:
+		// offline routers report the records they do not have as missing
+		// from their datastore
+		webError(w, "ipns-record "+key, err, http.StatusNotFound)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		webError(w, "ipns-record "+key, err, http.StatusGatewayTimeout)
+		return
+	case err != nil:
+		webError(w, "ipns-record "+key, err, http.StatusInternalServerError)
+		return
+	}
+
+	var entry ipns_pb.IpnsEntry
+	if err := entry.Unmarshal(rawRecord); err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	// Let caches keep the record for as long as the publisher asked
+	// resolvers to, but never past its validity.
+	ttl := namesys.DefaultResolverCacheTTL
+	if entry.Ttl != nil {
+		ttl = time.Duration(entry.GetTtl())
+	}
+	if eol, err := ipns.GetEOL(&entry); err == nil {
+		if untilEOL := time.Until(eol); untilEOL < ttl {
+			ttl = untilEOL
+		}
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	i.addUserHeaders(w)
+	w.Header().Set("X-IPFS-Path", urlPath)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ttl.Seconds())))
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	name := key + ".ipns-record"
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Content-Type", ipnsRecordResponseFormat)
+
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(rawRecord))
+}
@@ -0,0 +1,245 @@
+package corehttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	gopath "path"
+	"sort"
+	"strconv"
+	"strings"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// redirectsFilename is the name of the file, at the root of a website, that
+// holds the redirect and rewrite rules for the site.
+const redirectsFilename = "_redirects"
+
+// maxRedirectsFileSize bounds how much of a _redirects file is read.
+const maxRedirectsFileSize = 64 << 10
+
+// redirectsAppliedKey marks requests that were already rewritten by a
+// _redirects rule, so rewrites can't loop.
+type redirectsAppliedKey struct{}
+
+// redirectRule is a single line of a Netlify-style _redirects file:
+//
+//	/from/:placeholder/*  /to/:placeholder/:splat  [status]
+//
+// From may contain :placeholders matching a single path segment and a
+// trailing * matching the rest of the path. Status is one of 200 (rewrite),
+// 301 or 302 (redirect) and 404 (serve To as a not found page), and defaults
+// to 301.
+type redirectRule struct {
+	From   string
+	To     string
+	Status int
+}
+
+// parseRedirects parses the contents of a _redirects file. Blank lines and
+// lines starting with # are ignored.
+func parseRedirects(r io.Reader) ([]redirectRule, error) {
+	var rules []redirectRule
+	s := bufio.NewScanner(io.LimitReader(r, maxRedirectsFileSize))
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected 'from to [status]', got %q", lineNo, line)
+		}
+
+		rule := redirectRule{
+			From:   fields[0],
+			To:     fields[1],
+			Status: http.StatusMovedPermanently,
+		}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid status %q", lineNo, fields[2])
+			}
+			rule.Status = status
+		}
+
+		if !strings.HasPrefix(rule.From, "/") {
+			return nil, fmt.Errorf("line %d: 'from' must be an absolute path: %q", lineNo, rule.From)
+		}
+		if i := strings.Index(rule.From, "*"); i >= 0 && i != len(rule.From)-1 {
+			return nil, fmt.Errorf("line %d: splat '*' is only allowed at the end of 'from': %q", lineNo, rule.From)
+		}
+
+		isURL := strings.HasPrefix(rule.To, "http://") || strings.HasPrefix(rule.To, "https://")
+		if !isURL && !strings.HasPrefix(rule.To, "/") {
+			return nil, fmt.Errorf("line %d: 'to' must be an absolute path or URL: %q", lineNo, rule.To)
+		}
+
+		switch rule.Status {
+		case http.StatusMovedPermanently, http.StatusFound:
+		case http.StatusOK, http.StatusNotFound:
+			if isURL {
+				return nil, fmt.Errorf("line %d: status %d requires 'to' to be a path: %q", lineNo, rule.Status, rule.To)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unsupported status %d", lineNo, rule.Status)
+		}
+
+		rules = append(rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// match checks urlPath (relative to the website root) against the rule and
+// returns the expanded target.
+func (rule redirectRule) match(urlPath string) (string, bool) {
+	from := strings.Split(strings.Trim(rule.From, "/"), "/")
+	segs := strings.Split(strings.Trim(urlPath, "/"), "/")
+
+	placeholders := make(map[string]string)
+	matched := false
+	for i, f := range from {
+		if f == "*" {
+			placeholders["splat"] = strings.Join(segs[i:], "/")
+			matched = true
+			break
+		}
+		if i >= len(segs) {
+			return "", false
+		}
+		if strings.HasPrefix(f, ":") && len(f) > 1 {
+			placeholders[f[1:]] = segs[i]
+			continue
+		}
+		if f != segs[i] {
+			return "", false
+		}
+	}
+	if !matched && len(from) != len(segs) {
+		return "", false
+	}
+
+	// Replace the longest names first so :id doesn't clobber :identifier.
+	names := make([]string, 0, len(placeholders))
+	for name := range placeholders {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	to := rule.To
+	for _, name := range names {
+		to = strings.ReplaceAll(to, ":"+name, placeholders[name])
+	}
+	return to, true
+}
+
+// serveRedirectsIfPresent evaluates the _redirects file at the root of a
+// website against a request for a path that could not be resolved. It is
+// only honored for DNSLink and subdomain gateway requests, where the website
+// has its own origin. Returns true if a response was written.
+func (i *gatewayHandler) serveRedirectsIfPresent(w http.ResponseWriter, r *http.Request, urlPath string) bool {
+	if _, ok := r.Context().Value("gw-hostname").(string); !ok {
+		return false
+	}
+	if r.Context().Value(redirectsAppliedKey{}) != nil {
+		return false
+	}
+
+	// urlPath is /{ns}/{root}/{rest...}
+	segs := strings.SplitN(strings.TrimPrefix(urlPath, "/"), "/", 3)
+	if len(segs) < 2 {
+		return false
+	}
+	rootPath := "/" + segs[0] + "/" + segs[1]
+	relPath := "/"
+	if len(segs) == 3 {
+		relPath += segs[2]
+	}
+
+	rules, ok, err := i.getRedirectRules(r.Context(), rootPath)
+	if err != nil {
+		internalWebError(w, fmt.Errorf("could not parse %s: %w", redirectsFilename, err))
+		return true
+	}
+	if !ok {
+		return false
+	}
+
+	for _, rule := range rules {
+		to, ok := rule.match(relPath)
+		if !ok {
+			continue
+		}
+		log.Debugw("_redirects rule matched", "from", rule.From, "to", to, "status", rule.Status, "path", urlPath)
+
+		switch rule.Status {
+		case http.StatusMovedPermanently, http.StatusFound:
+			http.Redirect(w, r, to, rule.Status)
+			return true
+		case http.StatusOK:
+			target, err := url.Parse(to)
+			if err != nil {
+				internalWebError(w, err)
+				return true
+			}
+			// Serve the rewritten path as if it had been requested, but
+			// don't evaluate _redirects for it again.
+			rr := r.Clone(context.WithValue(r.Context(), redirectsAppliedKey{}, true))
+			rr.URL.Path = gopath.Join(rootPath, target.Path)
+			if target.RawQuery != "" {
+				rr.URL.RawQuery = target.RawQuery
+			}
+			i.getOrHeadHandler(w, rr)
+			return true
+		case http.StatusNotFound:
+			target := strings.SplitN(to, "?", 2)[0]
+			resolved, err := i.resolvePath(r.Context(), ipath.New(gopath.Join(rootPath, target)))
+			if err != nil {
+				return false
+			}
+			ctype := mime.TypeByExtension(gopath.Ext(target))
+			if ctype == "" {
+				ctype = "text/html"
+			}
+			return i.serveNotFoundFile(w, r, resolved, ctype)
+		}
+	}
+	return false
+}
+
+// getRedirectRules loads and parses the _redirects file at rootPath. ok is
+// false if the website has no _redirects file.
+func (i *gatewayHandler) getRedirectRules(ctx context.Context, rootPath string) (rules []redirectRule, ok bool, err error) {
+	resolved, err := i.resolvePath(ctx, ipath.New(gopath.Join(rootPath, redirectsFilename)))
+	if err != nil {
+		return nil, false, nil
+	}
+
+	node, err := i.api.Unixfs().Get(ctx, resolved)
+	if err != nil {
+		return nil, false, err
+	}
+	defer node.Close()
+
+	f, ok := node.(files.File)
+	if !ok {
+		return nil, false, fmt.Errorf("%s is not a file", redirectsFilename)
+	}
+
+	rules, err = parseRedirects(f)
+	if err != nil {
+		return nil, false, err
+	}
+	return rules, true, nil
+}
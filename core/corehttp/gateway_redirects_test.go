@@ -0,0 +1,161 @@
+package corehttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	files "github.com/ipfs/go-ipfs-files"
+	path "github.com/ipfs/go-path"
+)
+
+func TestParseRedirects(t *testing.T) {
+	rules, err := parseRedirects(strings.NewReader(`
+# comment
+/home              /
+/blog/:year/:slug  /posts/:year-:slug.html  302
+/app/*             /app/index.html          200
+/gone/*            /404.html                404
+/ext               https://example.com/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []redirectRule{
+		{"/home", "/", http.StatusMovedPermanently},
+		{"/blog/:year/:slug", "/posts/:year-:slug.html", http.StatusFound},
+		{"/app/*", "/app/index.html", http.StatusOK},
+		{"/gone/*", "/404.html", http.StatusNotFound},
+		{"/ext", "https://example.com/", http.StatusMovedPermanently},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("expected %d rules, got %d", len(expected), len(rules))
+	}
+	for i, rule := range rules {
+		if rule != expected[i] {
+			t.Errorf("rule %d: expected %v, got %v", i, expected[i], rule)
+		}
+	}
+
+	for _, bad := range []string{
+		"/only-from",
+		"/a /b 307",
+		"/a /b three",
+		"relative /b",
+		"/a relative",
+		"/a/*/b /b",
+		"/a https://example.com/ 200",
+		"/a /b 301 extra",
+	} {
+		if _, err := parseRedirects(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestRedirectRuleMatch(t *testing.T) {
+	for _, test := range []struct {
+		from, to string
+		path     string
+		expected string
+		ok       bool
+	}{
+		{"/home", "/", "/home", "/", true},
+		{"/home", "/", "/home/", "/", true},
+		{"/home", "/", "/home/more", "", false},
+		{"/blog/:year/:slug", "/posts/:year-:slug.html", "/blog/2021/hello", "/posts/2021-hello.html", true},
+		{"/blog/:year/:slug", "/posts/:year-:slug.html", "/blog/2021", "", false},
+		{"/:id/:identifier", "/:identifier/:id", "/a/b", "/b/a", true},
+		{"/app/*", "/app/index.html", "/app/some/deep/route", "/app/index.html", true},
+		{"/old/*", "/new/:splat", "/old/a/b", "/new/a/b", true},
+		{"/*", "/index.html", "/anything", "/index.html", true},
+	} {
+		rule := redirectRule{From: test.from, To: test.to, Status: http.StatusMovedPermanently}
+		to, ok := rule.match(test.path)
+		if ok != test.ok || to != test.expected {
+			t.Errorf("%s -> %s on %s: expected (%q, %t), got (%q, %t)", test.from, test.to, test.path, test.expected, test.ok, to, ok)
+		}
+	}
+}
+
+func TestGatewayRedirects(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+
+	f1 := files.NewMapDirectory(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte(`
+/redirect-one   /one.html
+/found          /one.html  302
+/app/*          /app.html  200
+/gone/*         /404.html  404
+`)),
+		"one.html": files.NewBytesFile([]byte("one")),
+		"app.html": files.NewBytesFile([]byte("app")),
+		"404.html": files.NewBytesFile([]byte("custom not found")),
+	})
+
+	k, err := api.Unixfs().Add(ctx, f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := "example.net"
+	ns["/ipns/"+host] = path.FromString(k.String())
+
+	for _, test := range []struct {
+		path     string
+		status   int
+		location string
+		text     string
+	}{
+		{"/one.html", http.StatusOK, "", "one"},
+		{"/redirect-one", http.StatusMovedPermanently, "/one.html", ""},
+		{"/found", http.StatusFound, "/one.html", ""},
+		{"/app/some/route", http.StatusOK, "", "app"},
+		{"/gone/away", http.StatusNotFound, "", "custom not found"},
+		{"/unmatched", http.StatusNotFound, "", ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		resp, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != test.status {
+			t.Fatalf("got %d, expected %d, from %s", resp.StatusCode, test.status, test.path)
+		}
+		if loc := resp.Header.Get("Location"); loc != test.location {
+			t.Fatalf("got location %q, expected %q, from %s", loc, test.location, test.path)
+		}
+		if test.text == "" {
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("error reading response from %s: %s", test.path, err)
+		}
+		if string(body) != test.text {
+			t.Fatalf("unexpected response body from %s: got %q, expected %q", test.path, body, test.text)
+		}
+	}
+
+	// _redirects is ignored on path gateways, which don't provide origin isolation
+	req, err := http.NewRequest(http.MethodGet, ts.URL+k.String()+"/redirect-one", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := doWithoutRedirect(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %d, expected 404 for path gateway request", resp.StatusCode)
+	}
+}
@@ -2,8 +2,12 @@ package corehttp
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
@@ -21,11 +25,14 @@ import (
 	syncds "github.com/ipfs/go-datastore/sync"
 	files "github.com/ipfs/go-ipfs-files"
 	config "github.com/ipfs/go-ipfs/config"
+	ipns "github.com/ipfs/go-ipns"
 	path "github.com/ipfs/go-path"
 	iface "github.com/ipfs/interface-go-ipfs-core"
 	nsopts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
 	ci "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
 )
 
@@ -706,6 +713,317 @@ func TestGoGetSupport(t *testing.T) {
 	}
 }
 
+func TestVerifiableResponseFormats(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"file.txt": files.NewBytesFile([]byte("verifiable")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileCid, err := api.ResolvePath(ctx, ipath.Join(k, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockReader, err := api.Block().Get(ctx, fileCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ioutil.ReadAll(blockReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// raw block, requested with ?format= and with the Accept header
+	for _, req := range []struct {
+		url    string
+		accept string
+	}{
+		{ts.URL + k.String() + "/file.txt?format=raw", ""},
+		{ts.URL + k.String() + "/file.txt", "application/vnd.ipld.raw"},
+	} {
+		r, err := http.NewRequest(http.MethodGet, req.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.accept != "" {
+			r.Header.Set("Accept", req.accept)
+		}
+		res, err := doWithoutRedirect(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status is %d, expected 200: %s", res.StatusCode, body)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.raw" {
+			t.Fatalf("unexpected Content-Type: %s", ct)
+		}
+		if string(body) != string(block) {
+			t.Fatalf("raw response does not match the block")
+		}
+	}
+
+	// CAR
+	res, err := http.Get(ts.URL + k.String() + "?format=car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status is %d, expected 200", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/vnd.ipld.car") {
+		t.Fatalf("unexpected Content-Type: %s", ct)
+	}
+	car, err := gocar.NewCarReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(car.Header.Roots) != 1 || !car.Header.Roots[0].Equals(k.Cid()) {
+		t.Fatalf("unexpected CAR roots: %v", car.Header.Roots)
+	}
+	var blocks int
+	for {
+		_, err := car.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks++
+	}
+	if blocks != 2 {
+		t.Fatalf("expected 2 blocks in the CAR, got %d", blocks)
+	}
+
+	// unknown format
+	res, err = http.Get(ts.URL + k.String() + "?format=nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status is %d, expected 400", res.StatusCode)
+	}
+}
+
+func TestTrustlessGateway(t *testing.T) {
+	n, err := newNodeWithMockNamesys(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(newGatewayHandler(GatewayConfig{Trustless: true}, api, n.Routing))
+	t.Cleanup(func() { ts.Close() })
+
+	k, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("verifiable")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{k.String(), http.StatusNotAcceptable},
+		{emptyDir + "/", http.StatusNotAcceptable},
+		{k.String() + "?format=raw", http.StatusOK},
+		{k.String() + "?format=car", http.StatusOK},
+		{emptyDir + "/nope?format=raw", http.StatusNotFound},
+	} {
+		res, err := http.Get(ts.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Fatalf("%s: status is %d, expected %d: %s", test.path, res.StatusCode, test.status, body)
+		}
+		if test.status == http.StatusNotAcceptable && !strings.Contains(string(body), "?format=car") {
+			t.Fatalf("%s: expected a helpful error, got %q", test.path, body)
+		}
+	}
+}
+
+func TestIpnsRecordResponse(t *testing.T) {
+	n, err := newNodeWithMockNamesys(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(newGatewayHandler(GatewayConfig{Trustless: true}, api, n.Routing))
+	t.Cleanup(func() { ts.Close() })
+
+	sk, pk, err := ci.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := ipns.Create(sk, []byte(emptyDir), 1, time.Now().Add(time.Hour), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawRecord, err := entry.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Routing.PutValue(n.Context(), ipns.RecordKey(pid), rawRecord); err != nil {
+		t.Fatal(err)
+	}
+	_, unknownPk, err := ci.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := peer.IDFromPublicKey(unknownPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []struct {
+		url    string
+		accept string
+	}{
+		{ts.URL + "/ipns/" + pid.String() + "?format=ipns-record", ""},
+		{ts.URL + "/ipns/" + peer.ToCid(pid).String(), "application/vnd.ipfs.ipns-record"},
+	} {
+		r, err := http.NewRequest(http.MethodGet, req.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.accept != "" {
+			r.Header.Set("Accept", req.accept)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status is %d, expected 200: %s", res.StatusCode, body)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipfs.ipns-record" {
+			t.Fatalf("unexpected Content-Type: %s", ct)
+		}
+		if cc := res.Header.Get("Cache-Control"); cc != "public, max-age=300" {
+			t.Fatalf("unexpected Cache-Control: %s", cc)
+		}
+		if string(body) != string(rawRecord) {
+			t.Fatal("response does not match the published record")
+		}
+	}
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/ipns/example.net?format=ipns-record", http.StatusBadRequest},
+		{"/ipns/" + pid.String() + "/sub?format=ipns-record", http.StatusBadRequest},
+		{emptyDir + "?format=ipns-record", http.StatusBadRequest},
+		{"/ipns/" + unknown.String() + "?format=ipns-record", http.StatusNotFound},
+	} {
+		res, err := http.Get(ts.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Fatalf("%s: status is %d, expected %d", test.path, res.StatusCode, test.status)
+		}
+	}
+}
+
+func TestGatewayRangeRequests(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	data := make([]byte, 1024*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// single range
+	req, err := http.NewRequest(http.MethodGet, ts.URL+k.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=500000-500099")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status is %d, expected 206", res.StatusCode)
+	}
+	if string(body) != string(data[500000:500100]) {
+		t.Fatal("range response does not match")
+	}
+
+	// multiple ranges
+	req.Header.Set("Range", "bytes=10-19, 700000-700009, -5")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status is %d, expected 206", res.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/byteranges" {
+		t.Fatalf("unexpected Content-Type: %s", mediaType)
+	}
+	mr := multipart.NewReader(res.Body, params["boundary"])
+	for _, expected := range [][]byte{data[10:20], data[700000:700010], data[len(data)-5:]} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(expected) {
+			t.Fatalf("part %s does not match", part.Header.Get("Content-Range"))
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("expected 3 parts, got more (%v)", err)
+	}
+}
+
 func TestVersion(t *testing.T) {
 	version.CurrentCommit = "theshortcommithash"
 
@@ -73,6 +73,8 @@ func HostnameOption() ServeOption {
 
 			// HTTP Host & Path check: is this one of our  "known gateways"?
 			if gw, ok := isKnownHostname(host, knownGateways); ok {
+				r = withTrustlessContext(r, gw)
+
 				// This is a known gateway but request is not using
 				// the subdomain feature.
 
@@ -128,6 +130,7 @@ func HostnameOption() ServeOption {
 			// /ipns/ example: {libp2p-key}.ipns.localhost:8080, {inlined-dnslink-fqdn}.ipns.dweb.link
 			if gw, gwHostname, ns, rootID, ok := knownSubdomainDetails(host, knownGateways); ok {
 				// Looks like we're using a known gateway in subdomain mode.
+				r = withTrustlessContext(r, gw)
 
 				// Assemble original path prefix.
 				pathPrefix := "/" + ns + "/" + rootID
@@ -253,6 +256,16 @@ func withHostnameContext(r *http.Request, hostname string) *http.Request {
 	return r.WithContext(ctx)
 }
 
+// Extends request context to flag requests made to a known gateway that only
+// serves verifiable responses
+func withTrustlessContext(r *http.Request, gw *config.GatewaySpec) *http.Request {
+	if !gw.Trustless {
+		return r
+	}
+	ctx := context.WithValue(r.Context(), "gw-trustless", true)
+	return r.WithContext(ctx)
+}
+
 func prepareKnownGateways(publicGateways map[string]*config.GatewaySpec) gatewayHosts {
 	var hosts gatewayHosts
 
@@ -0,0 +1,297 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+// prefetchWindow bounds how far ahead of the current read position blocks
+// are fetched, within a requested byte range.
+const prefetchWindow = 4 << 20
+
+// maxPrefetchRanges is the number of byte ranges of a single request that
+// are used as prefetch hints. Requests with more ranges are still served, but
+// blocks are only fetched as they are read.
+const maxPrefetchRanges = 16
+
+// httpRange is a [start, end) byte range requested with the Range header.
+type httpRange struct {
+	start, end int64
+}
+
+// unixfsRangeReader is a lazy io.ReadSeeker over a UnixFS file DAG. Unlike a
+// DagReader it doesn't stream the file from the start: every read descends
+// from the root using the block sizes stored in the intermediate nodes, so
+// only the leaves covering the bytes actually read are fetched. Leaves within
+// the requested byte ranges are fetched ahead of the reader, in parallel, by
+// a single prefetch at a time, which Close stops.
+type unixfsRangeReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	dag    ipld.NodeGetter
+	root   ipld.Node
+	size   int64
+	ranges []httpRange
+
+	offset int64
+
+	// chunk holds the data of the leaf that was read last
+	chunk      []byte
+	chunkStart int64
+
+	prefetchedUntil int64
+	// prefetching is closed when the running prefetch is done, nil if none
+	// was started
+	prefetching chan struct{}
+}
+
+func newUnixfsRangeReader(ctx context.Context, ng ipld.NodeGetter, root ipld.Node, size int64, ranges []httpRange) *unixfsRangeReader {
+	if len(ranges) > maxPrefetchRanges {
+		ranges = nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &unixfsRangeReader{
+		ctx:    ctx,
+		cancel: cancel,
+		dag:    ng,
+		root:   root,
+		size:   size,
+		ranges: ranges,
+	}
+}
+
+func (r *unixfsRangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekEnd:
+		offset += r.size
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekStart:
+	default:
+		return r.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return r.offset, fmt.Errorf("invalid seek offset")
+	}
+	r.offset = offset
+	return r.offset, nil
+}
+
+func (r *unixfsRangeReader) Read(b []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.offset < r.chunkStart || r.offset >= r.chunkStart+int64(len(r.chunk)) {
+		r.maybePrefetch()
+		if err := r.loadChunk(r.offset); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, r.chunk[r.offset-r.chunkStart:])
+	if n == 0 && len(b) > 0 {
+		// the leaf is shorter than its parent claims
+		return 0, io.ErrUnexpectedEOF
+	}
+	r.offset += int64(n)
+	return n, nil
+}
+
+// Close stops the prefetch, and waits for it.
+func (r *unixfsRangeReader) Close() error {
+	r.cancel()
+	if r.prefetching != nil {
+		<-r.prefetching
+	}
+	return nil
+}
+
+// loadChunk finds the leaf holding the byte at offset and makes it the
+// current chunk.
+func (r *unixfsRangeReader) loadChunk(offset int64) error {
+	nd := r.root
+	start := int64(0)
+	for {
+		switch n := nd.(type) {
+		case *dag.RawNode:
+			r.chunk, r.chunkStart = n.RawData(), start
+			if offset >= start+int64(len(n.RawData())) {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		case *dag.ProtoNode:
+			fsn, err := ft.FSNodeFromBytes(n.Data())
+			if err != nil {
+				return err
+			}
+			// Nodes may carry data before their children.
+			data := fsn.Data()
+			if offset < start+int64(len(data)) || len(n.Links()) == 0 {
+				r.chunk, r.chunkStart = data, start
+				if offset >= start+int64(len(data)) {
+					return io.ErrUnexpectedEOF
+				}
+				return nil
+			}
+			if fsn.NumChildren() != len(n.Links()) {
+				return ft.ErrMalformedFileFormat
+			}
+
+			childStart := start + int64(len(data))
+			var next ipld.Node
+			for i, l := range n.Links() {
+				childSize := int64(fsn.BlockSize(i))
+				if offset < childStart+childSize {
+					next, err = l.GetNode(r.ctx, r.dag)
+					if err != nil {
+						return err
+					}
+					break
+				}
+				childStart += childSize
+			}
+			if next == nil {
+				return io.ErrUnexpectedEOF
+			}
+			nd, start = next, childStart
+		default:
+			return ft.ErrUnrecognizedType
+		}
+	}
+}
+
+// maybePrefetch starts fetching, in the background, the leaves between the
+// current offset and the end of the requested range that contains it (at most
+// prefetchWindow bytes ahead), unless the previous prefetch is still running.
+func (r *unixfsRangeReader) maybePrefetch() {
+	if r.offset < r.prefetchedUntil {
+		return
+	}
+	if r.prefetching != nil {
+		select {
+		case <-r.prefetching:
+		default:
+			return
+		}
+	}
+	for _, rng := range r.ranges {
+		if r.offset < rng.start || r.offset >= rng.end {
+			continue
+		}
+		end := rng.end
+		if end > r.offset+prefetchWindow {
+			end = r.offset + prefetchWindow
+		}
+		r.prefetchedUntil = end
+		done := make(chan struct{})
+		r.prefetching = done
+		go func(from, to int64) {
+			defer close(done)
+			if err := r.prefetch(r.root, 0, from, to); err != nil {
+				log.Debugw("range prefetch failed", "from", from, "to", to, "error", err)
+			}
+		}(r.offset, end)
+		return
+	}
+}
+
+// prefetch fetches the children of nd intersecting [from, to) in parallel,
+// one DAG level at a time.
+func (r *unixfsRangeReader) prefetch(nd ipld.Node, start, from, to int64) error {
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok || len(pn.Links()) == 0 {
+		return nil
+	}
+	fsn, err := ft.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return err
+	}
+	if fsn.NumChildren() != len(pn.Links()) {
+		return ft.ErrMalformedFileFormat
+	}
+
+	var cids []cid.Cid
+	var starts []int64
+	childStart := start + int64(len(fsn.Data()))
+	for i, l := range pn.Links() {
+		childSize := int64(fsn.BlockSize(i))
+		if childStart < to && childStart+childSize > from {
+			cids = append(cids, l.Cid)
+			starts = append(starts, childStart)
+		}
+		childStart += childSize
+	}
+
+	nodes := make(map[cid.Cid]ipld.Node, len(cids))
+	for opt := range r.dag.GetMany(r.ctx, cids) {
+		if opt.Err != nil {
+			return opt.Err
+		}
+		nodes[opt.Node.Cid()] = opt.Node
+	}
+	for i, c := range cids {
+		if err := r.prefetch(nodes[c], starts[i], from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseRangeHeader parses a "bytes=" Range header into [start, end) ranges.
+// It is only used for prefetch hints: invalid headers yield no ranges and
+// are reported to the client by http.ServeContent.
+func parseRangeHeader(s string, size int64) []httpRange {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil
+	}
+	var ranges []httpRange
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = strings.TrimSpace(ra)
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil
+		}
+		startStr, endStr := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+		var rng httpRange
+		if startStr == "" {
+			// suffix range: the last n bytes
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || n < 0 {
+				return nil
+			}
+			if n > size {
+				n = size
+			}
+			rng = httpRange{start: size - n, end: size}
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil
+			}
+			rng = httpRange{start: start, end: size}
+			if endStr != "" {
+				end, err := strconv.ParseInt(endStr, 10, 64)
+				if err != nil || end < start {
+					return nil
+				}
+				if end+1 < size {
+					rng.end = end + 1
+				}
+			}
+		}
+		if rng.start < rng.end {
+			ranges = append(ranges, rng)
+		}
+	}
+	return ranges
+}
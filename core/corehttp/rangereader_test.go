@@ -0,0 +1,264 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	ft "github.com/ipfs/go-unixfs"
+	bal "github.com/ipfs/go-unixfs/importer/balanced"
+	h "github.com/ipfs/go-unixfs/importer/helpers"
+	trickle "github.com/ipfs/go-unixfs/importer/trickle"
+)
+
+// countingNodeGetter records which blocks were fetched
+type countingNodeGetter struct {
+	ipld.NodeGetter
+
+	lk      sync.Mutex
+	fetched map[cid.Cid]int
+}
+
+func (c *countingNodeGetter) Get(ctx context.Context, k cid.Cid) (ipld.Node, error) {
+	c.lk.Lock()
+	c.fetched[k]++
+	c.lk.Unlock()
+	return c.NodeGetter.Get(ctx, k)
+}
+
+func (c *countingNodeGetter) GetMany(ctx context.Context, ks []cid.Cid) <-chan *ipld.NodeOption {
+	c.lk.Lock()
+	for _, k := range ks {
+		c.fetched[k]++
+	}
+	c.lk.Unlock()
+	return c.NodeGetter.GetMany(ctx, ks)
+}
+
+func (c *countingNodeGetter) count() int {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return len(c.fetched)
+}
+
+func buildTestFile(t *testing.T, data []byte, rawLeaves bool, layout func(*h.DagBuilderHelper) (ipld.Node, error)) (ipld.DAGService, ipld.Node) {
+	ds := mdtest.Mock()
+	dbp := h.DagBuilderParams{
+		Dagserv:   ds,
+		Maxlinks:  8,
+		RawLeaves: rawLeaves,
+	}
+	db, err := dbp.New(chunker.NewSizeSplitter(bytes.NewReader(data), 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := layout(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds, nd
+}
+
+func TestUnixfsRangeReader(t *testing.T) {
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	size := int64(len(data))
+
+	for _, layout := range []struct {
+		name      string
+		rawLeaves bool
+		layout    func(*h.DagBuilderHelper) (ipld.Node, error)
+	}{
+		{"balanced", false, bal.Layout},
+		{"balanced-raw-leaves", true, bal.Layout},
+		{"trickle", false, trickle.Layout},
+	} {
+		t.Run(layout.name, func(t *testing.T) {
+			ds, root := buildTestFile(t, data, layout.rawLeaves, layout.layout)
+			ctx := context.Background()
+
+			r := newUnixfsRangeReader(ctx, ds, root, size, nil)
+			all, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(all, data) {
+				t.Fatal("full read does not match")
+			}
+
+			for _, rng := range []httpRange{{0, 1}, {255, 257}, {1000, 5000}, {size - 10, size}, {50000, 50001}} {
+				if _, err := r.Seek(rng.start, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, rng.end-rng.start)
+				if _, err := io.ReadFull(r, buf); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf, data[rng.start:rng.end]) {
+					t.Fatalf("range %v does not match", rng)
+				}
+			}
+		})
+	}
+}
+
+func TestUnixfsRangeReaderFetchesOnlyNeededBlocks(t *testing.T) {
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	size := int64(len(data))
+
+	ds, root := buildTestFile(t, data, true, bal.Layout)
+	ctx := context.Background()
+
+	ng := &countingNodeGetter{NodeGetter: ds, fetched: make(map[cid.Cid]int)}
+	r := newUnixfsRangeReader(ctx, ng, root, size, nil)
+
+	// A range within a single 256 byte chunk only needs the path from the
+	// root to the leaf: 100KiB in 400 chunks with 8 links per node is 3
+	// levels below the root.
+	if _, err := r.Seek(60000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data[60000:60016]) {
+		t.Fatal("range does not match")
+	}
+	if n := ng.count(); n > 3 {
+		t.Fatalf("expected at most 3 blocks to be fetched, got %d", n)
+	}
+}
+
+func TestUnixfsRangeReaderInconsistentBlockSize(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock()
+
+	// the first leaf has 4 bytes, while its parent claims 8
+	leaves := []ipld.Node{dag.NewRawNode([]byte("abcd")), dag.NewRawNode([]byte("efgh"))}
+	fsn := ft.NewFSNode(ft.TFile)
+	fsn.AddBlockSize(8)
+	fsn.AddBlockSize(4)
+	data, err := fsn.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := dag.NodeWithData(data)
+	for _, l := range leaves {
+		if err := ds.Add(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink("", l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, offset := range []int64{4, 6} {
+		r := newUnixfsRangeReader(ctx, ds, root, 12, nil)
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+			t.Fatalf("offset %d: expected io.ErrUnexpectedEOF, got %v", offset, err)
+		}
+		r.Close()
+	}
+}
+
+// blockingNodeGetter serves the root, and blocks the other fetches until
+// their context is done.
+type blockingNodeGetter struct {
+	ipld.NodeGetter
+}
+
+func (b *blockingNodeGetter) GetMany(ctx context.Context, ks []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, 1)
+	go func() {
+		defer close(out)
+		<-ctx.Done()
+		out <- &ipld.NodeOption{Err: ctx.Err()}
+	}()
+	return out
+}
+
+func TestUnixfsRangeReaderClose(t *testing.T) {
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	size := int64(len(data))
+	ds, root := buildTestFile(t, data, true, bal.Layout)
+
+	r := newUnixfsRangeReader(context.Background(), &blockingNodeGetter{ds}, root, size, []httpRange{{0, 1000}, {50000, size}})
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	prefetching := r.prefetching
+	if prefetching == nil {
+		t.Fatal("expected a prefetch to be started")
+	}
+
+	// the next range does not start another prefetch while it runs
+	if _, err := r.Seek(50000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if r.prefetching != prefetching {
+		t.Fatal("expected a single prefetch at a time")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not stop the prefetch")
+	}
+	select {
+	case <-prefetching:
+	default:
+		t.Fatal("expected the prefetch to be done")
+	}
+}
+
+func TestParseRangeHeader(t *testing.T) {
+	for _, test := range []struct {
+		header   string
+		expected []httpRange
+	}{
+		{"", nil},
+		{"items=0-1", nil},
+		{"bytes=0-99", []httpRange{{0, 100}}},
+		{"bytes=100-", []httpRange{{100, 1000}}},
+		{"bytes=-100", []httpRange{{900, 1000}}},
+		{"bytes=0-0, 500-599,-1", []httpRange{{0, 1}, {500, 600}, {999, 1000}}},
+		{"bytes=900-5000", []httpRange{{900, 1000}}},
+		{"bytes=5-1", nil},
+		{"bytes=nope", nil},
+	} {
+		ranges := parseRangeHeader(test.header, 1000)
+		if len(ranges) != len(test.expected) {
+			t.Fatalf("%q: expected %v, got %v", test.header, test.expected, ranges)
+		}
+		for i := range ranges {
+			if ranges[i] != test.expected[i] {
+				t.Fatalf("%q: expected %v, got %v", test.header, test.expected, ranges)
+			}
+		}
+	}
+}
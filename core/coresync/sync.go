@@ -0,0 +1,551 @@
+// Package coresync keeps the copy of a local directory in IPFS up to date
+// with its files, and publishes its new root to MFS or IPNS.
+package coresync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	fsnotify "github.com/fsnotify/fsnotify"
+	cid "github.com/ipfs/go-cid"
+	filestore "github.com/ipfs/go-filestore"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+)
+
+var log = logging.Logger("coresync")
+
+// DefaultDelay is how long the changes are collected before they are added,
+// when it is not set.
+const DefaultDelay = time.Second
+
+// Options configure the syncing of a directory.
+type Options struct {
+	// MFSPath is the MFS path pointed at the root of the directory, if set.
+	// Otherwise the root is pinned.
+	MFSPath string
+	// IPNSKey is the name of the key the root of the directory is published
+	// with, if set.
+	IPNSKey string
+
+	// Nocopy adds the files to the filestore, whose paths are relative to
+	// FilestoreRoot, instead of copying them. The directory must then be
+	// inside FilestoreRoot.
+	Nocopy        bool
+	FilestoreRoot string
+	// Hidden adds the hidden files.
+	Hidden bool
+
+	// Delay is how long the changes are collected before they are added.
+	Delay time.Duration
+}
+
+// Syncer adds a local directory, and the files changed in it since.
+type Syncer struct {
+	n    *core.IpfsNode
+	api  coreiface.CoreAPI
+	dir  string
+	opts Options
+
+	// root is the node of the directory, once added
+	root ipld.Node
+}
+
+// New returns a Syncer of the local directory dir.
+func New(n *core.IpfsNode, dir string, opts Options) (*Syncer, error) {
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		return nil, err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MFSPath != "" && !strings.HasPrefix(opts.MFSPath, "/") {
+		return nil, fmt.Errorf("the MFS path %s is not absolute", opts.MFSPath)
+	}
+	if opts.Nocopy && n.Filestore == nil {
+		return nil, filestore.ErrFilestoreNotEnabled
+	}
+	if opts.Nocopy && !inDir(opts.FilestoreRoot, dir) {
+		return nil, fmt.Errorf("%s is outside the filestore root %s", dir, opts.FilestoreRoot)
+	}
+	if opts.Delay == 0 {
+		opts.Delay = DefaultDelay
+	}
+	return &Syncer{n: n, api: api, dir: dir, opts: opts}, nil
+}
+
+// inDir returns whether path is root or one of its descendants.
+func inDir(root, path string) bool {
+	if root == "" {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Root returns the node of the directory, or nil before it is added.
+func (s *Syncer) Root() ipld.Node {
+	return s.root
+}
+
+// Resume starts from the node at the MFS path, if it exists: the updates
+// apply to it, and the next Sync replaces it, removing the filestore objects
+// of its files that changed. It returns whether it does.
+func (s *Syncer) Resume(ctx context.Context) (bool, error) {
+	if s.opts.MFSPath == "" {
+		return false, nil
+	}
+	fsn, err := mfs.Lookup(s.n.FilesRoot, s.opts.MFSPath)
+	if err == os.ErrNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !mfs.IsDir(fsn) {
+		return false, fmt.Errorf("%s is not a directory", s.opts.MFSPath)
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return false, err
+	}
+	s.root = nd
+	return true, nil
+}
+
+// Sync adds the whole directory, and publishes its root.
+func (s *Syncer) Sync(ctx context.Context) (ipld.Node, error) {
+	// the blocks added are not pinned until they are published
+	defer s.n.Blockstore.PinLock(ctx).Unlock(ctx)
+	return s.sync(ctx)
+}
+
+func (s *Syncer) sync(ctx context.Context) (ipld.Node, error) {
+	if s.root != nil && s.opts.Nocopy {
+		if err := s.invalidate(ctx, []string{s.dir}); err != nil {
+			return nil, err
+		}
+	}
+	nd, err := s.add(ctx, s.dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.publish(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+// Update adds the local paths changed in the directory, removing the ones
+// that do not exist anymore, and publishes the new root.
+func (s *Syncer) Update(ctx context.Context, paths []string) (ipld.Node, error) {
+	defer s.n.Blockstore.PinLock(ctx).Unlock(ctx)
+	if s.root == nil {
+		return s.sync(ctx)
+	}
+	paths = s.changed(paths)
+	if len(paths) > 0 && paths[0] == s.dir {
+		return s.sync(ctx)
+	}
+
+	pn, ok := s.root.(*dag.ProtoNode)
+	if !ok {
+		return nil, dag.ErrNotProtobuf
+	}
+	rt, err := mfs.NewRoot(ctx, s.n.DAG, pn, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.Nocopy {
+		if err := s.invalidate(ctx, paths); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range paths {
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return nil, err
+		}
+		rel = "/" + filepath.ToSlash(rel)
+
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			log.Debugf("removing %s", p)
+			if err := unlink(rt, rel); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		log.Debugf("adding %s", p)
+		nd, err := s.add(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if err := put(rt, rel, nd); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := rt.GetDirectory()
+	if err := dir.Flush(); err != nil {
+		return nil, err
+	}
+	nd, err := dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.publish(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+// Watch updates the directory with its changes until ctx is done, and calls
+// report with the new root, or the error, after every update.
+func (s *Syncer) Watch(ctx context.Context, report func(ipld.Node, error)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := s.watchTree(w, s.dir); err != nil {
+		return err
+	}
+
+	pending := make(map[string]struct{})
+	var timer *time.Timer
+	var fire <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			name := e.Name
+			if filepath.Base(name) == coreunix.IgnoreFileName {
+				// the rules changed for the whole directory
+				name = filepath.Dir(name)
+			}
+			if e.Op == fsnotify.Chmod || s.excluded(name) {
+				continue
+			}
+			if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				// a renamed directory is watched again with its new name,
+				// on its Create event
+				_ = w.Remove(e.Name)
+			}
+			if st, err := os.Lstat(e.Name); err == nil && st.IsDir() && e.Op&fsnotify.Create != 0 {
+				if err := s.watchTree(w, e.Name); err != nil {
+					report(nil, err)
+				}
+			}
+			pending[name] = struct{}{}
+			// wait for the writes to settle
+			if timer == nil {
+				timer = time.NewTimer(s.opts.Delay)
+			} else {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(s.opts.Delay)
+			}
+			fire = timer.C
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			report(nil, err)
+		case <-fire:
+			fire = nil
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, p)
+			}
+			pending = make(map[string]struct{})
+			report(s.Update(ctx, paths))
+		}
+	}
+}
+
+// watchTree watches the directories of root that are added.
+func (s *Syncer) watchTree(w *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// removed since
+			return nil
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if p != s.dir && s.excluded(p) {
+			return filepath.SkipDir
+		}
+		return w.Add(p)
+	})
+}
+
+// excluded returns whether the local path p is not part of the directory, as
+// it is hidden or ignored.
+func (s *Syncer) excluded(p string) bool {
+	rel, err := filepath.Rel(s.dir, p)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return true
+	}
+	if !s.opts.Hidden {
+		for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+			if strings.HasPrefix(name, ".") && name != "." {
+				return true
+			}
+		}
+	}
+	ignored, err := coreunix.IgnoredPath(s.dir, p)
+	return err != nil || ignored
+}
+
+// changed returns the paths to add or remove, sorted, without the ones
+// under another one, which are added with it.
+func (s *Syncer) changed(paths []string) []string {
+	sort.Strings(paths)
+	var out []string
+	for _, p := range paths {
+		if s.excluded(p) {
+			continue
+		}
+		if len(out) > 0 {
+			last := out[len(out)-1]
+			if strings.HasPrefix(p, last+string(filepath.Separator)) {
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// add adds the file or directory at the local path p.
+func (s *Syncer) add(ctx context.Context, p string) (ipld.Node, error) {
+	st, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := files.NewSerialFile(p, s.opts.Hidden, st)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if d, ok := f.(files.Directory); ok {
+		if f, err = coreunix.FilterIgnoredAt(s.dir, p, d); err != nil {
+			return nil, err
+		}
+	}
+
+	added, err := s.api.Unixfs().Add(ctx, f,
+		options.Unixfs.Nocopy(s.opts.Nocopy),
+		options.Unixfs.Pin(false),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.api.Dag().Get(ctx, added.Cid())
+}
+
+// invalidate removes the filestore objects of the files at paths, or under
+// them, whose data changed. They are the raw leaves of the entries at paths
+// in the previous root, which are walked without reading the leaves.
+func (s *Syncer) invalidate(ctx context.Context, paths []string) error {
+	getLinks := dag.GetLinksWithDAG(s.n.DAG)
+	rawLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		if c.Type() == cid.Raw {
+			return nil, nil
+		}
+		return getLinks(ctx, c)
+	}
+
+	var keys []cid.Cid
+	set := cid.NewSet()
+	for _, p := range paths {
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		c, err := s.lookup(ctx, filepath.ToSlash(rel))
+		if err == os.ErrNotExist {
+			// a new file
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = dag.Walk(ctx, rawLinks, c, func(c cid.Cid) bool {
+			if !set.Visit(c) {
+				return false
+			}
+			if c.Type() == cid.Raw {
+				keys = append(keys, c)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, k := range keys {
+		switch r := filestore.Verify(ctx, s.n.Filestore, k); r.Status {
+		case filestore.StatusOk, filestore.StatusKeyNotFound:
+			// unchanged, or not a filestore object
+			continue
+		}
+		log.Debugf("removing the changed filestore object %s", k)
+		if err := s.n.Filestore.FileManager().DeleteBlock(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the CID of the entry at the slash separated path rel in the
+// previous root, or os.ErrNotExist. Only the directories are read.
+func (s *Syncer) lookup(ctx context.Context, rel string) (cid.Cid, error) {
+	c := s.root.Cid()
+	if rel == "." {
+		return c, nil
+	}
+	for _, name := range strings.Split(rel, "/") {
+		nd, err := s.n.DAG.Get(ctx, c)
+		if err != nil {
+			return cid.Undef, err
+		}
+		dir, err := uio.NewDirectoryFromNode(s.n.DAG, nd)
+		if err == uio.ErrNotADir {
+			return cid.Undef, os.ErrNotExist
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+		links, err := dir.Links(ctx)
+		if err != nil {
+			return cid.Undef, err
+		}
+		found := false
+		for _, l := range links {
+			if l.Name == name {
+				c, found = l.Cid, true
+				break
+			}
+		}
+		if !found {
+			return cid.Undef, os.ErrNotExist
+		}
+	}
+	return c, nil
+}
+
+// publish points the MFS path or the pin, and the IPNS key, to nd. The pin
+// lock has to be held, since the blocks added are pinned here.
+func (s *Syncer) publish(ctx context.Context, nd ipld.Node) error {
+	old := s.root
+	s.root = nd
+	p := ipath.IpfsPath(nd.Cid())
+
+	if s.opts.MFSPath != "" {
+		dir := gopath.Dir(s.opts.MFSPath)
+		if dir != "/" {
+			if err := mfs.Mkdir(s.n.FilesRoot, dir, mfs.MkdirOpts{Mkparents: true}); err != nil {
+				return err
+			}
+		}
+		if err := unlink(s.n.FilesRoot, s.opts.MFSPath); err != nil {
+			return err
+		}
+		if err := mfs.PutNode(s.n.FilesRoot, s.opts.MFSPath, nd); err != nil {
+			return err
+		}
+		if _, err := mfs.FlushPath(ctx, s.n.FilesRoot, s.opts.MFSPath); err != nil {
+			return err
+		}
+	} else if old == nil {
+		if err := s.n.Pinning.Pin(ctx, nd, true); err != nil {
+			return err
+		}
+		if err := s.n.Pinning.Flush(ctx); err != nil {
+			return err
+		}
+	} else if !old.Cid().Equals(nd.Cid()) {
+		if err := s.n.Pinning.Update(ctx, old.Cid(), nd.Cid(), true); err != nil {
+			return err
+		}
+		if err := s.n.Pinning.Flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.IPNSKey != "" {
+		if _, err := s.api.Name().Publish(ctx, p, options.Name.Key(s.opts.IPNSKey), options.Name.AllowOffline(true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put replaces the entry at p, in rt, with nd.
+func put(rt *mfs.Root, p string, nd ipld.Node) error {
+	dir := gopath.Dir(p)
+	if dir != "/" {
+		if err := mfs.Mkdir(rt, dir, mfs.MkdirOpts{Mkparents: true}); err != nil {
+			return err
+		}
+	}
+	if err := unlink(rt, p); err != nil {
+		return err
+	}
+	return mfs.PutNode(rt, p, nd)
+}
+
+// unlink removes the entry at p from rt, if it exists.
+func unlink(rt *mfs.Root, p string) error {
+	dir, name := gopath.Split(p)
+	fsn, err := mfs.Lookup(rt, dir)
+	if err == os.ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pdir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if err := pdir.Unlink(name); err != nil && err != os.ErrNotExist {
+		return err
+	}
+	return nil
+}
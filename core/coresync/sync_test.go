@@ -0,0 +1,289 @@
+package coresync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	filestore "github.com/ipfs/go-filestore"
+	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-mfs"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+
+	config "github.com/ipfs/go-ipfs/config"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/repo"
+)
+
+func newFilestoreNode(t *testing.T, root string) *core.IpfsNode {
+	c := config.Config{}
+	c.Identity.PeerID = "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe"
+	c.Experimental.FilestoreEnabled = true
+
+	ds := syncds.MutexWrap(datastore.NewMapDatastore())
+	fm := filestore.NewFileManager(ds, root)
+	fm.AllowFiles = true
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{
+		Repo: &repo.Mock{
+			C: c,
+			D: ds,
+			K: keystore.NewMemKeystore(),
+			F: fm,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func writeFiles(t *testing.T, dir string, tree map[string]string) {
+	for name, data := range tree {
+		fpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fpath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the files of the directory nd, with their contents.
+func readTree(t *testing.T, n *core.IpfsNode, nd ipld.Node) string {
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := api.Unixfs().Get(context.Background(), ipath.IpfsPath(nd.Cid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	err = files.Walk(f, func(fpath string, nd files.Node) error {
+		switch nd := nd.(type) {
+		case files.File:
+			data, err := ioutil.ReadAll(nd)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, fpath+"="+string(data))
+		case files.Directory:
+			if fpath != "" {
+				entries = append(entries, fpath+"/")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// checkFilestore checks that every object of the filestore matches its file.
+func checkFilestore(t *testing.T, n *core.IpfsNode) {
+	ctx := context.Background()
+	next, err := filestore.VerifyAll(ctx, n.Filestore, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for r := next(ctx); r != nil; r = next(ctx) {
+		if r.Status != filestore.StatusOk {
+			t.Errorf("%s of %s is %s", r.Key, r.FilePath, r.Status.Format())
+		}
+		count++
+	}
+	if count == 0 {
+		t.Error("the filestore is empty")
+	}
+}
+
+func TestSyncUpdate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	n := newFilestoreNode(t, filepath.Dir(dir))
+	writeFiles(t, dir, map[string]string{
+		".ipfsignore": "*.log\n",
+		".hidden":     "h",
+		"a.txt":       "a",
+		"x.log":       "x",
+		"sub/b.txt":   "b",
+	})
+
+	s, err := New(n, dir, Options{
+		MFSPath:       "/sync/dir",
+		Nocopy:        true,
+		FilestoreRoot: filepath.Dir(dir),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed, err := s.Resume(ctx); err != nil || resumed {
+		t.Fatalf("expected nothing to resume, got %t, %v", resumed, err)
+	}
+	nd, err := s.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "a.txt=a,sub/,sub/b.txt=b"
+	if got := readTree(t, n, nd); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+	checkFilestore(t, n)
+
+	writeFiles(t, dir, map[string]string{
+		"a.txt":     "changed",
+		"c.txt":     "c",
+		"y.log":     "y",
+		"sub/d.txt": "d",
+	})
+	if err := os.Remove(filepath.Join(dir, "sub", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, name := range []string{"a.txt", "c.txt", "y.log", "sub/b.txt", "sub/d.txt", "sub"} {
+		paths = append(paths, filepath.Join(dir, filepath.FromSlash(name)))
+	}
+	nd, err = s.Update(ctx, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "a.txt=changed,c.txt=c,sub/,sub/d.txt=d"
+	if got := readTree(t, n, nd); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+	// the objects of the old a.txt and of sub/b.txt are gone
+	checkFilestore(t, n)
+
+	fsn, err := mfs.Lookup(n.FilesRoot, "/sync/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnd, err := fsn.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mnd.Cid().Equals(nd.Cid()) {
+		t.Fatalf("expected /sync/dir to be %s, got %s", nd.Cid(), mnd.Cid())
+	}
+
+	// a new syncer starts from the MFS path
+	s, err = New(n, dir, Options{
+		MFSPath:       "/sync/dir",
+		Nocopy:        true,
+		FilestoreRoot: filepath.Dir(dir),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed, err := s.Resume(ctx); err != nil || !resumed {
+		t.Fatalf("expected to resume, got %t, %v", resumed, err)
+	}
+	if !s.Root().Cid().Equals(nd.Cid()) {
+		t.Fatalf("expected to resume from %s, got %s", nd.Cid(), s.Root().Cid())
+	}
+
+	// the files changed while not watched
+	writeFiles(t, dir, map[string]string{"sub/d.txt": "changed"})
+	nd, err = s.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "a.txt=changed,c.txt=c,sub/,sub/d.txt=changed"
+	if got := readTree(t, n, nd); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+	// the object of the old sub/d.txt is gone
+	checkFilestore(t, n)
+}
+
+func TestNewOutsideFilestoreRoot(t *testing.T) {
+	root := t.TempDir()
+	n := newFilestoreNode(t, root)
+
+	for _, dir := range []string{root, filepath.Join(root, "sub")} {
+		if _, err := New(n, dir, Options{Nocopy: true, FilestoreRoot: root}); err != nil {
+			t.Fatalf("%s: %s", dir, err)
+		}
+	}
+	for _, dir := range []string{t.TempDir(), root + "2", filepath.Dir(root)} {
+		if _, err := New(n, dir, Options{Nocopy: true, FilestoreRoot: root}); err == nil {
+			t.Fatalf("expected %s to be rejected", dir)
+		}
+	}
+	if _, err := New(n, root, Options{Nocopy: true}); err == nil {
+		t.Fatal("expected a directory without filestore root to be rejected")
+	}
+}
+
+func TestSyncWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	n := newFilestoreNode(t, filepath.Dir(dir))
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+
+	s, err := New(n, dir, Options{Delay: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := make(chan ipld.Node, 16)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Watch(ctx, func(nd ipld.Node, err error) {
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			roots <- nd
+		})
+	}()
+	// let the watcher start
+	time.Sleep(100 * time.Millisecond)
+
+	waitFor := func(expected string) {
+		timeout := time.After(10 * time.Second)
+		for got := ""; got != expected; {
+			select {
+			case nd := <-roots:
+				got = readTree(t, n, nd)
+			case <-timeout:
+				t.Fatalf("expected %s, got %s", expected, got)
+			}
+		}
+	}
+
+	writeFiles(t, dir, map[string]string{"new/b.txt": "b"})
+	waitFor("a.txt=a,new/,new/b.txt=b")
+
+	// the files of a renamed directory are watched with their new path
+	if err := os.Rename(filepath.Join(dir, "new"), filepath.Join(dir, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("a.txt=a,moved/,moved/b.txt=b")
+	writeFiles(t, dir, map[string]string{"moved/c.txt": "c"})
+	waitFor("a.txt=a,moved/,moved/b.txt=b,moved/c.txt=c")
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	gopath "path"
 	"strconv"
 
@@ -24,6 +25,8 @@ import (
 	"github.com/ipfs/go-unixfs/importer/trickle"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/ipfs/interface-go-ipfs-core/path"
+
+	"github.com/ipfs/go-ipfs/core/coreunix/metadata"
 )
 
 var log = logging.Logger("coreunix")
@@ -79,6 +82,27 @@ type Adder struct {
 	tempRoot   cid.Cid
 	CidBuilder cid.Builder
 	liveNodes  uint64
+
+	// Checkpoints, when set, records the files added so that Resume can
+	// skip them if the add is interrupted and run again.
+	Checkpoints  *Checkpoints
+	Resume       bool
+	checkpointFP string
+
+	// PreserveMode and PreserveMtime record the mode and modification time
+	// of the files and directories of the local filesystem in their nodes.
+	PreserveMode  bool
+	PreserveMtime bool
+	// Wrapped is set when the added directory only wraps the files, so that
+	// it gets no metadata.
+	Wrapped bool
+	// Concurrency is the number of files chunked and hashed at once, 0 or 1
+	// adding them one after the other. The DAG and the events do not depend
+	// on it.
+	Concurrency int
+	pipeline    *addPipeline
+
+	metaDirs []metaDir
 }
 
 func (adder *Adder) mfsRoot() (*mfs.Root, error) {
@@ -107,8 +131,19 @@ func (adder *Adder) add(reader io.Reader) (ipld.Node, error) {
 		return nil, err
 	}
 
+	bufferedDS := adder.bufferedDS
+	if adder.Concurrency > 1 {
+		spl := newReadAheadSplitter(chnk)
+		defer spl.close()
+		chnk = spl
+	}
+	if adder.pipeline != nil {
+		// the files are added concurrently, each one with its own batch
+		bufferedDS = ipld.NewBufferedDAG(adder.ctx, adder.dagService)
+	}
+
 	params := ihelper.DagBuilderParams{
-		Dagserv:    adder.bufferedDS,
+		Dagserv:    bufferedDS,
 		RawLeaves:  adder.RawLeaves,
 		Maxlinks:   ihelper.DefaultLinksPerBlock,
 		NoCopy:     adder.NoCopy,
@@ -129,7 +164,7 @@ func (adder *Adder) add(reader io.Reader) (ipld.Node, error) {
 		return nil, err
 	}
 
-	return nd, adder.bufferedDS.Commit()
+	return nd, bufferedDS.Commit()
 }
 
 // RootNode returns the mfs root node
@@ -182,7 +217,9 @@ func (adder *Adder) PinRoot(root ipld.Node) error {
 	return adder.pinning.Flush(adder.ctx)
 }
 
-func (adder *Adder) outputDirs(path string, fsn mfs.FSNode) error {
+// outputDirs outputs the directories below fsn, and fsn itself as nd if it is
+// not nil.
+func (adder *Adder) outputDirs(path string, fsn mfs.FSNode, nd ipld.Node) error {
 	switch fsn := fsn.(type) {
 	case *mfs.File:
 		return nil
@@ -199,16 +236,18 @@ func (adder *Adder) outputDirs(path string, fsn mfs.FSNode) error {
 			}
 
 			childpath := gopath.Join(path, name)
-			err = adder.outputDirs(childpath, child)
+			err = adder.outputDirs(childpath, child, nil)
 			if err != nil {
 				return err
 			}
 
 			fsn.Uncache(name)
 		}
-		nd, err := fsn.GetNode()
-		if err != nil {
-			return err
+		if nd == nil {
+			var err error
+			if nd, err = fsn.GetNode(); err != nil {
+				return err
+			}
 		}
 
 		return outputDagnode(adder.Out, path, nd)
@@ -264,7 +303,28 @@ func (adder *Adder) AddAllAndPin(ctx context.Context, file files.Node) (ipld.Nod
 		}
 	}()
 
-	if err := adder.addFileNode(ctx, "", file, true); err != nil {
+	if adder.Concurrency > 1 {
+		if adder.Checkpoints != nil && adder.checkpointFP == "" {
+			// compute the fingerprint before the workers need it
+			fp, err := adder.fingerprint()
+			if err != nil {
+				return nil, err
+			}
+			adder.checkpointFP = fp
+		}
+		adder.pipeline = newAddPipeline(adder, adder.Concurrency)
+	}
+	err := adder.addFileNode(ctx, "", file, true)
+	if adder.pipeline != nil {
+		if perr := adder.pipeline.wait(); err == nil {
+			err = perr
+		}
+		adder.pipeline = nil
+	}
+	if err == nil {
+		err = adder.setDirsMetadata()
+	}
+	if err != nil {
 		return nil, err
 	}
 
@@ -313,9 +373,24 @@ func (adder *Adder) AddAllAndPin(ctx context.Context, file files.Node) (ipld.Nod
 	if err != nil {
 		return nil, err
 	}
+	if dir && !adder.Wrapped {
+		// the root directory is the mfs root, which has no parent to update
+		md, err := adder.metadataFor("", file)
+		if err != nil {
+			return nil, err
+		}
+		if !md.IsZero() {
+			if nd, err = metadata.Set(nd, md); err != nil {
+				return nil, err
+			}
+			if err := adder.dagService.Add(adder.ctx, nd); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	// output directory events
-	err = adder.outputDirs(name, root)
+	err = adder.outputDirs(name, root, nd)
 	if err != nil {
 		return nil, err
 	}
@@ -327,39 +402,70 @@ func (adder *Adder) AddAllAndPin(ctx context.Context, file files.Node) (ipld.Nod
 		}
 	}
 
-	if !adder.Pin {
-		return nd, nil
+	if adder.Pin {
+		if err := adder.PinRoot(nd); err != nil {
+			return nil, err
+		}
+	}
+
+	if adder.Checkpoints != nil {
+		if err := adder.Checkpoints.clear(ctx); err != nil {
+			return nil, err
+		}
 	}
-	return nd, adder.PinRoot(nd)
+	return nd, nil
+}
+
+// commit runs op after the operations committed before it: at once when the
+// files are added sequentially, by the pipeline otherwise.
+func (adder *Adder) commit(op func() error) error {
+	if adder.pipeline == nil {
+		return op()
+	}
+	return adder.pipeline.commit(op)
 }
 
 func (adder *Adder) addFileNode(ctx context.Context, path string, file files.Node, toplevel bool) error {
 	defer file.Close()
 
-	err := adder.maybePauseForGC(ctx)
-	if err != nil {
-		return err
-	}
-
-	if adder.liveNodes >= liveCacheSize {
-		// TODO: A smarter cache that uses some sort of lru cache with an eviction handler
-		mr, err := adder.mfsRoot()
-		if err != nil {
+	if adder.pipeline != nil {
+		if err := adder.pipeline.maybePauseForGC(ctx); err != nil {
 			return err
 		}
-		if err := mr.FlushMemFree(adder.ctx); err != nil {
-			return err
+	}
+	err := adder.commit(func() error {
+		if adder.pipeline == nil {
+			if err := adder.maybePauseForGC(ctx); err != nil {
+				return err
+			}
 		}
 
-		adder.liveNodes = 0
+		if adder.liveNodes >= liveCacheSize {
+			// TODO: A smarter cache that uses some sort of lru cache with an eviction handler
+			mr, err := adder.mfsRoot()
+			if err != nil {
+				return err
+			}
+			if err := mr.FlushMemFree(adder.ctx); err != nil {
+				return err
+			}
+
+			adder.liveNodes = 0
+		}
+		adder.liveNodes++
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	adder.liveNodes++
 
 	switch f := file.(type) {
 	case files.Directory:
 		return adder.addDir(ctx, path, f, toplevel)
 	case *files.Symlink:
-		return adder.addSymlink(path, f)
+		return adder.commit(func() error {
+			return adder.addSymlink(path, f)
+		})
 	case files.File:
 		return adder.addFile(path, f)
 	default:
@@ -384,22 +490,86 @@ func (adder *Adder) addSymlink(path string, l *files.Symlink) error {
 }
 
 func (adder *Adder) addFile(path string, file files.File) error {
-	// if the progress flag was specified, wrap the file so that we can send
-	// progress updates to the client (over the output channel)
-	var reader io.Reader = file
+	if adder.pipeline != nil {
+		return adder.pipeline.addFile(path, file)
+	}
+
+	var out chan<- interface{}
 	if adder.Progress {
-		rdr := &progressReader{file: reader, path: path, out: adder.Out}
-		if fi, ok := file.(files.FileInfo); ok {
-			reader = &progressReader2{rdr, fi}
-		} else {
-			reader = rdr
+		out = adder.Out
+	}
+	dagnode, _, err := adder.fileNode(path, file, out)
+	if err != nil {
+		return err
+	}
+	return adder.addFileMetadata(dagnode, path, file)
+}
+
+// fileNode returns the node of the content of file, resumed from its
+// checkpoint or added, and its size. The progress is sent to out if it is not
+// nil.
+func (adder *Adder) fileNode(path string, file files.File, out chan<- interface{}) (ipld.Node, int64, error) {
+	fc, err := adder.checkpointFor(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	if fc != nil && adder.Resume {
+		dagnode, err := adder.Checkpoints.resume(adder.ctx, adder.dagService, fc)
+		if err != nil {
+			return nil, 0, err
+		}
+		if dagnode != nil {
+			log.Debugf("resuming add of %s from checkpoint %s", path, dagnode.Cid())
+			if out != nil {
+				out <- &coreiface.AddEvent{
+					Name:  path,
+					Bytes: fc.entry.Size,
+				}
+			}
+			return dagnode, fc.entry.Size, nil
 		}
 	}
 
+	// wrap the file so that we can send progress updates to the client (over
+	// the output channel)
+	var reader io.Reader
+	rdr := &progressReader{file: file, path: path, out: out}
+	if fi, ok := file.(files.FileInfo); ok {
+		reader = &progressReader2{rdr, fi}
+	} else {
+		reader = rdr
+	}
+
 	dagnode, err := adder.add(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the checkpoint records the content only, the metadata may have changed
+	// when resuming
+	if fc != nil {
+		if err := adder.Checkpoints.save(adder.ctx, fc, dagnode.Cid()); err != nil {
+			return nil, 0, err
+		}
+	}
+	return dagnode, rdr.bytes, nil
+}
+
+// addFileMetadata adds dagnode as the file at path, with the metadata of the
+// local file if it is preserved.
+func (adder *Adder) addFileMetadata(dagnode ipld.Node, path string, file files.File) error {
+	md, err := adder.metadataFor(path, file)
 	if err != nil {
 		return err
 	}
+	if !md.IsZero() {
+		if dagnode, err = metadata.Set(dagnode, md); err != nil {
+			return err
+		}
+		if err := adder.dagService.Add(adder.ctx, dagnode); err != nil {
+			return err
+		}
+	}
 
 	// patch it into the root
 	return adder.addNode(dagnode, path)
@@ -409,14 +579,16 @@ func (adder *Adder) addDir(ctx context.Context, path string, dir files.Directory
 	log.Infof("adding directory: %s", path)
 
 	if !(toplevel && path == "") {
-		mr, err := adder.mfsRoot()
-		if err != nil {
-			return err
-		}
-		err = mfs.Mkdir(mr, path, mfs.MkdirOpts{
-			Mkparents:  true,
-			Flush:      false,
-			CidBuilder: adder.CidBuilder,
+		err := adder.commit(func() error {
+			mr, err := adder.mfsRoot()
+			if err != nil {
+				return err
+			}
+			return mfs.Mkdir(mr, path, mfs.MkdirOpts{
+				Mkparents:  true,
+				Flush:      false,
+				CidBuilder: adder.CidBuilder,
+			})
 		})
 		if err != nil {
 			return err
@@ -431,8 +603,111 @@ func (adder *Adder) addDir(ctx context.Context, path string, dir files.Directory
 			return err
 		}
 	}
+	if it.Err() != nil {
+		return it.Err()
+	}
+
+	if path != "" && (adder.PreserveMode || adder.PreserveMtime) {
+		adder.metaDirs = append(adder.metaDirs, metaDir{path, dir})
+	}
+	return nil
+}
+
+// metaDir is a directory whose metadata is set once all the files are added.
+type metaDir struct {
+	path string
+	dir  files.Directory
+}
 
-	return it.Err()
+// setDirsMetadata sets the metadata of the directories added, once all their
+// files are. They are set from the deepest, as the directories are replaced
+// in their parent.
+func (adder *Adder) setDirsMetadata() error {
+	for _, d := range adder.metaDirs {
+		md, err := adder.metadataFor(d.path, d.dir)
+		if err != nil {
+			return err
+		}
+		if md.IsZero() {
+			continue
+		}
+		if err := adder.setDirMetadata(d.path, md); err != nil {
+			return err
+		}
+	}
+	adder.metaDirs = nil
+	return nil
+}
+
+// setDirMetadata replaces the directory at path with a copy holding md, once
+// all of its entries were added.
+func (adder *Adder) setDirMetadata(path string, md metadata.FileMetadata) error {
+	mr, err := adder.mfsRoot()
+	if err != nil {
+		return err
+	}
+	fsn, err := mfs.Lookup(mr, path)
+	if err != nil {
+		return err
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return err
+	}
+	if nd, err = metadata.Set(nd, md); err != nil {
+		return err
+	}
+
+	dirp, name := gopath.Split(path)
+	parent, err := mfs.Lookup(mr, dirp)
+	if err != nil {
+		return err
+	}
+	pdir, ok := parent.(*mfs.Directory)
+	if !ok {
+		return fmt.Errorf("%s is not a directory", dirp)
+	}
+	if err := pdir.Unlink(name); err != nil {
+		return err
+	}
+	return mfs.PutNode(mr, path, nd)
+}
+
+// ErrNotLocal is returned when preserving the metadata of files that carry
+// none, like the files sent to the daemon, of which only the content is sent.
+var ErrNotLocal = errors.New("the mode and mtime of the files are only preserved when they are added from the filesystem of the node")
+
+func notLocal(path string) error {
+	if path == "" {
+		return ErrNotLocal
+	}
+	return fmt.Errorf("%s: %w", path, ErrNotLocal)
+}
+
+// metadataFor returns the metadata to record for the node added at path, which
+// is empty unless the metadata is preserved.
+func (adder *Adder) metadataFor(path string, node files.Node) (metadata.FileMetadata, error) {
+	if !adder.PreserveMode && !adder.PreserveMtime {
+		return metadata.FileMetadata{}, nil
+	}
+	st, err := localStat(path, node)
+	if err != nil {
+		return metadata.FileMetadata{}, err
+	}
+	return metadata.FromStat(st, adder.PreserveMode, adder.PreserveMtime), nil
+}
+
+// localStat returns the stat of the local file or directory added at path. It
+// fails with ErrNotLocal when the node has none: the files sent to the daemon
+// only carry the path they have on the client, which the daemon does not
+// stat, as it is not the one of its own files.
+func localStat(path string, node files.Node) (os.FileInfo, error) {
+	if s, ok := node.(interface{ Stat() os.FileInfo }); ok {
+		if st := s.Stat(); st != nil {
+			return st, nil
+		}
+	}
+	return nil, notLocal(path)
 }
 
 func (adder *Adder) maybePauseForGC(ctx context.Context) error {
@@ -501,7 +776,7 @@ func (i *progressReader) Read(p []byte) (int, error) {
 	n, err := i.file.Read(p)
 
 	i.bytes += int64(n)
-	if i.bytes-i.lastProgress >= progressReaderIncrement || err == io.EOF {
+	if i.out != nil && (i.bytes-i.lastProgress >= progressReaderIncrement || err == io.EOF) {
 		i.lastProgress = i.bytes
 		i.out <- &coreiface.AddEvent{
 			Name:  i.path,
@@ -3,15 +3,19 @@ package coreunix
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix/metadata"
 	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/repo"
 
@@ -19,11 +23,13 @@ import (
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 	syncds "github.com/ipfs/go-datastore/sync"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	files "github.com/ipfs/go-ipfs-files"
 	pi "github.com/ipfs/go-ipfs-posinfo"
 	config "github.com/ipfs/go-ipfs/config"
+	ipld "github.com/ipfs/go-ipld-format"
 	dag "github.com/ipfs/go-merkledag"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 )
@@ -253,6 +259,103 @@ func TestAddGCLive(t *testing.T) {
 	}
 }
 
+func TestAddGCLiveConcurrent(t *testing.T) {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: testPeerID, // required by offline node
+			},
+		},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adder, err := NewAdder(context.Background(), node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Out = make(chan interface{}, 16)
+	adder.Silent = true
+	adder.Concurrency = 4
+
+	// the pipes hold the add before and after the pause for GC
+	piper1, pipew1 := io.Pipe()
+	piper2, pipew2 := io.Pipe()
+	slf := files.NewMapDirectory(map[string]files.Node{
+		"a": files.NewBytesFile([]byte("testfileA")),
+		"b": files.NewReaderFile(piper1),
+		"c": files.NewBytesFile([]byte("testfileC")),
+		"d": files.NewReaderFile(piper2),
+	})
+
+	var root ipld.Node
+	addDone := make(chan struct{})
+	go func() {
+		defer close(addDone)
+		var err error
+		if root, err = adder.AddAllAndPin(context.Background(), slf); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if _, err := pipew1.Write([]byte("some data for file b")); err != nil {
+		t.Fatal(err)
+	}
+	gcstarted := make(chan (<-chan gc.Result))
+	go func() {
+		gcstarted <- gc.GC(context.Background(), node.Blockstore, node.Repo.Datastore(), node.Pinning, nil)
+	}()
+	time.Sleep(time.Millisecond * 100) // make sure gc gets to requesting lock
+	pipew1.Close()
+
+	// the GC runs once a and b are committed, while d is still being read
+	timeout := time.After(5 * time.Second)
+	var gcout <-chan gc.Result
+	select {
+	case gcout = <-gcstarted:
+	case <-timeout:
+		t.Fatal("gc did not start during the add")
+	}
+	removed := make(map[string]struct{})
+	for done := false; !done; {
+		select {
+		case r, ok := <-gcout:
+			if !ok {
+				done = true
+				break
+			}
+			if r.Error != nil {
+				t.Fatal(r.Error)
+			}
+			removed[r.KeyRemoved.String()] = struct{}{}
+		case <-timeout:
+			t.Fatal("gc did not run during the add")
+		}
+	}
+
+	pipew2.Close()
+	<-addDone
+	if root == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	set := cid.NewSet()
+	err = dag.Walk(ctx, dag.GetLinksWithDAG(node.DAG), root.Cid(), func(c cid.Cid) bool {
+		if _, ok := removed[c.String()]; ok {
+			t.Errorf("gc'ed %s, which was added", c)
+		}
+		return set.Visit(c)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func testAddWPosInfo(t *testing.T, rawLeaves bool) {
 	r := &repo.Mock{
 		C: config.Config{
@@ -366,3 +469,380 @@ func (fi *dummyFileInfo) Mode() os.FileMode  { return 0 }
 func (fi *dummyFileInfo) ModTime() time.Time { return fi.modTime }
 func (fi *dummyFileInfo) IsDir() bool        { return false }
 func (fi *dummyFileInfo) Sys() interface{}   { return nil }
+
+func TestAddResume(t *testing.T) {
+	ctx := context.Background()
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: testPeerID, // required by offline node
+			},
+		},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	node, err := core.NewNode(ctx, &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(fpath, []byte("some data for file a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("some data for file b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newAdder := func(resume bool) (*Adder, chan interface{}) {
+		adder, err := NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(chan interface{}, 10)
+		adder.Out = out
+		adder.Checkpoints = NewCheckpoints(node.Repo.Datastore(), node.Blockstore)
+		adder.Resume = resume
+		return adder, out
+	}
+
+	// add only the first file, as if the add was interrupted
+	f, err := files.NewSerialFile(fpath, false, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupted, out := newAdder(true)
+	if err := interrupted.addFileNode(ctx, "a.txt", f, false); err != nil {
+		t.Fatal(err)
+	}
+	first := (<-out).(*coreiface.AddEvent).Path.Cid()
+
+	// same size and modification time: a resumed add must not read it again
+	if err := ioutil.WriteFile(fpath, []byte("other data of file a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(fpath, st.ModTime(), st.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	addDir := func(adder *Adder, out chan interface{}) map[string]cid.Cid {
+		dst, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := files.NewSerialFile(dir, false, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := adder.AddAllAndPin(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+		close(out)
+		added := make(map[string]cid.Cid)
+		for ev := range out {
+			ev := ev.(*coreiface.AddEvent)
+			added[ev.Name] = ev.Path.Cid()
+		}
+		return added
+	}
+
+	resumed, out := newAdder(true)
+	if added := addDir(resumed, out); added["a.txt"] != first || !added["b.txt"].Defined() {
+		t.Fatalf("expected a.txt to be resumed as %s, got %v", first, added)
+	}
+
+	if len(resumed.Checkpoints.written) != 0 {
+		t.Fatal("expected the checkpoints to be cleared once the add completed")
+	}
+	if added := addDir(newAdder(true)); added["a.txt"] == first {
+		t.Fatal("expected a.txt to be added again once its checkpoint was cleared")
+	}
+}
+
+func TestClearCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	node, err := core.NewNode(ctx, &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(t.TempDir(), "a.txt")
+	if err := ioutil.WriteFile(fpath, []byte("some data for file a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := files.NewSerialFile(fpath, false, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adder, err := NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Checkpoints = NewCheckpoints(node.Repo.Datastore(), node.Blockstore)
+	adder.Resume = true
+	// interrupted before completing
+	if err := adder.addFileNode(ctx, "a.txt", f, false); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func() int {
+		res, err := node.Repo.Datastore().Query(ctx, query.Query{Prefix: checkpointPrefix.String(), KeysOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("expected a checkpoint, got %d", n)
+	}
+	if err := ClearCheckpoints(ctx, node.Repo.Datastore()); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("expected the checkpoints to be cleared, got %d", n)
+	}
+}
+
+func TestAddPreserveMetadata(t *testing.T) {
+	ctx := context.Background()
+	node, err := core.NewNode(ctx, &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0750); err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(sub, "a.txt")
+	if err := ioutil.WriteFile(fpath, []byte("some data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(fpath, 0604); err != nil {
+		t.Fatal(err)
+	}
+	fileTime := time.Unix(1600000000, 123)
+	dirTime := time.Unix(1500000000, 0)
+	if err := os.Chtimes(fpath, fileTime, fileTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0710); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{sub, dir} {
+		if err := os.Chtimes(d, dirTime, dirTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := files.NewSerialFile(dir, false, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adder, err := NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.PreserveMode = true
+	adder.PreserveMtime = true
+	rnd, err := adder.AddAllAndPin(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(p string, mode os.FileMode, mtime time.Time) {
+		t.Helper()
+		nd := rnd
+		for _, name := range strings.Split(p, "/") {
+			if name == "" {
+				continue
+			}
+			lnk, _, err := nd.ResolveLink([]string{name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nd, err = lnk.GetNode(ctx, node.DAG); err != nil {
+				t.Fatal(err)
+			}
+		}
+		md, err := metadata.Read(nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !md.HasMode || md.Mode != mode || !md.ModTime.Equal(mtime) {
+			t.Fatalf("expected %q to have mode %s and mtime %s, got %+v", p, mode, mtime, md)
+		}
+	}
+	check("sub/a.txt", 0604, fileTime)
+	check("sub", 0750, dirTime)
+	check("", 0710, dirTime)
+}
+
+func TestAddPreserveMetadataNotLocal(t *testing.T) {
+	ctx := context.Background()
+	node, err := core.NewNode(ctx, &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(fpath, []byte("the daemon's file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(root files.Node) error {
+		adder, err := NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.PreserveMtime = true
+		_, err = adder.AddAllAndPin(ctx, root)
+		return err
+	}
+
+	// a file sent to the daemon only carries its path on the client, even
+	// when the daemon has a file at that path
+	rf, err := files.NewReaderPathFile(fpath, ioutil.NopCloser(strings.NewReader("the client's file")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = add(files.NewMapDirectory(map[string]files.Node{"a.txt": rf}))
+	if !errors.Is(err, ErrNotLocal) {
+		t.Fatalf("expected ErrNotLocal for a file sent to the daemon, got %v", err)
+	}
+
+	// nor does a directory sent to the daemon, or stdin
+	err = add(files.NewMapDirectory(map[string]files.Node{
+		"empty": files.NewMapDirectory(nil),
+	}))
+	if !errors.Is(err, ErrNotLocal) {
+		t.Fatalf("expected ErrNotLocal for a directory, got %v", err)
+	}
+	err = add(files.NewReaderFile(strings.NewReader("stdin")))
+	if !errors.Is(err, ErrNotLocal) {
+		t.Fatalf("expected ErrNotLocal for stdin, got %v", err)
+	}
+}
+
+func TestAddConcurrent(t *testing.T) {
+	ctx := context.Background()
+	node, err := core.NewNode(ctx, &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	contents := make(map[string][]byte)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("d%d/f%02d", i%3, i)
+		size := rnd.Intn(64 << 10)
+		if i%13 == 0 {
+			// read in order when streamed
+			size = parallelReadAhead + 1000
+		}
+		data := make([]byte, size)
+		rnd.Read(data)
+		contents[name] = data
+
+		fpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fpath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the files of the local filesystem, and as streamed to the daemon
+	local := func() files.Node {
+		st, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := files.NewSerialFile(dir, false, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	streamed := func() files.Node {
+		dirs := make(map[string]map[string]files.Node)
+		for name, data := range contents {
+			d, f := filepath.Split(name)
+			if dirs[d] == nil {
+				dirs[d] = make(map[string]files.Node)
+			}
+			dirs[d][f] = files.NewReaderFile(ioutil.NopCloser(bytes.NewReader(data)))
+		}
+		root := make(map[string]files.Node)
+		for d, entries := range dirs {
+			root[strings.TrimSuffix(d, "/")] = files.NewMapDirectory(entries)
+		}
+		return files.NewMapDirectory(root)
+	}
+
+	add := func(f files.Node, concurrency int) (cid.Cid, []string) {
+		adder, err := NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(chan interface{})
+		adder.Out = out
+		adder.Progress = true
+		adder.Chunker = "size-4096"
+		adder.Concurrency = concurrency
+
+		var events []string
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for ev := range out {
+				ev := ev.(*coreiface.AddEvent)
+				if ev.Path != nil {
+					events = append(events, ev.Name)
+				}
+			}
+		}()
+		nd, err := adder.AddAllAndPin(ctx, f)
+		close(out)
+		<-done
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nd.Cid(), events
+	}
+
+	expected, expectedEvents := add(local(), 1)
+	for _, tc := range []struct {
+		name string
+		f    func() files.Node
+	}{{"local", local}, {"streamed", streamed}} {
+		root, events := add(tc.f(), 8)
+		if root != expected {
+			t.Fatalf("%s: expected the concurrent add to give %s, got %s", tc.name, expected, root)
+		}
+		if strings.Join(events, ",") != strings.Join(expectedEvents, ",") {
+			t.Fatalf("%s: expected the events in order %v, got %v", tc.name, expectedEvents, events)
+		}
+	}
+}
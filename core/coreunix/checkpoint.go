@@ -0,0 +1,217 @@
+package coreunix
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// checkpointPrefix is the datastore namespace of the add checkpoints.
+var checkpointPrefix = datastore.NewKey("/local/add-checkpoints")
+
+var checkpointKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type resumeKey struct{}
+
+// WithResume returns a context resuming the adds done with it from the
+// checkpoints of previous adds, see Adder.Resume.
+func WithResume(ctx context.Context) context.Context {
+	return context.WithValue(ctx, resumeKey{}, true)
+}
+
+// Resuming returns whether adds done with ctx should be resumed.
+func Resuming(ctx context.Context) bool {
+	resume, _ := ctx.Value(resumeKey{}).(bool)
+	return resume
+}
+
+// Checkpoints stores the CID of every file added from the local filesystem,
+// so that an interrupted add can skip the files it already completed.
+//
+// A checkpoint is only used if the file still has the same size and
+// modification time, and its root block is still in the blockstore. The
+// checkpoints of an add are removed when it completes, and all of them by
+// ClearCheckpoints.
+type Checkpoints struct {
+	ds datastore.Datastore
+	bs bstore.Blockstore
+
+	lk sync.Mutex
+	// written are the checkpoints of the current add, removed once it
+	// completes.
+	written []datastore.Key
+}
+
+// NewCheckpoints returns checkpoints stored in ds for files added to bs.
+func NewCheckpoints(ds datastore.Datastore, bs bstore.Blockstore) *Checkpoints {
+	return &Checkpoints{ds: ds, bs: bs}
+}
+
+type checkpointEntry struct {
+	Size    int64
+	ModTime int64
+	Cid     string
+}
+
+// fileCheckpoint identifies the checkpoint of a file.
+type fileCheckpoint struct {
+	key   datastore.Key
+	entry checkpointEntry
+}
+
+// fingerprint identifies the options of the adder that change the CIDs of
+// the files, so that files added with other options are not resumed.
+func (adder *Adder) fingerprint() (string, error) {
+	builder := adder.CidBuilder
+	if builder == nil {
+		builder = dag.V0CidPrefix()
+	}
+	probe, err := builder.Sum([]byte("checkpoint"))
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%t\x00%t\x00%t", probe, adder.Chunker, adder.RawLeaves, adder.Trickle, adder.NoCopy)
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// checkpointFor returns the checkpoint of file, or nil if it is not a file of
+// the local filesystem.
+func (adder *Adder) checkpointFor(file files.File) (*fileCheckpoint, error) {
+	if adder.Checkpoints == nil {
+		return nil, nil
+	}
+	fi, ok := file.(files.FileInfo)
+	if !ok || fi.AbsPath() == "" {
+		return nil, nil
+	}
+	st := fi.Stat()
+	if st == nil {
+		// files sent to the daemon only carry their path
+		var err error
+		if st, err = os.Stat(fi.AbsPath()); err != nil {
+			return nil, nil
+		}
+	}
+	if !st.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	if adder.checkpointFP == "" {
+		fp, err := adder.fingerprint()
+		if err != nil {
+			return nil, err
+		}
+		adder.checkpointFP = fp
+	}
+	return &fileCheckpoint{
+		key: checkpointPrefix.ChildString(adder.checkpointFP).ChildString(checkpointKeyEncoding.EncodeToString([]byte(fi.AbsPath()))),
+		entry: checkpointEntry{
+			Size:    st.Size(),
+			ModTime: st.ModTime().UnixNano(),
+		},
+	}, nil
+}
+
+// resume returns the node of the file added by a previous add, or nil if it
+// has to be added again.
+func (cp *Checkpoints) resume(ctx context.Context, dserv ipld.DAGService, fc *fileCheckpoint) (ipld.Node, error) {
+	val, err := cp.ds.Get(ctx, fc.key)
+	switch err {
+	case nil:
+	case datastore.ErrNotFound:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+	var entry checkpointEntry
+	if err := json.Unmarshal(val, &entry); err != nil {
+		log.Warnf("ignoring invalid add checkpoint %s: %s", fc.key, err)
+		return nil, nil
+	}
+	if entry.Size != fc.entry.Size || entry.ModTime != fc.entry.ModTime {
+		return nil, nil
+	}
+	c, err := cid.Decode(entry.Cid)
+	if err != nil {
+		log.Warnf("ignoring invalid add checkpoint %s: %s", fc.key, err)
+		return nil, nil
+	}
+
+	// the blocks may have been garbage collected since
+	if has, err := cp.bs.Has(ctx, c); err != nil || !has {
+		return nil, err
+	}
+	nd, err := dserv.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	cp.lk.Lock()
+	cp.written = append(cp.written, fc.key)
+	cp.lk.Unlock()
+	return nd, nil
+}
+
+// save records that the file was added as c.
+func (cp *Checkpoints) save(ctx context.Context, fc *fileCheckpoint, c cid.Cid) error {
+	entry := fc.entry
+	entry.Cid = c.String()
+	val, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	if err := cp.ds.Put(ctx, fc.key, val); err != nil {
+		return err
+	}
+	cp.lk.Lock()
+	cp.written = append(cp.written, fc.key)
+	cp.lk.Unlock()
+	return nil
+}
+
+// clear removes the checkpoints of the current add, once it is complete.
+func (cp *Checkpoints) clear(ctx context.Context) error {
+	cp.lk.Lock()
+	defer cp.lk.Unlock()
+	for _, k := range cp.written {
+		if err := cp.ds.Delete(ctx, k); err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+	}
+	cp.written = nil
+	return nil
+}
+
+// ClearCheckpoints removes the checkpoints of all the adds, such as the ones
+// interrupted and never resumed, from ds.
+func ClearCheckpoints(ctx context.Context, ds datastore.Datastore) error {
+	res, err := ds.Query(ctx, query.Query{Prefix: checkpointPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := ds.Delete(ctx, datastore.NewKey(e.Key)); err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
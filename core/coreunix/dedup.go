@@ -0,0 +1,135 @@
+package coreunix
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+// DedupReport is the deduplication of the chunks of the files added, to
+// compare the chunkers on a dataset.
+type DedupReport struct {
+	// TotalBytes and Chunks count every chunk of the files.
+	TotalBytes uint64
+	Chunks     int
+	// UniqueBytes count every distinct chunk once, DuplicateChunks are the
+	// chunks seen before.
+	UniqueBytes     uint64
+	DuplicateChunks int
+	// LocalBytes and LocalChunks are the distinct chunks already in the
+	// local repo, when it is checked.
+	LocalBytes  uint64 `json:",omitempty"`
+	LocalChunks int    `json:",omitempty"`
+
+	lk   sync.Mutex
+	seen map[cid.Cid]struct{}
+}
+
+type dedupKey struct{}
+
+type dedupValue struct {
+	report     *DedupReport
+	checkLocal bool
+}
+
+// WithDedupReport returns a context recording the chunks of the adds done with
+// it in r. If checkLocal is set, the chunks already in the local repo are
+// counted too.
+func WithDedupReport(ctx context.Context, r *DedupReport, checkLocal bool) context.Context {
+	return context.WithValue(ctx, dedupKey{}, dedupValue{r, checkLocal})
+}
+
+// DedupReportFrom returns the report of the adds done with ctx, or nil.
+func DedupReportFrom(ctx context.Context) (r *DedupReport, checkLocal bool) {
+	v, _ := ctx.Value(dedupKey{}).(dedupValue)
+	return v.report, v.checkLocal
+}
+
+// chunkSize returns the size of the file data of nd if it is a chunk, i.e. a
+// leaf of a UnixFS file.
+func chunkSize(nd ipld.Node) (uint64, bool) {
+	switch n := nd.(type) {
+	case *dag.RawNode:
+		return uint64(len(n.RawData())), true
+	case *dag.ProtoNode:
+		if len(n.Links()) != 0 {
+			return 0, false
+		}
+		fsn, err := ft.FSNodeFromBytes(n.Data())
+		if err != nil || (fsn.Type() != ft.TFile && fsn.Type() != ft.TRaw) {
+			return 0, false
+		}
+		return uint64(len(fsn.Data())), true
+	default:
+		return 0, false
+	}
+}
+
+func (r *DedupReport) add(ctx context.Context, local bstore.Blockstore, nd ipld.Node) error {
+	size, ok := chunkSize(nd)
+	if !ok {
+		return nil
+	}
+
+	r.lk.Lock()
+	r.Chunks++
+	r.TotalBytes += size
+	if _, dup := r.seen[nd.Cid()]; dup {
+		r.DuplicateChunks++
+		r.lk.Unlock()
+		return nil
+	}
+	if r.seen == nil {
+		r.seen = make(map[cid.Cid]struct{})
+	}
+	r.seen[nd.Cid()] = struct{}{}
+	r.UniqueBytes += size
+	r.lk.Unlock()
+
+	if local == nil {
+		return nil
+	}
+	has, err := local.Has(ctx, nd.Cid())
+	if err != nil || !has {
+		return err
+	}
+	r.lk.Lock()
+	r.LocalChunks++
+	r.LocalBytes += size
+	r.lk.Unlock()
+	return nil
+}
+
+// dedupDAGService records the chunks added to the wrapped DAGService.
+type dedupDAGService struct {
+	ipld.DAGService
+	report *DedupReport
+	local  bstore.Blockstore
+}
+
+// NewDedupDAGService returns a DAGService recording the chunks added to ds in
+// r. If local is not nil, the chunks it already holds are counted.
+func NewDedupDAGService(ds ipld.DAGService, r *DedupReport, local bstore.Blockstore) ipld.DAGService {
+	return &dedupDAGService{DAGService: ds, report: r, local: local}
+}
+
+func (ds *dedupDAGService) Add(ctx context.Context, nd ipld.Node) error {
+	if err := ds.report.add(ctx, ds.local, nd); err != nil {
+		return err
+	}
+	return ds.DAGService.Add(ctx, nd)
+}
+
+func (ds *dedupDAGService) AddMany(ctx context.Context, nds []ipld.Node) error {
+	for _, nd := range nds {
+		if err := ds.report.add(ctx, ds.local, nd); err != nil {
+			return err
+		}
+	}
+	return ds.DAGService.AddMany(ctx, nds)
+}
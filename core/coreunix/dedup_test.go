@@ -0,0 +1,55 @@
+package coreunix
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/core"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	files "github.com/ipfs/go-ipfs-files"
+	dag "github.com/ipfs/go-merkledag"
+	dagtest "github.com/ipfs/go-merkledag/test"
+)
+
+func TestDedupReport(t *testing.T) {
+	ctx := context.Background()
+	node, err := core.NewNode(ctx, &core.BuildCfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := bytes.Repeat([]byte{1}, 1024)
+	local := blockstore.NewBlockstore(syncds.MutexWrap(datastore.NewMapDatastore()))
+	if err := local.Put(ctx, blocks.NewBlock(dag.NewRawNode(chunk).RawData())); err != nil {
+		t.Fatal(err)
+	}
+
+	var report DedupReport
+	adder, err := NewAdder(ctx, node.Pinning, node.Blockstore, NewDedupDAGService(dagtest.Mock(), &report, local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Pin = false
+	adder.RawLeaves = true
+	adder.Chunker = "size-1024"
+
+	data := append(bytes.Repeat(chunk, 3), bytes.Repeat([]byte{2}, 512)...)
+	if _, err := adder.AddAllAndPin(ctx, files.NewBytesFile(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Chunks != 4 || report.DuplicateChunks != 2 {
+		t.Fatalf("expected 4 chunks with 2 duplicates, got %d and %d", report.Chunks, report.DuplicateChunks)
+	}
+	if report.TotalBytes != uint64(len(data)) || report.UniqueBytes != 1024+512 {
+		t.Fatalf("expected %d bytes with %d unique, got %d and %d", len(data), 1024+512, report.TotalBytes, report.UniqueBytes)
+	}
+	if report.LocalChunks != 1 || report.LocalBytes != 1024 {
+		t.Fatalf("expected the repeated chunk to be in the repo, got %d chunks of %d bytes", report.LocalChunks, report.LocalBytes)
+	}
+}
@@ -0,0 +1,293 @@
+package coreunix
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+
+	ignore "github.com/crackcomm/go-gitignore"
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// IgnoreFileName is the name of the files holding the gitignore-style rules
+// excluding files from a recursive add. The rules apply to the paths relative
+// to the directory of the file, in it and in its subdirectories.
+const IgnoreFileName = ".ipfsignore"
+
+// ignoreRules are the rules of the ignore file of a directory, chained to the
+// rules of its parents.
+type ignoreRules struct {
+	parent *ignoreRules
+	// dir is the path of the directory the rules are relative to
+	dir   string
+	rules *ignore.GitIgnore
+}
+
+// with returns the rules of the ignore file of dir, holding lines, chained to
+// r.
+func (r *ignoreRules) with(dir string, lines []string) *ignoreRules {
+	if len(lines) == 0 {
+		return r
+	}
+	// compiling the lines never fails
+	g, _ := ignore.CompileIgnoreLines(lines...)
+	return &ignoreRules{parent: r, dir: dir, rules: g}
+}
+
+// excluded returns whether the entry at p, a slash separated path, is matched
+// by the rules.
+func (r *ignoreRules) excluded(p string, isDir bool) bool {
+	for ; r != nil; r = r.parent {
+		rel := p
+		if r.dir != "" {
+			rel = strings.TrimPrefix(p, r.dir+"/")
+		}
+		if isDir {
+			// so that the rules matching directories only apply
+			rel += "/"
+		}
+		if r.rules.MatchesPath(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func ignoreLines(data []byte) []string {
+	return strings.Split(string(data), "\n")
+}
+
+// readLocalIgnoreFile returns the lines of the ignore file of the local
+// directory dir, if it has one.
+func readLocalIgnoreFile(dir string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, IgnoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ignoreLines(data), nil
+}
+
+// FilterIgnored returns dir without the entries excluded by the .ipfsignore
+// files of its directories. The ignore files of the directories of the local
+// filesystem are read from it, the ones of the directories streamed with a
+// request apply to the entries following them.
+func FilterIgnored(dir files.Directory) files.Directory {
+	if _, ok := dir.(*ignoreDirectory); ok {
+		return dir
+	}
+	return &ignoreDirectory{Directory: dir, local: localDirPath(dir)}
+}
+
+// IgnoredPath returns whether the local path p, in the tree added from root,
+// is excluded by the .ipfsignore files of root and of its subdirectories.
+func IgnoredPath(root, p string) (bool, error) {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false, err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return false, nil
+	}
+	isDir := false
+	if st, err := os.Lstat(p); err == nil {
+		isDir = st.IsDir()
+	}
+
+	_, ignored, err := localIgnoreRules(root, strings.Split(rel, "/"), isDir)
+	return ignored, err
+}
+
+// FilterIgnoredAt is like FilterIgnored for dir, the local directory at p in
+// the tree added from root, applying the ignore files of its parents too.
+func FilterIgnoredAt(root, p string, dir files.Directory) (files.Directory, error) {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return nil, err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		return &ignoreDirectory{Directory: dir, local: p}, nil
+	}
+	rules, _, err := localIgnoreRules(root, strings.Split(rel, "/"), true)
+	if err != nil {
+		return nil, err
+	}
+	return &ignoreDirectory{Directory: dir, rules: rules, path: rel, local: p}, nil
+}
+
+// localIgnoreRules returns the rules of the ignore files of root and of its
+// subdirectories on the way to the entry at the path made of parts, and
+// whether one of them excludes the entry or a parent.
+func localIgnoreRules(root string, parts []string, isDir bool) (*ignoreRules, bool, error) {
+	var rules *ignoreRules
+	dir := root
+	for i, name := range parts {
+		lines, err := readLocalIgnoreFile(dir)
+		if err != nil {
+			return nil, false, err
+		}
+		rules = rules.with(strings.Join(parts[:i], "/"), lines)
+		if rules.excluded(strings.Join(parts[:i+1], "/"), i < len(parts)-1 || isDir) {
+			return rules, true, nil
+		}
+		dir = filepath.Join(dir, name)
+	}
+	return rules, false, nil
+}
+
+// localDirPath returns the path of dir in the local filesystem, learnt from
+// the files it holds, or "" if it is not a local directory.
+func localDirPath(dir files.Directory) string {
+	if s, ok := dir.(interface{ Stat() os.FileInfo }); !ok || s.Stat() == nil {
+		// the directories streamed with a request can only be read once
+		return ""
+	}
+
+	it := dir.Entries()
+	for it.Next() {
+		var p string
+		switch nd := it.Node().(type) {
+		case files.Directory:
+			if sub := localDirPath(nd); sub != "" {
+				p = filepath.Dir(sub)
+			}
+		case files.FileInfo:
+			if nd.AbsPath() != "" {
+				p = filepath.Dir(nd.AbsPath())
+			}
+		}
+		it.Node().Close()
+		if p != "" {
+			return p
+		}
+	}
+	return ""
+}
+
+// ignoreDirectory is a directory without the entries excluded by the ignore
+// files.
+type ignoreDirectory struct {
+	files.Directory
+	// rules are the rules of the parents
+	rules *ignoreRules
+	// path is the path of the directory in the added tree
+	path string
+	// local is the path of the directory in the local filesystem, if known
+	local string
+}
+
+func (d *ignoreDirectory) Stat() os.FileInfo {
+	if s, ok := d.Directory.(interface{ Stat() os.FileInfo }); ok {
+		return s.Stat()
+	}
+	return nil
+}
+
+func (d *ignoreDirectory) Size() (int64, error) {
+	var size int64
+	it := d.Entries()
+	for it.Next() {
+		s, err := it.Node().Size()
+		if d.local != "" {
+			// the entries of local directories are opened by the iterator,
+			// the other ones are read later
+			it.Node().Close()
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+	return size, it.Err()
+}
+
+func (d *ignoreDirectory) Entries() files.DirIterator {
+	return &ignoreIterator{dir: d, it: d.Directory.Entries()}
+}
+
+type ignoreIterator struct {
+	dir *ignoreDirectory
+	it  files.DirIterator
+
+	started bool
+	rules   *ignoreRules
+	name    string
+	node    files.Node
+	err     error
+}
+
+func (it *ignoreIterator) Next() bool {
+	if !it.started {
+		it.started = true
+		it.rules = it.dir.rules
+		if it.dir.local != "" {
+			lines, err := readLocalIgnoreFile(it.dir.local)
+			if err != nil {
+				it.err = err
+				return false
+			}
+			it.rules = it.rules.with(it.dir.path, lines)
+		}
+	}
+
+	for it.it.Next() {
+		name, nd := it.it.Name(), it.it.Node()
+		p := gopath.Join(it.dir.path, name)
+
+		if f, ok := nd.(files.File); ok && name == IgnoreFileName && it.dir.local == "" {
+			data, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				it.err = err
+				return false
+			}
+			it.rules = it.rules.with(it.dir.path, ignoreLines(data))
+			if nd, err = withReader(f, ioutil.NopCloser(bytes.NewReader(data))); err != nil {
+				it.err = err
+				return false
+			}
+		}
+
+		dir, isDir := nd.(files.Directory)
+		if it.rules.excluded(p, isDir) {
+			log.Debugf("ignoring %s", p)
+			nd.Close()
+			continue
+		}
+		if isDir {
+			var local string
+			if it.dir.local != "" {
+				local = filepath.Join(it.dir.local, name)
+			} else {
+				local = localDirPath(dir)
+			}
+			nd = &ignoreDirectory{Directory: dir, rules: it.rules, path: p, local: local}
+		}
+
+		it.name, it.node = name, nd
+		return true
+	}
+	return false
+}
+
+func (it *ignoreIterator) Name() string {
+	return it.name
+}
+
+func (it *ignoreIterator) Node() files.Node {
+	return it.node
+}
+
+func (it *ignoreIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Err()
+}
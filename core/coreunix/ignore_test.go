@@ -0,0 +1,143 @@
+package coreunix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+var ignoreTree = map[string]string{
+	".ipfsignore":     "*.log\nbuild/\n",
+	"a.txt":           "a",
+	"x.log":           "x",
+	"build/out":       "out",
+	"sub/.ipfsignore": "secret\n",
+	"sub/b.txt":       "b",
+	"sub/c.log":       "c",
+	"sub/secret":      "s",
+	"sub/build":       "a file, not a directory",
+}
+
+func walkNames(t *testing.T, nd files.Node) string {
+	var names []string
+	err := files.Walk(nd, func(fpath string, nd files.Node) error {
+		if f, ok := nd.(files.File); ok {
+			f.Close()
+		}
+		if fpath != "" {
+			names = append(names, filepath.ToSlash(fpath))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestFilterIgnored(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range ignoreTree {
+		fpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fpath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the local files, as walked by the cli without the hidden ones
+	st, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := files.NewSerialFile(dir, false, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := FilterIgnored(files.NewMapDirectory(map[string]files.Node{"dir": sf}))
+	expected := "dir,dir/a.txt,dir/sub,dir/sub/b.txt,dir/sub/build"
+	if got := walkNames(t, local); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+
+	// the files streamed to the daemon, with the ignore files
+	streamed := map[string]files.Node{}
+	sub := map[string]files.Node{}
+	for name, data := range ignoreTree {
+		f := files.NewBytesFile([]byte(data))
+		switch {
+		case strings.HasPrefix(name, "sub/"):
+			sub[strings.TrimPrefix(name, "sub/")] = f
+		case strings.HasPrefix(name, "build/"):
+			streamed["build"] = files.NewMapDirectory(map[string]files.Node{"out": f})
+		default:
+			streamed[name] = f
+		}
+	}
+	streamed["sub"] = files.NewMapDirectory(sub)
+	expected = ".ipfsignore,a.txt,sub,sub/.ipfsignore,sub/b.txt,sub/build"
+	if got := walkNames(t, FilterIgnored(files.NewMapDirectory(streamed))); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+
+	for name, ignored := range map[string]bool{
+		"a.txt":      false,
+		"x.log":      true,
+		"build":      true,
+		"build/out":  true,
+		"sub":        false,
+		"sub/c.log":  true,
+		"sub/secret": true,
+		"sub/build":  false,
+		"secret":     false,
+	} {
+		got, err := IgnoredPath(dir, filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != ignored {
+			t.Errorf("expected %s to be ignored: %t, got %t", name, ignored, got)
+		}
+	}
+}
+
+func TestFilterIgnoredSize(t *testing.T) {
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(fpath, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := files.NewSerialFile(fpath, false, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the files given on the command line are read after the size is known
+	d := FilterIgnored(files.NewSliceDirectory([]files.DirEntry{files.FileEntry("a.txt", sf)}))
+	size, err := d.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 4 {
+		t.Fatalf("expected a size of 4, got %d", size)
+	}
+	data, err := ioutil.ReadAll(sf.(files.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Fatalf("expected data, got %q", data)
+	}
+}
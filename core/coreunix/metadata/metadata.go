@@ -0,0 +1,285 @@
+// Package metadata reads and writes the mode and modification time of UnixFS
+// nodes.
+package metadata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	posinfo "github.com/ipfs/go-ipfs-posinfo"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+// UnixFS 1.5 adds the optional mode and mtime fields to the Data of the nodes,
+// which go-unixfs does not support yet. They are read and written here at the
+// protobuf wire level, leaving the other fields untouched.
+const (
+	unixfsModeField  = 7
+	unixfsMtimeField = 8
+
+	unixTimeSecondsField      = 1
+	unixTimeFractionalNsField = 2
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errMalformedProtobuf = errors.New("malformed unixfs protobuf")
+
+// FileMetadata is the mode and modification time of a UnixFS node.
+type FileMetadata struct {
+	// Mode holds the permission bits and the setuid, setgid and sticky
+	// bits when HasMode is set.
+	Mode    os.FileMode
+	HasMode bool
+	// ModTime is zero when unset.
+	ModTime time.Time
+}
+
+// IsZero returns whether no metadata is set.
+func (md FileMetadata) IsZero() bool {
+	return !md.HasMode && md.ModTime.IsZero()
+}
+
+// FromStat returns the metadata of st selected by mode and mtime.
+func FromStat(st os.FileInfo, mode, mtime bool) FileMetadata {
+	var md FileMetadata
+	if mode {
+		md.Mode = st.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		md.HasMode = true
+	}
+	if mtime {
+		md.ModTime = st.ModTime()
+	}
+	return md
+}
+
+// PosixMode returns the POSIX encoding of m, as stored by UnixFS.
+func PosixMode(m os.FileMode) uint32 {
+	p := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		p |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		p |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		p |= 01000
+	}
+	return p
+}
+
+// FileModeFromPosix returns the mode of the POSIX encoding p.
+func FileModeFromPosix(p uint32) os.FileMode {
+	m := os.FileMode(p) & os.ModePerm
+	if p&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if p&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if p&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// wireField is a field of an encoded protobuf message.
+type wireField struct {
+	num     uint64
+	typ     uint64
+	varint  uint64
+	fixed32 uint32
+	bytes   []byte
+	raw     []byte // the whole encoded field
+}
+
+// parseWire splits an encoded protobuf message into its fields.
+func parseWire(b []byte) ([]wireField, error) {
+	var fields []wireField
+	for len(b) > 0 {
+		start := b
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errMalformedProtobuf
+		}
+		b = b[n:]
+		f := wireField{num: key >> 3, typ: key & 7}
+		switch f.typ {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errMalformedProtobuf
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, errMalformedProtobuf
+			}
+			b = b[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errMalformedProtobuf
+			}
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, errMalformedProtobuf
+			}
+			f.fixed32 = binary.LittleEndian.Uint32(b)
+			b = b[4:]
+		default:
+			return nil, errMalformedProtobuf
+		}
+		f.raw = start[:len(start)-len(b)]
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendKey(b []byte, num, typ uint64) []byte {
+	return appendUvarint(b, num<<3|typ)
+}
+
+// unixfsData returns the UnixFS data of nd, or nil if nd is not a UnixFS
+// dag-pb node.
+func unixfsData(nd ipld.Node) []byte {
+	if fsn, ok := nd.(*posinfo.FilestoreNode); ok {
+		nd = fsn.Node
+	}
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return nil
+	}
+	if _, err := ft.FSNodeFromBytes(pn.Data()); err != nil {
+		return nil
+	}
+	return pn.Data()
+}
+
+// Read returns the metadata of nd, which is empty for nodes that
+// are not UnixFS dag-pb nodes.
+func Read(nd ipld.Node) (FileMetadata, error) {
+	var md FileMetadata
+	data := unixfsData(nd)
+	if data == nil {
+		return md, nil
+	}
+
+	fields, err := parseWire(data)
+	if err != nil {
+		return md, err
+	}
+	for _, f := range fields {
+		switch {
+		case f.num == unixfsModeField && f.typ == wireVarint:
+			md.Mode = FileModeFromPosix(uint32(f.varint))
+			md.HasMode = true
+		case f.num == unixfsMtimeField && f.typ == wireBytes:
+			tfields, err := parseWire(f.bytes)
+			if err != nil {
+				return md, err
+			}
+			var sec int64
+			var nsec uint32
+			for _, tf := range tfields {
+				switch {
+				case tf.num == unixTimeSecondsField && tf.typ == wireVarint:
+					sec = int64(tf.varint)
+				case tf.num == unixTimeFractionalNsField && tf.typ == wireFixed32:
+					nsec = tf.fixed32
+				}
+			}
+			if nsec >= uint32(time.Second) {
+				return md, fmt.Errorf("invalid unixfs mtime nanoseconds %d", nsec)
+			}
+			md.ModTime = time.Unix(sec, int64(nsec))
+		}
+	}
+	return md, nil
+}
+
+// Set returns a copy of the UnixFS node nd with the metadata md,
+// replacing the one it had. Raw file nodes, which cannot hold metadata, are
+// wrapped into a dag-pb file node.
+func Set(nd ipld.Node, md FileMetadata) (ipld.Node, error) {
+	if fsn, ok := nd.(*posinfo.FilestoreNode); ok {
+		nd = fsn.Node
+	}
+
+	var pn *dag.ProtoNode
+	switch n := nd.(type) {
+	case *dag.ProtoNode:
+		if _, err := ft.FSNodeFromBytes(n.Data()); err != nil {
+			return nil, err
+		}
+		pn = n.Copy().(*dag.ProtoNode)
+	case *dag.RawNode:
+		if md.IsZero() {
+			return n, nil
+		}
+		fsn := ft.NewFSNode(ft.TFile)
+		fsn.AddBlockSize(uint64(len(n.RawData())))
+		data, err := fsn.GetBytes()
+		if err != nil {
+			return nil, err
+		}
+		pn = dag.NodeWithData(data)
+		prefix := n.Cid().Prefix()
+		prefix.Codec = cid.DagProtobuf
+		pn.SetCidBuilder(prefix)
+		if err := pn.AddNodeLink("", n); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("cannot set the metadata of %T nodes", nd)
+	}
+
+	fields, err := parseWire(pn.Data())
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, f := range fields {
+		if f.num != unixfsModeField && f.num != unixfsMtimeField {
+			data = append(data, f.raw...)
+		}
+	}
+	if md.HasMode {
+		data = appendKey(data, unixfsModeField, wireVarint)
+		data = appendUvarint(data, uint64(PosixMode(md.Mode)))
+	}
+	if !md.ModTime.IsZero() {
+		var t []byte
+		t = appendKey(t, unixTimeSecondsField, wireVarint)
+		t = appendUvarint(t, uint64(md.ModTime.Unix()))
+		if nsec := md.ModTime.Nanosecond(); nsec != 0 {
+			t = appendKey(t, unixTimeFractionalNsField, wireFixed32)
+			var buf [4]byte
+			binary.LittleEndian.PutUint32(buf[:], uint32(nsec))
+			t = append(t, buf[:]...)
+		}
+		data = appendKey(data, unixfsMtimeField, wireBytes)
+		data = appendUvarint(data, uint64(len(t)))
+		data = append(data, t...)
+	}
+	pn.SetData(data)
+	return pn, nil
+}
@@ -0,0 +1,102 @@
+package metadata
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	dag "github.com/ipfs/go-merkledag"
+	dagtest "github.com/ipfs/go-merkledag/test"
+	ft "github.com/ipfs/go-unixfs"
+	unixfile "github.com/ipfs/go-unixfs/file"
+)
+
+func TestFileMetadataRoundTrip(t *testing.T) {
+	nd := dag.NodeWithData(ft.FilePBData([]byte("some data"), 9))
+
+	if md, err := Read(nd); err != nil || !md.IsZero() {
+		t.Fatalf("expected no metadata, got %+v %v", md, err)
+	}
+
+	md := FileMetadata{
+		Mode:    0750 | os.ModeSetgid | os.ModeSticky,
+		HasMode: true,
+		ModTime: time.Unix(-1234, 567),
+	}
+	withMd, err := Set(nd, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(withMd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != md.Mode || !got.HasMode || !got.ModTime.Equal(md.ModTime) {
+		t.Fatalf("expected %+v, got %+v", md, got)
+	}
+	if nd.Cid() == withMd.Cid() {
+		t.Fatal("expected the metadata to change the CID")
+	}
+
+	// the other fields are kept
+	fsn, err := ft.FSNodeFromBytes(withMd.(*dag.ProtoNode).Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fsn.Data()) != "some data" || fsn.FileSize() != 9 {
+		t.Fatalf("expected the file data to be kept, got %q", fsn.Data())
+	}
+
+	// setting the metadata again replaces it
+	replaced, err := Set(withMd, FileMetadata{ModTime: time.Unix(42, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = Read(replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.HasMode || got.ModTime.Unix() != 42 || got.ModTime.Nanosecond() != 0 {
+		t.Fatalf("expected only the new mtime, got %+v", got)
+	}
+}
+
+func TestFileMetadataRawNode(t *testing.T) {
+	ctx := context.Background()
+	dserv := dagtest.Mock()
+
+	raw := dag.NewRawNode([]byte("raw file"))
+	if err := dserv.Add(ctx, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	nd, err := Set(raw, FileMetadata{Mode: 0600, HasMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd.Cid().Prefix().Version != 1 {
+		t.Fatal("expected the wrapping node to keep the CID version of the raw node")
+	}
+	md, err := Read(nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !md.HasMode || md.Mode != 0600 {
+		t.Fatalf("expected mode 0600, got %+v", md)
+	}
+
+	f, err := unixfile.NewUnixfsFile(ctx, dserv, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(files.ToFile(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "raw file" {
+		t.Fatalf("expected the wrapping node to read as the raw node, got %q", data)
+	}
+}
@@ -0,0 +1,289 @@
+package coreunix
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// parallelReadAhead is the largest file streamed with the request that is
+// read ahead to be added concurrently with the next ones.
+const parallelReadAhead = 1 << 20
+
+// readAheadChunks is the number of chunks split ahead of the DAG builder.
+const readAheadChunks = 8
+
+type concurrencyKey struct{}
+
+// WithConcurrency returns a context adding n files at once in the adds done
+// with it, see Adder.Concurrency.
+func WithConcurrency(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, concurrencyKey{}, n)
+}
+
+// Concurrency returns the number of files added at once by adds done with ctx,
+// 0 if unset.
+func Concurrency(ctx context.Context) int {
+	n, _ := ctx.Value(concurrencyKey{}).(int)
+	return n
+}
+
+// addPipeline adds the files concurrently, while every change to the mfs
+// root, and every event, is committed in the order of the input, so that the
+// DAG and the output are the ones of a sequential add.
+type addPipeline struct {
+	adder *Adder
+
+	// sem bounds the files being added
+	sem chan struct{}
+	ops chan func() error
+
+	lk  sync.Mutex
+	err error
+	// aborted is closed on the first error
+	aborted chan struct{}
+	done    chan struct{}
+}
+
+func newAddPipeline(adder *Adder, concurrency int) *addPipeline {
+	p := &addPipeline{
+		adder:   adder,
+		sem:     make(chan struct{}, concurrency),
+		ops:     make(chan func() error, 4*concurrency),
+		aborted: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *addPipeline) run() {
+	defer close(p.done)
+	for op := range p.ops {
+		if p.failed() != nil {
+			// drain the operations queued before the failure
+			continue
+		}
+		if err := op(); err != nil {
+			p.lk.Lock()
+			p.err = err
+			p.lk.Unlock()
+			close(p.aborted)
+		}
+	}
+}
+
+func (p *addPipeline) failed() error {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.err
+}
+
+// commit queues op after the operations committed before it.
+func (p *addPipeline) commit(op func() error) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	select {
+	case p.ops <- op:
+		return nil
+	case <-p.adder.ctx.Done():
+		return p.adder.ctx.Err()
+	}
+}
+
+// wait waits for the files being added and the queued operations, and
+// returns the first error.
+func (p *addPipeline) wait() error {
+	close(p.ops)
+	<-p.done
+	for i := 0; i < cap(p.sem); i++ {
+		p.sem <- struct{}{}
+	}
+	return p.failed()
+}
+
+// maybePauseForGC lets a requested GC run, once the files being added are
+// committed: the blocks of those that are not in the mfs root yet would not
+// be pinned with it.
+func (p *addPipeline) maybePauseForGC(ctx context.Context) error {
+	if p.adder.unlocker == nil || !p.adder.gcLocker.GCRequested(ctx) {
+		return nil
+	}
+
+	// no file is started until the GC ran
+	for i := 0; i < cap(p.sem); i++ {
+		p.sem <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < cap(p.sem); i++ {
+			<-p.sem
+		}
+	}()
+
+	committed := make(chan struct{})
+	err := p.commit(func() error {
+		close(committed)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case <-committed:
+	case <-p.aborted:
+		return p.failed()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// no operation runs until the next file is committed
+	return p.adder.maybePauseForGC(ctx)
+}
+
+type addResult struct {
+	nd   ipld.Node
+	size int64
+	err  error
+}
+
+// addFile adds file with a worker, and queues its insertion at path.
+func (p *addPipeline) addFile(path string, file files.File) error {
+	select {
+	case p.sem <- struct{}{}:
+	case <-p.adder.ctx.Done():
+		return p.adder.ctx.Err()
+	}
+
+	src, detached, err := detachFile(file)
+	if err != nil {
+		<-p.sem
+		return err
+	}
+
+	res := make(chan addResult, 1)
+	work := func() {
+		defer func() { <-p.sem }()
+		nd, size, err := p.adder.fileNode(path, src, nil)
+		res <- addResult{nd, size, err}
+	}
+	if detached {
+		go func() {
+			defer src.Close()
+			work()
+		}()
+	} else {
+		// the file has to be read before the next entries
+		work()
+	}
+
+	return p.commit(func() error {
+		r := <-res
+		if r.err != nil {
+			return r.err
+		}
+		if p.adder.Progress {
+			p.adder.Out <- &coreiface.AddEvent{
+				Name:  path,
+				Bytes: r.size,
+			}
+		}
+		return p.adder.addFileMetadata(r.nd, path, src)
+	})
+}
+
+// detachFile returns a copy of file that can be read concurrently with the
+// next entries, or a file that has to be read at once if it cannot.
+func detachFile(file files.File) (files.File, bool, error) {
+	fi, ok := file.(files.FileInfo)
+	if ok && fi.AbsPath() != "" && fi.Stat() != nil {
+		// a file of the local filesystem is opened again
+		f, err := os.Open(fi.AbsPath())
+		if err != nil {
+			return nil, false, err
+		}
+		rf, err := files.NewReaderPathFile(fi.AbsPath(), f, fi.Stat())
+		if err != nil {
+			f.Close()
+			return nil, false, err
+		}
+		return rf, true, nil
+	}
+
+	// files streamed with the request are read in order, the small ones are
+	// read ahead
+	data, err := ioutil.ReadAll(io.LimitReader(file, parallelReadAhead+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) <= parallelReadAhead {
+		rf, err := withReader(file, ioutil.NopCloser(bytes.NewReader(data)))
+		return rf, err == nil, err
+	}
+	rf, err := withReader(file, ioutil.NopCloser(io.MultiReader(bytes.NewReader(data), file)))
+	return rf, false, err
+}
+
+// withReader returns a file reading r, with the path and stat of file.
+func withReader(file files.File, r io.ReadCloser) (files.File, error) {
+	if fi, ok := file.(files.FileInfo); ok && fi.AbsPath() != "" {
+		return files.NewReaderPathFile(fi.AbsPath(), r, fi.Stat())
+	}
+	return files.NewReaderFile(r), nil
+}
+
+// readAheadSplitter splits the chunks ahead of the DAG builder, so that
+// content defined chunking and hashing run on separate cores.
+type readAheadSplitter struct {
+	chunker.Splitter
+	chunks chan readAheadChunk
+	stop   chan struct{}
+}
+
+type readAheadChunk struct {
+	data []byte
+	err  error
+}
+
+func newReadAheadSplitter(spl chunker.Splitter) *readAheadSplitter {
+	s := &readAheadSplitter{
+		Splitter: spl,
+		chunks:   make(chan readAheadChunk, readAheadChunks),
+		stop:     make(chan struct{}),
+	}
+	go func() {
+		defer close(s.chunks)
+		for {
+			data, err := spl.NextBytes()
+			select {
+			case s.chunks <- readAheadChunk{data, err}:
+			case <-s.stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *readAheadSplitter) NextBytes() ([]byte, error) {
+	c, ok := <-s.chunks
+	if !ok {
+		return nil, io.EOF
+	}
+	return c.data, c.err
+}
+
+// close stops the splitting, when the builder did not read every chunk.
+func (s *readAheadSplitter) close() {
+	close(s.stop)
+}
@@ -0,0 +1,29 @@
+package coreunix
+
+import (
+	"context"
+)
+
+type preserveKey struct{}
+
+// Preserve selects the metadata of the local files recorded by an add, see
+// Adder.PreserveMode.
+type Preserve struct {
+	Mode  bool
+	Mtime bool
+	// Wrapped is set when the added directory only wraps the files, so it has
+	// no metadata of its own.
+	Wrapped bool
+}
+
+// WithPreserve returns a context recording the metadata selected by p in the
+// adds done with it.
+func WithPreserve(ctx context.Context, p Preserve) context.Context {
+	return context.WithValue(ctx, preserveKey{}, p)
+}
+
+// Preserving returns the metadata recorded by adds done with ctx.
+func Preserving(ctx context.Context) Preserve {
+	p, _ := ctx.Value(preserveKey{}).(Preserve)
+	return p
+}
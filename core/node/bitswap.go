@@ -2,6 +2,7 @@ package node
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/ipfs/go-bitswap"
 	"github.com/ipfs/go-bitswap/network"
@@ -9,9 +10,11 @@ import (
 	exchange "github.com/ipfs/go-ipfs-exchange-interface"
 	config "github.com/ipfs/go-ipfs/config"
 	"github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/routing"
 	"go.uber.org/fx"
 
+	ibitswap "github.com/ipfs/go-ipfs/bitswap"
 	"github.com/ipfs/go-ipfs/core/node/helpers"
 )
 
@@ -23,18 +26,75 @@ const (
 	DefaultMaxOutstandingBytesPerPeer  = 1 << 20
 )
 
+// BitswapPolicy creates the policy restricting what the bitswap server does
+// for remote peers.
+func BitswapPolicy(cfg *config.Config) interface{} {
+	return func() (*ibitswap.Policy, error) {
+		return newBitswapPolicy(cfg)
+	}
+}
+
+func newBitswapPolicy(cfg *config.Config) (*ibitswap.Policy, error) {
+	var internalBsCfg config.InternalBitswap
+	if cfg.Internal.Bitswap != nil {
+		internalBsCfg = *cfg.Internal.Bitswap
+	}
+
+	allow, err := parsePeerList("Internal.Bitswap.ServeAllowList", internalBsCfg.ServeAllowList)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parsePeerList("Internal.Bitswap.ServeDenyList", internalBsCfg.ServeDenyList)
+	if err != nil {
+		return nil, err
+	}
+	maxBytes := internalBsCfg.MaxBytesPerSecondPerPeer.WithDefault(0)
+	maxWants := internalBsCfg.MaxWantlistSizePerPeer.WithDefault(0)
+	if maxBytes < 0 || maxWants < 0 {
+		return nil, fmt.Errorf("Internal.Bitswap.MaxBytesPerSecondPerPeer and Internal.Bitswap.MaxWantlistSizePerPeer can't be negative")
+	}
+
+	server := internalBsCfg.ServerEnabled.WithDefault(true)
+	if !server && !internalBsCfg.ClientEnabled.WithDefault(true) {
+		return nil, fmt.Errorf("Internal.Bitswap.ClientEnabled and Internal.Bitswap.ServerEnabled can't both be false, run the node offline instead")
+	}
+
+	return ibitswap.NewPolicy(ibitswap.PolicyConfig{
+		AllowList:                allow,
+		DenyList:                 deny,
+		MaxBytesPerSecondPerPeer: maxBytes,
+		MaxWantlistSize:          int(maxWants),
+		DisableServer:            !server,
+	}), nil
+}
+
+func parsePeerList(field string, peers []string) ([]peer.ID, error) {
+	out := make([]peer.ID, 0, len(peers))
+	for _, s := range peers {
+		pid, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid peer ID %q: %w", field, s, err)
+		}
+		out = append(out, pid)
+	}
+	return out, nil
+}
+
 // OnlineExchange creates new LibP2P backed block exchange (BitSwap)
 func OnlineExchange(cfg *config.Config, provide bool) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt routing.Routing, bs blockstore.GCBlockstore) exchange.Interface {
-		bitswapNetwork := network.NewFromIpfsHost(host, rt)
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt routing.Routing, bs blockstore.GCBlockstore, policy *ibitswap.Policy) exchange.Interface {
+		bitswapNetwork := policy.Wrap(network.NewFromIpfsHost(host, rt))
 
 		var internalBsCfg config.InternalBitswap
 		if cfg.Internal.Bitswap != nil {
 			internalBsCfg = *cfg.Internal.Bitswap
 		}
 
+		// Don't advertise blocks we won't serve.
+		server := internalBsCfg.ServerEnabled.WithDefault(true)
+
 		opts := []bitswap.Option{
-			bitswap.ProvideEnabled(provide),
+			bitswap.ProvideEnabled(provide && server),
 			bitswap.EngineBlockstoreWorkerCount(int(internalBsCfg.EngineBlockstoreWorkerCount.WithDefault(DefaultEngineBlockstoreWorkerCount))),
 			bitswap.TaskWorkerCount(int(internalBsCfg.TaskWorkerCount.WithDefault(DefaultTaskWorkerCount))),
 			bitswap.EngineTaskWorkerCount(int(internalBsCfg.EngineTaskWorkerCount.WithDefault(DefaultEngineTaskWorkerCount))),
@@ -46,7 +106,9 @@ func OnlineExchange(cfg *config.Config, provide bool) interface{} {
 				return exch.Close()
 			},
 		})
+		if !internalBsCfg.ClientEnabled.WithDefault(true) {
+			return ibitswap.ServerOnly{Bitswap: exch.(*bitswap.Bitswap)}
+		}
 		return exch
-
 	}
 }
@@ -140,6 +140,36 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 			"If you want to continue running a circuit v1 relay, please use the standalone relay daemon: https://github.com/libp2p/go-libp2p-relay-daemon (with RelayV1.Enabled: true)")
 	}
 
+	// With the custom routing type, the DHT is declared in Routing.Routers
+	// and built by BaseRouting like the DHT selected by other types.
+	dhtName, dhtPriority, dhtMethods := "dht", config.DefaultDHTRouterPriority, []string(nil)
+	if cfg.Routing.Type == config.RoutingTypeCustom {
+		name, dht, err := cfg.Routing.DHTRouter()
+		if err != nil {
+			return fx.Error(err)
+		}
+		if dht != nil {
+			dhtName = name
+			dhtPriority = int(dht.Priority.WithDefault(config.DefaultDHTRouterPriority))
+			dhtMethods = dht.Methods
+		}
+	}
+
+	var routers []fx.Option
+	for name, r := range cfg.Routing.Routers {
+		if !r.Enabled.WithDefault(true) {
+			continue
+		}
+		if r.Type == config.RouterTypeDHT {
+			if cfg.Routing.Type != config.RoutingTypeCustom {
+				return fx.Error(fmt.Errorf("Routing.Routers.%s: dht routers require the %q routing type", name, config.RoutingTypeCustom))
+			}
+			continue
+		}
+		routers = append(routers, fx.Provide(libp2p.DelegatedRouter(name, r)))
+	}
+	delegatedRouters := fx.Options(routers...)
+
 	// Gather all the options
 	opts := fx.Options(
 		BaseLibP2P,
@@ -158,9 +188,10 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 
 		fx.Provide(libp2p.Security(!bcfg.DisableEncryptedConnections, cfg.Swarm.Transports)),
 
-		fx.Provide(libp2p.Routing),
-		fx.Provide(libp2p.BaseRouting(cfg.Experimental.AcceleratedDHTClient)),
+		fx.Provide(libp2p.Routing(cfg.Routing.Methods)),
+		fx.Provide(libp2p.BaseRouting(cfg.AcceleratedDHTClient(), dhtName, dhtPriority, dhtMethods)),
 		maybeProvide(libp2p.PubsubRouter, bcfg.getOpt("ipnsps")),
+		delegatedRouters,
 
 		maybeProvide(libp2p.BandwidthCounter, !cfg.Swarm.DisableBandwidthMetrics),
 		maybeProvide(libp2p.NatPortMap, !cfg.Swarm.DisableNatPortMap),
@@ -283,6 +314,7 @@ func Online(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 	shouldBitswapProvide := !cfg.Experimental.StrategicProviding
 
 	return fx.Options(
+		fx.Provide(BitswapPolicy(cfg)),
 		fx.Provide(OnlineExchange(cfg, shouldBitswapProvide)),
 		maybeProvide(Graphsync, cfg.Experimental.GraphsyncEnabled),
 		fx.Provide(DNSResolver),
@@ -295,7 +327,7 @@ func Online(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		fx.Provide(p2p.New),
 
 		LibP2P(bcfg, cfg),
-		OnlineProviders(cfg.Experimental.StrategicProviding, cfg.Experimental.AcceleratedDHTClient, cfg.Reprovider.Strategy, cfg.Reprovider.Interval),
+		OnlineProviders(cfg.Experimental.StrategicProviding, cfg.AcceleratedDHTClient(), cfg.Reprovider.Strategy, cfg.Reprovider.Interval),
 	)
 }
 
@@ -306,7 +338,7 @@ func Offline(cfg *config.Config) fx.Option {
 		fx.Provide(DNSResolver),
 		fx.Provide(Namesys(0)),
 		fx.Provide(offroute.NewOfflineRouter),
-		OfflineProviders(cfg.Experimental.StrategicProviding, cfg.Experimental.AcceleratedDHTClient, cfg.Reprovider.Strategy, cfg.Reprovider.Interval),
+		OfflineProviders(cfg.Experimental.StrategicProviding, cfg.AcceleratedDHTClient(), cfg.Reprovider.Strategy, cfg.Reprovider.Interval),
 	)
 }
 
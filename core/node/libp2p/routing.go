@@ -2,13 +2,17 @@ package libp2p
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"time"
 
+	config "github.com/ipfs/go-ipfs/config"
 	"github.com/ipfs/go-ipfs/core/node/helpers"
+	irouting "github.com/ipfs/go-ipfs/routing"
 
 	"github.com/ipfs/go-ipfs/repo"
 	host "github.com/libp2p/go-libp2p-core/host"
+	peer "github.com/libp2p/go-libp2p-core/peer"
 	routing "github.com/libp2p/go-libp2p-core/routing"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	ddht "github.com/libp2p/go-libp2p-kad-dht/dual"
@@ -26,7 +30,42 @@ type BaseIpfsRouting routing.Routing
 type Router struct {
 	routing.Routing
 
+	// Name identifies the router in the results of 'ipfs routing'
+	// commands.
+	Name string
+
 	Priority int // less = more important
+
+	// Methods served by the router, see config.RoutingMethods. All
+	// methods when empty.
+	Methods []string
+}
+
+// serves returns whether the router serves the given routing method.
+func (r Router) serves(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMethods returns an error for unknown routing method names.
+func checkMethods(field string, methods []string) error {
+	for _, m := range methods {
+		known := false
+		for _, km := range config.RoutingMethods {
+			known = known || m == km
+		}
+		if !known {
+			return fmt.Errorf("%s: unknown routing method %q, must be one of %v", field, m, config.RoutingMethods)
+		}
+	}
+	return nil
 }
 
 type p2pRouterOut struct {
@@ -55,8 +94,14 @@ type processInitialRoutingOut struct {
 	BaseRT    BaseIpfsRouting
 }
 
-func BaseRouting(experimentalDHTClient bool) interface{} {
+// BaseRouting adds the initial routing, usually the DHT, to the routers group
+// with the given name, priority and methods.
+func BaseRouting(experimentalDHTClient bool, name string, priority int, methods []string) interface{} {
 	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, in processInitialRoutingIn) (out processInitialRoutingOut, err error) {
+		if err := checkMethods("dht router", methods); err != nil {
+			return out, err
+		}
+
 		var dr *ddht.DHT
 		if dht, ok := in.Router.(*ddht.DHT); ok {
 			dr = dht
@@ -100,7 +145,9 @@ func BaseRouting(experimentalDHTClient bool) interface{} {
 			return processInitialRoutingOut{
 				Router: Router{
 					Routing:  expClient,
-					Priority: 1000,
+					Name:     "accelerated-" + name,
+					Priority: priority,
+					Methods:  methods,
 				},
 				DHT:       dr,
 				DHTClient: expClient,
@@ -110,8 +157,10 @@ func BaseRouting(experimentalDHTClient bool) interface{} {
 
 		return processInitialRoutingOut{
 			Router: Router{
-				Priority: 1000,
+				Priority: priority,
 				Routing:  in.Router,
+				Name:     name,
+				Methods:  methods,
 			},
 			DHT:       dr,
 			DHTClient: dr,
@@ -127,21 +176,126 @@ type p2pOnlineRoutingIn struct {
 	Validator record.Validator
 }
 
-func Routing(in p2pOnlineRoutingIn) routing.Routing {
-	routers := in.Routers
+// Routing composes, for every routing method, the routers serving it. By
+// default, routers sharing a priority are queried in parallel, and each
+// priority only when the previous ones came up empty. Methods configured as
+// parallel query all their routers at once. Every router reports its results
+// to the trace of the query, see irouting.WithTrace.
+func Routing(methods map[string]config.Method) interface{} {
+	return func(in p2pOnlineRoutingIn) (routing.Routing, error) {
+		for m := range methods {
+			if err := checkMethods("Routing.Methods", []string{m}); err != nil {
+				return nil, err
+			}
+		}
+
+		routers := make([]Router, len(in.Routers))
+		for i, r := range in.Routers {
+			r.Routing = irouting.Traced(r.Name, r.Routing)
+			routers[i] = r
+		}
+		sort.SliceStable(routers, func(i, j int) bool {
+			return routers[i].Priority < routers[j].Priority
+		})
+
+		compose := func(method string) routing.Routing {
+			var serving []Router
+			for _, r := range routers {
+				if r.serves(method) {
+					serving = append(serving, r)
+				}
+			}
+			if methods[method].Parallel.WithDefault(false) {
+				parallel := routinghelpers.Parallel{Validator: in.Validator}
+				for _, r := range serving {
+					parallel.Routers = append(parallel.Routers, r.Routing)
+				}
+				return parallel
+			}
+			return sequentialRouting(serving, in.Validator)
+		}
+
+		return &irouting.Composer{
+			FindProvidersRouter: compose(config.MethodFindProviders),
+			ProvideRouter:       compose(config.MethodProvide),
+			FindPeersRouter:     compose(config.MethodFindPeers),
+			GetIPNSRouter:       compose(config.MethodGetIPNS),
+			PutIPNSRouter:       compose(config.MethodPutIPNS),
+			Bootstrapper:        sequentialRouting(routers, in.Validator),
+		}, nil
+	}
+}
 
-	sort.SliceStable(routers, func(i, j int) bool {
-		return routers[i].Priority < routers[j].Priority
-	})
+// sequentialRouting composes routers sorted by priority into tiers of routers
+// sharing a priority.
+func sequentialRouting(routers []Router, validator record.Validator) routing.Routing {
+	var tiers []routing.Routing
+	for i := 0; i < len(routers); {
+		j := i + 1
+		for j < len(routers) && routers[j].Priority == routers[i].Priority {
+			j++
+		}
+		if j-i == 1 {
+			tiers = append(tiers, routers[i].Routing)
+		} else {
+			parallel := routinghelpers.Parallel{Validator: validator}
+			for _, r := range routers[i:j] {
+				parallel.Routers = append(parallel.Routers, r.Routing)
+			}
+			tiers = append(tiers, parallel)
+		}
+		i = j
+	}
 
-	irouters := make([]routing.Routing, len(routers))
-	for i, v := range routers {
-		irouters[i] = v.Routing
+	return irouting.Sequential{
+		Tiered: routinghelpers.Tiered{
+			Routers:   tiers,
+			Validator: validator,
+		},
 	}
+}
+
+// DelegatedRouter adds the router configured as Routing.Routers[name] to the
+// routers group.
+func DelegatedRouter(name string, cfg config.Router) interface{} {
+	return func(h host.Host) (p2pRouterOut, error) {
+		if err := checkMethods("Routing.Routers."+name, cfg.Methods); err != nil {
+			return p2pRouterOut{}, err
+		}
 
-	return routinghelpers.Tiered{
-		Routers:   irouters,
-		Validator: in.Validator,
+		var r routing.Routing
+		switch cfg.Type {
+		case config.RouterTypeHTTP:
+			endpoint := cfg.Parameters["Endpoint"]
+			if endpoint == "" {
+				return p2pRouterOut{}, fmt.Errorf("Routing.Routers.%s: missing Endpoint parameter", name)
+			}
+			self := func() peer.AddrInfo {
+				return peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+			}
+			hr, err := irouting.NewHTTPRouter(endpoint, cfg.Timeout.WithDefault(config.DefaultRouterTimeout), self)
+			if err != nil {
+				return p2pRouterOut{}, fmt.Errorf("Routing.Routers.%s: %w", name, err)
+			}
+			r = &routinghelpers.Compose{
+				ContentRouting: hr,
+				ValueStore: &routinghelpers.LimitedValueStore{
+					ValueStore: hr,
+					Namespaces: []string{"ipns"},
+				},
+			}
+		default:
+			return p2pRouterOut{}, fmt.Errorf("Routing.Routers.%s: unknown router type %q", name, cfg.Type)
+		}
+
+		return p2pRouterOut{
+			Router: Router{
+				Routing:  r,
+				Name:     name,
+				Priority: int(cfg.Priority.WithDefault(config.DefaultRouterPriority)),
+				Methods:  cfg.Methods,
+			},
+		}, nil
 	}
 }
 
@@ -175,6 +329,7 @@ func PubsubRouter(mctx helpers.MetricsCtx, lc fx.Lifecycle, in p2pPSRoutingIn) (
 					Namespaces: []string{"ipns"},
 				},
 			},
+			Name:     "pubsub",
 			Priority: 100,
 		},
 	}, psRouter, nil
@@ -0,0 +1,110 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+
+	config "github.com/ipfs/go-ipfs/config"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	"github.com/libp2p/go-libp2p-core/test"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+)
+
+// testRouter finds itself as the provider of everything and as every peer.
+type testRouter struct {
+	routinghelpers.Null
+	id peer.ID
+}
+
+func (r *testRouter) FindProvidersAsync(context.Context, cid.Cid, int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo, 1)
+	out <- peer.AddrInfo{ID: r.id}
+	close(out)
+	return out
+}
+
+func (r *testRouter) FindPeer(context.Context, peer.ID) (peer.AddrInfo, error) {
+	return peer.AddrInfo{ID: r.id}, nil
+}
+
+func newTestRouter(t *testing.T) *testRouter {
+	id, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRouter{id: id}
+}
+
+func findProviders(r routing.Routing) []peer.ID {
+	var found []peer.ID
+	for ai := range r.FindProvidersAsync(context.Background(), cid.Cid{}, 0) {
+		found = append(found, ai.ID)
+	}
+	return found
+}
+
+func TestRoutingMethods(t *testing.T) {
+	dht, indexer := newTestRouter(t), newTestRouter(t)
+	routers := []Router{
+		{Routing: dht, Priority: 1000, Methods: []string{config.MethodFindPeers}},
+		{Routing: indexer, Priority: 2000, Methods: []string{config.MethodFindProviders, config.MethodProvide}},
+	}
+
+	construct := Routing(nil).(func(p2pOnlineRoutingIn) (routing.Routing, error))
+	r, err := construct(p2pOnlineRoutingIn{Routers: routers})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found := findProviders(r); len(found) != 1 || found[0] != indexer.id {
+		t.Fatalf("expected providers to only come from the indexer, got %v", found)
+	}
+	ai, err := r.FindPeer(context.Background(), indexer.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ai.ID != dht.id {
+		t.Fatalf("expected peers to be found on the dht, got %s", ai.ID)
+	}
+	if _, err := r.GetValue(context.Background(), "/ipns/key"); err != routing.ErrNotFound {
+		t.Fatalf("expected no router to serve get-ipns, got %v", err)
+	}
+}
+
+func TestRoutingParallelMethod(t *testing.T) {
+	first, second := newTestRouter(t), newTestRouter(t)
+	routers := []Router{
+		{Routing: first, Priority: 1000},
+		{Routing: second, Priority: 2000},
+	}
+
+	construct := Routing(nil).(func(p2pOnlineRoutingIn) (routing.Routing, error))
+	r, err := construct(p2pOnlineRoutingIn{Routers: routers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := findProviders(r); len(found) != 1 || found[0] != first.id {
+		t.Fatalf("expected only the first router to be queried, got %v", found)
+	}
+
+	construct = Routing(map[string]config.Method{
+		config.MethodFindProviders: {Parallel: config.True},
+	}).(func(p2pOnlineRoutingIn) (routing.Routing, error))
+	r, err = construct(p2pOnlineRoutingIn{Routers: routers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := findProviders(r); len(found) != 2 {
+		t.Fatalf("expected both routers to be queried, got %v", found)
+	}
+}
+
+func TestRoutingUnknownMethod(t *testing.T) {
+	construct := Routing(map[string]config.Method{"find-everything": {}}).(func(p2pOnlineRoutingIn) (routing.Routing, error))
+	if _, err := construct(p2pOnlineRoutingIn{}); err == nil {
+		t.Fatal("expected unknown methods to be rejected")
+	}
+}
@@ -2,7 +2,10 @@ package libp2p
 
 import (
 	"context"
+	"fmt"
+
 	"github.com/ipfs/go-datastore"
+	config "github.com/ipfs/go-ipfs/config"
 	host "github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
 	routing "github.com/libp2p/go-libp2p-core/routing"
@@ -62,3 +65,25 @@ var (
 	DHTServerOption               = constructDHTRouting(dht.ModeServer)
 	NilRouterOption               = constructNilRouting
 )
+
+// CustomRoutingOption returns the option building the dht router declared in
+// the custom routing configuration, or no initial routing if there is none.
+func CustomRoutingOption(cfg *config.Routing) (RoutingOption, error) {
+	name, dht, err := cfg.DHTRouter()
+	if err != nil {
+		return nil, err
+	}
+	if dht == nil {
+		return NilRouterOption, nil
+	}
+	switch mode := dht.Parameters["Mode"]; mode {
+	case "", "auto":
+		return DHTOption, nil
+	case "client":
+		return DHTClientOption, nil
+	case "server":
+		return DHTServerOption, nil
+	default:
+		return nil, fmt.Errorf("Routing.Routers.%s: unknown DHT mode %q", name, mode)
+	}
+}
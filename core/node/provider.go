@@ -6,17 +6,20 @@ import (
 	"time"
 
 	"github.com/ipfs/go-fetcher"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	"github.com/ipfs/go-ipfs-pinner"
 	"github.com/ipfs/go-ipfs-provider"
 	"github.com/ipfs/go-ipfs-provider/batched"
 	q "github.com/ipfs/go-ipfs-provider/queue"
 	"github.com/ipfs/go-ipfs-provider/simple"
+	"github.com/ipfs/go-mfs"
 	"github.com/libp2p/go-libp2p-core/routing"
 	"github.com/multiformats/go-multihash"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/go-ipfs/core/node/helpers"
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	iprovider "github.com/ipfs/go-ipfs/provider"
 	"github.com/ipfs/go-ipfs/repo"
 )
 
@@ -24,27 +27,36 @@ const kReprovideFrequency = time.Hour * 12
 
 // SIMPLE
 
-// ProviderQueue creates new datastore backed provider queue
-func ProviderQueue(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo) (*q.Queue, error) {
-	return q.NewQueue(helpers.LifecycleCtx(mctx, lc), "provider-v1", repo.Datastore())
-}
+const (
+	providerQueueName         = "provider-v1"
+	providerPriorityQueueName = "provider-v2"
+)
 
-// SimpleProvider creates new record provider
-func SimpleProvider(mctx helpers.MetricsCtx, lc fx.Lifecycle, queue *q.Queue, rt routing.Routing) provider.Provider {
-	return simple.NewProvider(helpers.LifecycleCtx(mctx, lc), queue, rt)
+// ProviderQueue creates new datastore backed provider queue. Only the batched
+// provider system uses it, as it does not take the priority queue.
+func ProviderQueue(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo) (*q.Queue, error) {
+	return q.NewQueue(helpers.LifecycleCtx(mctx, lc), providerQueueName, repo.Datastore())
 }
 
-// SimpleReprovider creates new reprovider
-func SimpleReprovider(reproviderInterval time.Duration) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, rt routing.Routing, keyProvider simple.KeyChanFunc) (provider.Reprovider, error) {
-		return simple.NewReprovider(helpers.LifecycleCtx(mctx, lc), reproviderInterval, rt, keyProvider), nil
+// ProviderPriorityQueue creates new datastore backed provider priority queue,
+// taking over the keys left in the FIFO provider queue.
+func ProviderPriorityQueue(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo) (*iprovider.Queue, error) {
+	ctx := helpers.LifecycleCtx(mctx, lc)
+	queue, err := iprovider.NewQueue(ctx, providerPriorityQueueName, repo.Datastore())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := queue.ImportLegacy(ctx, providerQueueName); err != nil {
+		queue.Close()
+		return nil, fmt.Errorf("failed to import the provider queue: %w", err)
 	}
+	return queue, nil
 }
 
 // SimpleProviderSys creates new provider system
-func SimpleProviderSys(isOnline bool) interface{} {
-	return func(lc fx.Lifecycle, p provider.Provider, r provider.Reprovider) provider.System {
-		sys := provider.NewSystem(p, r)
+func SimpleProviderSys(isOnline bool, reprovideInterval time.Duration) interface{} {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, queue *iprovider.Queue, rt routing.Routing, keyProvider simple.KeyChanFunc) provider.System {
+		sys := iprovider.New(helpers.LifecycleCtx(mctx, lc), queue, rt, keyProvider, reprovideInterval)
 
 		if isOnline {
 			lc.Append(fx.Hook{
@@ -109,6 +121,13 @@ func BatchedProviderSys(isOnline bool, reprovideInterval string) interface{} {
 	}
 }
 
+func parseReprovideInterval(reprovideInterval string) (time.Duration, error) {
+	if reprovideInterval == "" {
+		return kReprovideFrequency, nil
+	}
+	return time.ParseDuration(reprovideInterval)
+}
+
 // ONLINE/OFFLINE
 
 // OnlineProviders groups units managing provider routing records online
@@ -117,9 +136,15 @@ func OnlineProviders(useStrategicProviding bool, useBatchedProviding bool, repro
 		return fx.Provide(provider.NewOfflineProvider)
 	}
 
+	reproviderInterval, err := parseReprovideInterval(reprovideInterval)
+	if err != nil {
+		return fx.Error(err)
+	}
+
 	return fx.Options(
-		SimpleProviders(reprovideStrategy, reprovideInterval),
-		maybeProvide(SimpleProviderSys(true), !useBatchedProviding),
+		SimpleProviders(reprovideStrategy),
+		maybeProvide(SimpleProviderSys(true, reproviderInterval), !useBatchedProviding),
+		maybeProvide(ProviderQueue, useBatchedProviding),
 		maybeProvide(BatchedProviderSys(true, reprovideInterval), useBatchedProviding),
 	)
 }
@@ -130,54 +155,47 @@ func OfflineProviders(useStrategicProviding bool, useBatchedProviding bool, repr
 		return fx.Provide(provider.NewOfflineProvider)
 	}
 
+	reproviderInterval, err := parseReprovideInterval(reprovideInterval)
+	if err != nil {
+		return fx.Error(err)
+	}
+
 	return fx.Options(
-		SimpleProviders(reprovideStrategy, reprovideInterval),
-		maybeProvide(SimpleProviderSys(false), true),
+		SimpleProviders(reprovideStrategy),
+		maybeProvide(SimpleProviderSys(false, reproviderInterval), true),
 		//maybeProvide(BatchedProviderSys(false, reprovideInterval), useBatchedProviding),
 	)
 }
 
 // SimpleProviders creates the simple provider/reprovider dependencies
-func SimpleProviders(reprovideStrategy string, reprovideInterval string) fx.Option {
-	reproviderInterval := kReprovideFrequency
-	if reprovideInterval != "" {
-		dur, err := time.ParseDuration(reprovideInterval)
-		if err != nil {
-			return fx.Error(err)
-		}
-
-		reproviderInterval = dur
-	}
-
-	var keyProvider fx.Option
-	switch reprovideStrategy {
-	case "all":
-		fallthrough
-	case "":
-		keyProvider = fx.Provide(simple.NewBlockstoreProvider)
-	case "roots":
-		keyProvider = fx.Provide(pinnedProviderStrategy(true))
-	case "pinned":
-		keyProvider = fx.Provide(pinnedProviderStrategy(false))
-	default:
-		return fx.Error(fmt.Errorf("unknown reprovider strategy '%s'", reprovideStrategy))
+func SimpleProviders(reprovideStrategy string) fx.Option {
+	strategy, err := iprovider.ParseStrategy(reprovideStrategy)
+	if err != nil {
+		return fx.Error(err)
 	}
 
 	return fx.Options(
-		fx.Provide(ProviderQueue),
-		fx.Provide(SimpleProvider),
-		keyProvider,
-		fx.Provide(SimpleReprovider(reproviderInterval)),
+		fx.Provide(ProviderPriorityQueue),
+		fx.Provide(strategyKeyProvider(strategy)),
 	)
 }
 
-func pinnedProviderStrategy(onlyRoots bool) interface{} {
-	type input struct {
-		fx.In
-		Pinner      pin.Pinner
-		IPLDFetcher fetcher.Factory `name:"ipldFetcher"`
-	}
-	return func(in input) simple.KeyChanFunc {
-		return simple.NewPinnedProvider(onlyRoots, in.Pinner, in.IPLDFetcher)
+type keySourcesIn struct {
+	fx.In
+
+	Blockstore  blockstore.Blockstore
+	Pinner      pin.Pinner
+	IPLDFetcher fetcher.Factory `name:"ipldFetcher"`
+	FilesRoot   *mfs.Root
+}
+
+func strategyKeyProvider(strategy iprovider.Strategy) interface{} {
+	return func(in keySourcesIn) simple.KeyChanFunc {
+		return strategy.KeyProvider(iprovider.KeySources{
+			Blockstore: in.Blockstore,
+			Pinner:     in.Pinner,
+			Fetcher:    in.IPLDFetcher,
+			FilesRoot:  in.FilesRoot,
+		})
 	}
 }
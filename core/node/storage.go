@@ -10,6 +10,7 @@ import (
 	"github.com/ipfs/go-ipfs/core/node/helpers"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/thirdparty/verifbs"
+	"github.com/ipfs/go-ipfs/urlstore"
 )
 
 // RepoConfig loads configuration from the repo
@@ -59,12 +60,22 @@ func GcBlockstoreCtor(bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockst
 }
 
 // GcBlockstoreCtor wraps GcBlockstore and adds Filestore support
-func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
+func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore, err error) {
 	gclocker = blockstore.NewGCLocker()
 
 	// hash security
 	fstore = filestore.NewFilestore(bb, repo.FileManager())
-	gcbs = blockstore.NewGCBlockstore(fstore, gclocker)
+	var fbs blockstore.Blockstore = fstore
+	if fm := repo.FileManager(); fm != nil && fm.AllowUrls {
+		// the blocks of the urlstore are checked against their origin
+		cfg, err := repo.Config()
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		store := urlstore.NewStore(repo.Datastore(), cfg.Experimental.UrlstoreHeaderEnv)
+		fbs = urlstore.NewBlockstore(fstore, store)
+	}
+	gcbs = blockstore.NewGCBlockstore(fbs, gclocker)
 	gcbs = &verifbs.VerifBSGC{GCBlockstore: gcbs}
 
 	bs = gcbs
@@ -0,0 +1,167 @@
+// Package fetch implements alternative ways to fetch whole DAGs ahead of the
+// commands working on them, which then find the blocks locally and only fall
+// back to bitswap for those still missing.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-graphsync"
+	logging "github.com/ipfs/go-log"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+var log = logging.Logger("fetch")
+
+// Fetch methods.
+const (
+	// Bitswap fetches blocks one by one with bitswap, as needed.
+	Bitswap = "bitswap"
+	// Graphsync fetches the whole DAG with graphsync first.
+	Graphsync = "graphsync"
+)
+
+const (
+	// maxGraphsyncProviders is the number of providers graphsync tries
+	// before giving up.
+	maxGraphsyncProviders = 3
+
+	// maxGraphsyncDepth is the deepest recursion graphsync responders
+	// accept by default. Deeper blocks are left to bitswap.
+	maxGraphsyncDepth = 100
+)
+
+// Variables so tests can shorten them.
+var (
+	// findProvidersTimeout bounds the search for the providers of a root.
+	findProvidersTimeout = time.Minute
+
+	// graphsyncProviderTimeout bounds the whole transfer from a provider.
+	graphsyncProviderTimeout = 10 * time.Minute
+
+	// graphsyncIdleTimeout is how long a provider may go without sending a
+	// block before the transfer from it is abandoned.
+	graphsyncIdleTimeout = 30 * time.Second
+)
+
+// errIdle is returned by fetchFrom when a provider stops sending blocks.
+var errIdle = errors.New("provider stopped sending blocks")
+
+// exploreAll explores the DAG as deep as responders allow.
+var exploreAll ipld.Node
+
+func init() {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	exploreAll = ssb.ExploreRecursive(selector.RecursionLimitDepth(maxGraphsyncDepth),
+		ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
+}
+
+// ErrNoProviders is returned by DAG when no provider could send the DAG.
+var ErrNoProviders = errors.New("no provider sent the DAG with graphsync")
+
+// GraphsyncFetcher fetches DAGs with graphsync from the providers of their
+// root.
+type GraphsyncFetcher struct {
+	Exchange graphsync.GraphExchange
+	Host     host.Host
+	Routing  routing.ContentRouting
+}
+
+// DAG fetches the DAG under root matching sel, or the whole DAG up to the
+// recursion depth responders accept if sel is nil. Providers of root are
+// tried one at a time until one sends the DAG without errors. Received
+// blocks are stored even when the transfer fails, so callers can fall back
+// to bitswap for the rest. Both the provider search and each transfer are
+// bounded in time, so a silent network or a stalled provider cannot keep
+// callers from that fallback.
+func (f *GraphsyncFetcher) DAG(ctx context.Context, root cid.Cid, sel ipld.Node) error {
+	if sel == nil {
+		sel = exploreAll
+	}
+
+	findCtx, cancel := context.WithTimeout(ctx, findProvidersTimeout)
+	defer cancel()
+
+	tried := 0
+	lastErr := ErrNoProviders
+	for prov := range f.Routing.FindProvidersAsync(findCtx, root, 0) {
+		if prov.ID == f.Host.ID() {
+			continue
+		}
+		tried++
+		err := f.fetchFrom(ctx, prov, root, sel)
+		if err == nil {
+			return nil
+		}
+		log.Debugf("graphsync fetch of %s from %s failed: %s", root, prov.ID, err)
+		lastErr = fmt.Errorf("%s: %w", prov.ID, err)
+		if ctx.Err() != nil || tried == maxGraphsyncProviders {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return lastErr
+}
+
+// fetchFrom fetches the DAG from a single provider. The transfer is cancelled
+// once it takes longer than graphsyncProviderTimeout, or when no block arrives
+// for graphsyncIdleTimeout.
+func (f *GraphsyncFetcher) fetchFrom(ctx context.Context, prov peer.AddrInfo, root cid.Cid, sel ipld.Node) error {
+	ctx, cancel := context.WithTimeout(ctx, graphsyncProviderTimeout)
+	defer cancel()
+
+	if err := f.Host.Connect(ctx, prov); err != nil {
+		return err
+	}
+
+	idle := time.NewTimer(graphsyncIdleTimeout)
+	defer idle.Stop()
+	idleC := idle.C
+
+	progress, errs := f.Exchange.Request(ctx, prov.ID, cidlink.Link{Cid: root}, sel)
+	var firstErr error
+	for progress != nil || errs != nil {
+		select {
+		case _, ok := <-progress:
+			if !ok {
+				progress = nil
+			} else if idleC != nil {
+				if !idle.Stop() {
+					<-idle.C
+				}
+				idle.Reset(graphsyncIdleTimeout)
+			}
+		case <-idleC:
+			// Keep draining the channels until the cancelled request
+			// closes them.
+			idleC = nil
+			if firstErr == nil {
+				firstErr = errIdle
+			}
+			cancel()
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+			} else if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,207 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-graphsync"
+	gsimpl "github.com/ipfs/go-graphsync/impl"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/ipfs/go-graphsync/storeutil"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	ipldprime "github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// staticRouting returns the same providers for any key.
+type staticRouting []peer.AddrInfo
+
+func (r staticRouting) Provide(context.Context, cid.Cid, bool) error { return nil }
+
+func (r staticRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo, len(r))
+	for _, p := range r {
+		ch <- p
+	}
+	close(ch)
+	return ch
+}
+
+// silentRouting never finds a provider, nor ends the search before ctx is
+// done.
+type silentRouting struct{}
+
+func (silentRouting) Provide(context.Context, cid.Cid, bool) error { return nil }
+
+func (silentRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+// stallingExchange never answers the requests sent to the stalled peer.
+type stallingExchange struct {
+	graphsync.GraphExchange
+	stalled peer.ID
+}
+
+func (e stallingExchange) Request(ctx context.Context, p peer.ID, root ipldprime.Link, sel ipldprime.Node, exts ...graphsync.ExtensionData) (<-chan graphsync.ResponseProgress, <-chan error) {
+	if p != e.stalled {
+		return e.GraphExchange.Request(ctx, p, root, sel, exts...)
+	}
+	progress := make(chan graphsync.ResponseProgress)
+	errs := make(chan error)
+	go func() {
+		<-ctx.Done()
+		close(progress)
+		close(errs)
+	}()
+	return progress, errs
+}
+
+func newPeer(t *testing.T, ctx context.Context, mn mocknet.Mocknet) (host.Host, blockstore.Blockstore, *gsimpl.GraphSync) {
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	gs := gsimpl.New(ctx, gsnet.NewFromLibp2pHost(h), storeutil.LinkSystemForBlockstore(bs))
+	return h, bs, gs.(*gsimpl.GraphSync)
+}
+
+func TestGraphsyncFetch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	provider, provBs, _ := newPeer(t, ctx, mn)
+	empty, _, _ := newPeer(t, ctx, mn)
+	self, selfBs, selfGs := newPeer(t, ctx, mn)
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	root, keys := addDAG(t, ctx, provBs)
+
+	// The first provider doesn't have the DAG, the second one does.
+	f := &GraphsyncFetcher{
+		Exchange: selfGs,
+		Host:     self,
+		Routing: staticRouting{
+			{ID: empty.ID(), Addrs: empty.Addrs()},
+			{ID: provider.ID(), Addrs: provider.Addrs()},
+		},
+	}
+	if err := f.DAG(ctx, root.Cid(), nil); err != nil {
+		t.Fatal(err)
+	}
+	assertHas(t, ctx, selfBs, keys)
+
+	f.Routing = staticRouting{{ID: empty.ID(), Addrs: empty.Addrs()}}
+	if err := f.DAG(ctx, root.Cid(), nil); err == nil {
+		t.Fatal("expected the fetch to fail")
+	}
+	f.Routing = staticRouting{}
+	if err := f.DAG(ctx, root.Cid(), nil); !errors.Is(err, ErrNoProviders) {
+		t.Fatalf("expected ErrNoProviders, got %v", err)
+	}
+}
+
+func TestGraphsyncFetchTimeouts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	defer func(find, idle time.Duration) {
+		findProvidersTimeout, graphsyncIdleTimeout = find, idle
+	}(findProvidersTimeout, graphsyncIdleTimeout)
+	findProvidersTimeout = time.Second
+	graphsyncIdleTimeout = 100 * time.Millisecond
+
+	mn := mocknet.New(ctx)
+	provider, provBs, _ := newPeer(t, ctx, mn)
+	stalled, _, _ := newPeer(t, ctx, mn)
+	self, selfBs, selfGs := newPeer(t, ctx, mn)
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	root, keys := addDAG(t, ctx, provBs)
+
+	// The first provider never sends a block, the second one is tried
+	// once it has been idle for too long.
+	f := &GraphsyncFetcher{
+		Exchange: stallingExchange{GraphExchange: selfGs, stalled: stalled.ID()},
+		Host:     self,
+		Routing: staticRouting{
+			{ID: stalled.ID(), Addrs: stalled.Addrs()},
+			{ID: provider.ID(), Addrs: provider.Addrs()},
+		},
+	}
+	if err := f.DAG(ctx, root.Cid(), nil); err != nil {
+		t.Fatal(err)
+	}
+	assertHas(t, ctx, selfBs, keys)
+
+	f.Routing = staticRouting{{ID: stalled.ID(), Addrs: stalled.Addrs()}}
+	if err := f.DAG(ctx, root.Cid(), nil); !errors.Is(err, errIdle) {
+		t.Fatalf("expected errIdle, got %v", err)
+	}
+
+	// A search that finds nothing ends with the search timeout.
+	f.Routing = silentRouting{}
+	start := time.Now()
+	if err := f.DAG(ctx, root.Cid(), nil); !errors.Is(err, ErrNoProviders) {
+		t.Fatalf("expected ErrNoProviders, got %v", err)
+	}
+	if d := time.Since(start); d > 10*findProvidersTimeout {
+		t.Fatalf("the provider search took %s", d)
+	}
+}
+
+// addDAG adds a directory of three leaves to bs and returns its root along
+// with the keys of all its blocks.
+func addDAG(t *testing.T, ctx context.Context, bs blockstore.Blockstore) (ipld.Node, []cid.Cid) {
+	dserv := dag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	root := ft.EmptyDirNode()
+	var keys []cid.Cid
+	for _, data := range []string{"a", "b", "c"} {
+		leaf := dag.NewRawNode([]byte(data))
+		if err := dserv.Add(ctx, leaf); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink(data, leaf); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, leaf.Cid())
+	}
+	if err := dserv.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	return root, append(keys, root.Cid())
+}
+
+func assertHas(t *testing.T, ctx context.Context, bs blockstore.Blockstore, keys []cid.Cid) {
+	for _, c := range keys {
+		has, err := bs.Has(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("%s was not fetched", c)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+)
+
+// Priority orders the keys waiting in a Queue: keys with a higher priority
+// are announced first.
+type Priority int
+
+const (
+	// PriorityLow is for keys announced in bulk, such as the blocks of the
+	// files added.
+	PriorityLow Priority = iota
+	// PriorityNormal is the priority of keys given to Provide.
+	PriorityNormal
+	// PriorityRoot is for the roots of added or pinned DAGs.
+	PriorityRoot
+	// PriorityHigh is for keys explicitly provided by the user.
+	PriorityHigh
+
+	numPriorities
+)
+
+var priorityNames = [numPriorities]string{"low", "normal", "root", "high"}
+
+func (p Priority) String() string {
+	if p < 0 || p >= numPriorities {
+		return strconv.Itoa(int(p))
+	}
+	return priorityNames[p]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Priority) UnmarshalText(b []byte) error {
+	for i, name := range priorityNames {
+		if name == string(b) {
+			*p = Priority(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown provide priority %q", b)
+}
+
+// maxQueueDelay is how long a key can wait in the queue before it is
+// dequeued ahead of keys with a higher priority, so that a steady stream
+// of high priority keys can't starve the others.
+var maxQueueDelay = 30 * time.Minute
+
+// QueueEntry is a key waiting in a Queue.
+type QueueEntry struct {
+	Cid      cid.Cid
+	Priority Priority
+	Enqueued time.Time
+}
+
+// queueEntry is a QueueEntry with its datastore key.
+type queueEntry struct {
+	QueueEntry
+	key datastore.Key
+}
+
+type enqueueRequest struct {
+	c    cid.Cid
+	p    Priority
+	done chan<- error
+}
+
+// Queue is a datastore backed queue of keys to provide. Keys are dequeued
+// by priority, then in the order they were enqueued, except for keys that
+// waited longer than maxQueueDelay which are dequeued first. A key is only
+// queued once: enqueuing it again only raises its priority.
+//
+// Entries are stored as /<name>/queue/<priority>/<unix nanos>/<cid>, with an
+// index of the queued keys at /<name>/index/<cid>.
+type Queue struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed chan struct{}
+
+	name string
+	ds   datastore.Datastore
+
+	enqueue chan enqueueRequest
+	dequeue chan cid.Cid
+
+	lk     sync.Mutex
+	counts [numPriorities]int
+}
+
+// NewQueue creates a queue stored on ds under name, resuming with the keys
+// stored there.
+func NewQueue(ctx context.Context, name string, ds datastore.Datastore) (*Queue, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	q := &Queue{
+		ctx:     ctx,
+		cancel:  cancel,
+		closed:  make(chan struct{}),
+		name:    name,
+		ds:      ds,
+		enqueue: make(chan enqueueRequest),
+		dequeue: make(chan cid.Cid),
+	}
+	if err := q.countEntries(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+	go q.worker()
+	return q, nil
+}
+
+// Close stops the queue. Queued keys stay in the datastore.
+func (q *Queue) Close() error {
+	q.cancel()
+	<-q.closed
+	return nil
+}
+
+// Enqueue queues c with priority p. If c is already queued with a lower
+// priority, it is moved to p.
+func (q *Queue) Enqueue(c cid.Cid, p Priority) error {
+	if p < 0 || p >= numPriorities {
+		return fmt.Errorf("invalid provide priority %d", p)
+	}
+	select {
+	case q.enqueue <- enqueueRequest{c: c, p: p}:
+		return nil
+	case <-q.ctx.Done():
+		return ErrClosed
+	}
+}
+
+// enqueueSync is Enqueue, but returns once c is stored.
+func (q *Queue) enqueueSync(c cid.Cid, p Priority) error {
+	done := make(chan error, 1)
+	select {
+	case q.enqueue <- enqueueRequest{c: c, p: p, done: done}:
+	case <-q.ctx.Done():
+		return ErrClosed
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-q.closed:
+		return ErrClosed
+	}
+}
+
+// Dequeue returns a channel of the keys to provide, in order.
+func (q *Queue) Dequeue() <-chan cid.Cid {
+	return q.dequeue
+}
+
+// Len returns the number of queued keys.
+func (q *Queue) Len() int {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	n := 0
+	for _, c := range q.counts {
+		n += c
+	}
+	return n
+}
+
+// Lengths returns the number of queued keys of each priority.
+func (q *Queue) Lengths() map[Priority]int {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	lengths := make(map[Priority]int, numPriorities)
+	for p, c := range q.counts {
+		lengths[Priority(p)] = c
+	}
+	return lengths
+}
+
+// Pending returns up to limit queued keys, from the highest priority to the
+// lowest, or all of them if limit is 0.
+func (q *Queue) Pending(ctx context.Context, limit int) ([]QueueEntry, error) {
+	var entries []QueueEntry
+	for p := numPriorities - 1; p >= 0; p-- {
+		qlimit := 0
+		if limit > 0 {
+			qlimit = limit - len(entries)
+			if qlimit <= 0 {
+				break
+			}
+		}
+		found, err := q.query(ctx, p, qlimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range found {
+			entries = append(entries, e.QueueEntry)
+		}
+	}
+	return entries, nil
+}
+
+// ImportLegacy moves the keys of a go-ipfs-provider queue stored under
+// legacyName to this queue, with the normal priority, and returns how many
+// were moved.
+func (q *Queue) ImportLegacy(ctx context.Context, legacyName string) (int, error) {
+	res, err := q.ds.Query(ctx, query.Query{
+		Prefix: datastore.NewKey(legacyName).ChildString("queue").String(),
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, e := range entries {
+		c, err := cid.Parse(e.Value)
+		if err != nil {
+			log.Warnf("dropping invalid legacy provider queue entry %s: %s", e.Key, err)
+		} else if err := q.enqueueSync(c, PriorityNormal); err != nil {
+			return n, err
+		} else {
+			n++
+		}
+		if err := q.ds.Delete(ctx, datastore.NewKey(e.Key)); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (q *Queue) prefix(p Priority) datastore.Key {
+	return datastore.NewKey(q.name).ChildString("queue").ChildString(strconv.Itoa(int(p)))
+}
+
+func (q *Queue) indexKey(c cid.Cid) datastore.Key {
+	return datastore.NewKey(q.name).ChildString("index").ChildString(c.String())
+}
+
+// parseEntry parses the datastore key of an entry.
+func parseEntry(k datastore.Key) (*queueEntry, error) {
+	ns := k.Namespaces()
+	if len(ns) < 3 {
+		return nil, fmt.Errorf("invalid provider queue key %s", k)
+	}
+	ns = ns[len(ns)-3:]
+	p, err := strconv.Atoi(ns[0])
+	if err != nil || p < 0 || p >= int(numPriorities) {
+		return nil, fmt.Errorf("invalid provider queue key %s", k)
+	}
+	nanos, err := strconv.ParseInt(ns[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provider queue key %s: %s", k, err)
+	}
+	c, err := cid.Decode(ns[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid provider queue key %s: %s", k, err)
+	}
+	return &queueEntry{
+		QueueEntry: QueueEntry{Cid: c, Priority: Priority(p), Enqueued: time.Unix(0, nanos)},
+		key:        k,
+	}, nil
+}
+
+// query returns up to limit entries of priority p in order, or all of them
+// if limit is 0.
+func (q *Queue) query(ctx context.Context, p Priority, limit int) ([]*queueEntry, error) {
+	res, err := q.ds.Query(ctx, query.Query{
+		Prefix:   q.prefix(p).String(),
+		KeysOnly: true,
+		Orders:   []query.Order{query.OrderByKey{}},
+		Limit:    limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var entries []*queueEntry
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		e, err := parseEntry(datastore.RawKey(r.Key))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (q *Queue) countEntries(ctx context.Context) error {
+	for p := Priority(0); p < numPriorities; p++ {
+		res, err := q.ds.Query(ctx, query.Query{
+			Prefix:   q.prefix(p).String(),
+			KeysOnly: true,
+		})
+		if err != nil {
+			return err
+		}
+		n := 0
+		for r := range res.Next() {
+			if r.Error != nil {
+				res.Close()
+				return r.Error
+			}
+			n++
+		}
+		res.Close()
+		q.counts[p] = n
+	}
+	return nil
+}
+
+func (q *Queue) addCount(p Priority, n int) {
+	q.lk.Lock()
+	q.counts[p] += n
+	q.lk.Unlock()
+}
+
+// heads caches the first entry of each priority. A nil entry in a loaded
+// slot means the priority is empty.
+type heads struct {
+	entries [numPriorities]*queueEntry
+	loaded  [numPriorities]bool
+}
+
+func (h *heads) invalidate(p Priority) {
+	h.entries[p] = nil
+	h.loaded[p] = false
+}
+
+// next returns the next entry to dequeue, or nil if the queue is empty.
+func (q *Queue) next(h *heads) *queueEntry {
+	for p := Priority(0); p < numPriorities; p++ {
+		if h.loaded[p] {
+			continue
+		}
+		entries, err := q.query(q.ctx, p, 1)
+		if err != nil {
+			log.Errorf("failed to load provider queue: %s", err)
+			continue
+		}
+		h.loaded[p] = true
+		if len(entries) > 0 {
+			h.entries[p] = entries[0]
+		}
+	}
+
+	// Overdue entries go first, oldest first.
+	var next *queueEntry
+	deadline := time.Now().Add(-maxQueueDelay)
+	for _, e := range h.entries {
+		if e != nil && e.Enqueued.Before(deadline) && (next == nil || e.Enqueued.Before(next.Enqueued)) {
+			next = e
+		}
+	}
+	if next != nil {
+		return next
+	}
+	for p := numPriorities - 1; p >= 0; p-- {
+		if e := h.entries[p]; e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// put stores c unless it is already queued with at least priority p.
+func (q *Queue) put(h *heads, c cid.Cid, p Priority) error {
+	idx := q.indexKey(c)
+	old, err := q.ds.Get(q.ctx, idx)
+	switch err {
+	case nil:
+		oldKey := datastore.RawKey(string(old))
+		oldEntry, err := parseEntry(oldKey)
+		if err != nil {
+			return err
+		}
+		if oldEntry.Priority >= p {
+			return nil
+		}
+		if err := q.ds.Delete(q.ctx, oldKey); err != nil {
+			return err
+		}
+		q.addCount(oldEntry.Priority, -1)
+		if head := h.entries[oldEntry.Priority]; head != nil && head.key == oldKey {
+			h.invalidate(oldEntry.Priority)
+		}
+	case datastore.ErrNotFound:
+	default:
+		return err
+	}
+
+	now := time.Now()
+	k := q.prefix(p).ChildString(fmt.Sprintf("%020d", now.UnixNano())).ChildString(c.String())
+	if err := q.ds.Put(q.ctx, k, c.Bytes()); err != nil {
+		return err
+	}
+	if err := q.ds.Put(q.ctx, idx, []byte(k.String())); err != nil {
+		return err
+	}
+	q.addCount(p, 1)
+	if h.loaded[p] && h.entries[p] == nil {
+		h.entries[p] = &queueEntry{
+			QueueEntry: QueueEntry{Cid: c, Priority: p, Enqueued: now},
+			key:        k,
+		}
+	}
+	return nil
+}
+
+// remove deletes a dequeued entry.
+func (q *Queue) remove(h *heads, e *queueEntry) error {
+	h.invalidate(e.Priority)
+	q.addCount(e.Priority, -1)
+	if err := q.ds.Delete(q.ctx, e.key); err != nil {
+		return err
+	}
+	return q.ds.Delete(q.ctx, q.indexKey(e.Cid))
+}
+
+func (q *Queue) worker() {
+	defer close(q.closed)
+
+	var h heads
+	var next *queueEntry
+	for {
+		if next == nil {
+			next = q.next(&h)
+		}
+
+		var dequeue chan cid.Cid
+		var c cid.Cid
+		if next != nil {
+			dequeue = q.dequeue
+			c = next.Cid
+		}
+
+		select {
+		case req := <-q.enqueue:
+			err := q.put(&h, req.c, req.p)
+			if err != nil {
+				log.Errorf("failed to queue %s: %s", req.c, err)
+			}
+			if req.done != nil {
+				req.done <- err
+			}
+			// A higher priority key may have arrived.
+			next = nil
+		case dequeue <- c:
+			if err := q.remove(&h, next); err != nil {
+				log.Errorf("failed to remove %s from the provider queue: %s", c, err)
+			}
+			next = nil
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
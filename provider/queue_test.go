@@ -0,0 +1,176 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	q "github.com/ipfs/go-ipfs-provider/queue"
+)
+
+func dequeueN(t *testing.T, queue *Queue, n int) []cid.Cid {
+	var out []cid.Cid
+	for i := 0; i < n; i++ {
+		select {
+		case c := <-queue.Dequeue():
+			out = append(out, c)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only dequeued %d keys out of %d", i, n)
+		}
+	}
+	return out
+}
+
+func expectOrder(t *testing.T, got, expected []cid.Cid) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d keys, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("key %d: expected %s, got %s", i, expected[i], got[i])
+		}
+	}
+}
+
+func newTestQueue(t *testing.T, ds datastore.Datastore) *Queue {
+	queue, err := NewQueue(context.Background(), "test", ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { queue.Close() })
+	return queue
+}
+
+func TestQueuePriorities(t *testing.T) {
+	cids := testCids(t, 6)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	queue := newTestQueue(t, ds)
+
+	for i, p := range []Priority{PriorityLow, PriorityNormal, PriorityLow, PriorityRoot, PriorityHigh, PriorityNormal} {
+		if err := queue.enqueueSync(cids[i], p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := queue.Len(); n != 6 {
+		t.Fatalf("expected 6 queued keys, got %d", n)
+	}
+
+	expected := []cid.Cid{cids[4], cids[3], cids[1], cids[5], cids[0], cids[2]}
+	pending, err := queue.Pending(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pendingCids []cid.Cid
+	for _, e := range pending {
+		pendingCids = append(pendingCids, e.Cid)
+	}
+	expectOrder(t, pendingCids, expected)
+
+	expectOrder(t, dequeueN(t, queue, 6), expected)
+}
+
+func TestQueueDedup(t *testing.T) {
+	cids := testCids(t, 3)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	queue := newTestQueue(t, ds)
+
+	for _, req := range []struct {
+		c cid.Cid
+		p Priority
+	}{
+		{cids[0], PriorityNormal},
+		{cids[1], PriorityNormal},
+		{cids[0], PriorityLow},
+		{cids[2], PriorityNormal},
+		{cids[2], PriorityHigh},
+		{cids[1], PriorityNormal},
+	} {
+		if err := queue.enqueueSync(req.c, req.p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lengths := queue.Lengths()
+	if lengths[PriorityNormal] != 2 || lengths[PriorityHigh] != 1 || queue.Len() != 3 {
+		t.Fatalf("unexpected queue lengths %v", lengths)
+	}
+	expectOrder(t, dequeueN(t, queue, 3), []cid.Cid{cids[2], cids[0], cids[1]})
+	if n := queue.Len(); n != 0 {
+		t.Fatalf("expected an empty queue, got %d keys", n)
+	}
+
+	// Dequeued keys can be queued again.
+	if err := queue.enqueueSync(cids[0], PriorityLow); err != nil {
+		t.Fatal(err)
+	}
+	expectOrder(t, dequeueN(t, queue, 1), cids[:1])
+}
+
+func TestQueueMaxDelay(t *testing.T) {
+	cids := testCids(t, 2)
+	defer func(d time.Duration) { maxQueueDelay = d }(maxQueueDelay)
+	maxQueueDelay = 50 * time.Millisecond
+
+	queue, err := NewQueue(context.Background(), "test", dssync.MutexWrap(datastore.NewMapDatastore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+
+	if err := queue.enqueueSync(cids[0], PriorityLow); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * maxQueueDelay)
+	if err := queue.enqueueSync(cids[1], PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	expectOrder(t, dequeueN(t, queue, 2), cids)
+}
+
+func TestQueuePersistence(t *testing.T) {
+	ctx := context.Background()
+	cids := testCids(t, 4)
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	legacy, err := q.NewQueue(ctx, "legacy", ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cids[:2] {
+		if err := legacy.Enqueue(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Closing waits for the enqueued keys to be stored.
+	time.Sleep(100 * time.Millisecond)
+	legacy.Close()
+
+	queue, err := NewQueue(ctx, "test", ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.enqueueSync(cids[2], PriorityRoot); err != nil {
+		t.Fatal(err)
+	}
+	n, err := queue.ImportLegacy(ctx, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 imported keys, got %d", n)
+	}
+	queue.Close()
+
+	queue = newTestQueue(t, ds)
+	if err := queue.enqueueSync(cids[3], PriorityLow); err != nil {
+		t.Fatal(err)
+	}
+	if n := queue.Len(); n != 4 {
+		t.Fatalf("expected 4 queued keys, got %d", n)
+	}
+	expectOrder(t, dequeueN(t, queue, 4), []cid.Cid{cids[2], cids[0], cids[1], cids[3]})
+}
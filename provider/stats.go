@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats are statistics about the provider system.
+type Stats struct {
+	// QueueLength is the number of keys waiting to be announced.
+	QueueLength int
+	// QueueLengths is the number of keys waiting of each priority.
+	QueueLengths map[Priority]int
+
+	// Provides and Failures count announcements, of both new and
+	// reprovided keys, since the node started.
+	Provides uint64
+	Failures uint64
+
+	// ProvidesLastInterval counts the successful announcements during
+	// the last reprovide interval (or hour, when reproviding is disabled).
+	ProvidesLastInterval uint64
+
+	// ReprovideInterval is the time between reprovides, 0 if disabled.
+	ReprovideInterval time.Duration
+	// NextReprovide is when the next scheduled reprovide starts.
+	NextReprovide time.Time `json:",omitempty"`
+
+	// Reproviding is true while a reprovide is running.
+	Reproviding bool
+	// LastReprovide describes the running reprovide, or the last one.
+	LastReprovide *ReprovideStats `json:",omitempty"`
+}
+
+// ReprovideStats describe a single reprovide.
+type ReprovideStats struct {
+	Start    time.Time
+	End      time.Time `json:",omitempty"`
+	Provides uint64
+	Failures uint64
+	Error    string `json:",omitempty"`
+}
+
+// windowBuckets is the resolution of the ProvidesLastInterval window.
+const windowBuckets = 60
+
+// statsTracker records the events Stats are computed from.
+type statsTracker struct {
+	lk sync.Mutex
+
+	interval time.Duration
+
+	totalProvides uint64
+	totalFailures uint64
+
+	// window counts provides in windowBuckets buckets spanning the
+	// reprovide interval, lastBucket being the index of the newest one
+	window     [windowBuckets]uint64
+	bucketSize time.Duration
+	lastBucket int64
+
+	next        time.Time
+	reproviding bool
+	last        *ReprovideStats
+}
+
+func newStatsTracker(interval time.Duration) *statsTracker {
+	window := interval
+	if window <= 0 {
+		window = time.Hour
+	}
+	return &statsTracker{
+		interval:   interval,
+		bucketSize: window / windowBuckets,
+	}
+}
+
+// advance moves the window up to now, clearing expired buckets.
+func (t *statsTracker) advance(now time.Time) {
+	b := now.UnixNano() / int64(t.bucketSize)
+	if b <= t.lastBucket {
+		return
+	}
+	for i := t.lastBucket + 1; i <= b && i <= t.lastBucket+windowBuckets; i++ {
+		t.window[i%windowBuckets] = 0
+	}
+	t.lastBucket = b
+}
+
+func (t *statsTracker) count(now time.Time, ok bool) {
+	if !ok {
+		t.totalFailures++
+		return
+	}
+	t.totalProvides++
+	t.advance(now)
+	t.window[t.lastBucket%windowBuckets]++
+}
+
+func (t *statsTracker) provided(now time.Time, ok bool) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.count(now, ok)
+}
+
+func (t *statsTracker) reprovided(now time.Time, ok bool) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.count(now, ok)
+	if ok {
+		t.last.Provides++
+	} else {
+		t.last.Failures++
+	}
+}
+
+func (t *statsTracker) scheduled(next time.Time) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.next = next
+}
+
+func (t *statsTracker) reprovideStarted(now time.Time) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.reproviding = true
+	t.last = &ReprovideStats{Start: now}
+}
+
+func (t *statsTracker) isReproviding() bool {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	return t.reproviding
+}
+
+func (t *statsTracker) reprovideEnded(now time.Time, err error) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.reproviding = false
+	t.last.End = now
+	if err != nil {
+		t.last.Error = err.Error()
+	}
+}
+
+func (t *statsTracker) snapshot(now time.Time) *Stats {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	t.advance(now)
+	var lastInterval uint64
+	for _, n := range t.window {
+		lastInterval += n
+	}
+
+	st := &Stats{
+		Provides:             t.totalProvides,
+		Failures:             t.totalFailures,
+		ProvidesLastInterval: lastInterval,
+		ReprovideInterval:    t.interval,
+		NextReprovide:        t.next,
+		Reproviding:          t.reproviding,
+	}
+	if t.last != nil {
+		last := *t.last
+		st.LastReprovide = &last
+	}
+	return st
+}
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-fetcher"
+	fetcherhelpers "github.com/ipfs/go-fetcher/helpers"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipfs/go-ipfs-provider/simple"
+	"github.com/ipfs/go-mfs"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// Reprovider strategies. Strategies other than "all" can be combined with
+// "+", e.g. "pinned+mfs", and their keys are announced in that order.
+const (
+	// StrategyAll announces all the blocks in the blockstore.
+	StrategyAll = "all"
+	// StrategyPinned announces all the blocks of pinned DAGs.
+	StrategyPinned = "pinned"
+	// StrategyRoots announces direct pins and the roots of recursive pins.
+	StrategyRoots = "roots"
+	// StrategyMFS announces all the blocks reachable from the MFS root.
+	StrategyMFS = "mfs"
+	// StrategyFlat announces all the blocks in the blockstore, enumerating
+	// its keys without walking any DAG. Combined with other strategies, it
+	// announces their keys first.
+	StrategyFlat = "flat"
+)
+
+// Strategy is a parsed reprovider strategy.
+type Strategy struct {
+	All    bool
+	Pinned bool
+	Roots  bool
+	MFS    bool
+	Flat   bool
+}
+
+// ParseStrategy parses a reprovider strategy. The empty string is "all".
+func ParseStrategy(s string) (Strategy, error) {
+	var st Strategy
+	if s == "" || s == StrategyAll {
+		st.All = true
+		return st, nil
+	}
+	for _, part := range strings.Split(s, "+") {
+		switch part {
+		case StrategyPinned:
+			st.Pinned = true
+		case StrategyRoots:
+			st.Roots = true
+		case StrategyMFS:
+			st.MFS = true
+		case StrategyFlat:
+			st.Flat = true
+		case StrategyAll:
+			return st, fmt.Errorf("reprovider strategy %q can't be combined with others", StrategyAll)
+		default:
+			return st, fmt.Errorf("unknown reprovider strategy '%s'", part)
+		}
+	}
+	return st, nil
+}
+
+// KeySources are what strategies get keys from.
+type KeySources struct {
+	Blockstore blockstore.Blockstore
+	Pinner     simple.Pinner
+	Fetcher    fetcher.Factory
+	FilesRoot  *mfs.Root
+}
+
+// KeyProvider returns the keys to reprovide for the strategy.
+func (st Strategy) KeyProvider(src KeySources) simple.KeyChanFunc {
+	if st.All {
+		return simple.NewBlockstoreProvider(src.Blockstore)
+	}
+
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		out := make(chan cid.Cid)
+		go func() {
+			defer close(out)
+			if err := st.walk(ctx, src, out); err != nil {
+				log.Errorf("reprovide: %s", err)
+			}
+		}()
+		return out, nil
+	}
+}
+
+// walk sends the keys of the strategy to out, once each.
+func (st Strategy) walk(ctx context.Context, src KeySources, out chan<- cid.Cid) error {
+	// the keys are announced by multihash, and the blockstore lists them as
+	// raw CIDs
+	seen := make(map[string]struct{})
+	send := func(c cid.Cid) error {
+		if _, ok := seen[string(c.Hash())]; ok {
+			return nil
+		}
+		seen[string(c.Hash())] = struct{}{}
+		select {
+		case out <- c:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	session := src.Fetcher.NewSession(ctx)
+	sendDAG := func(root cid.Cid) error {
+		if err := send(root); err != nil {
+			return err
+		}
+		return fetcherhelpers.BlockAll(ctx, session, cidlink.Link{Cid: root}, func(res fetcher.FetchResult) error {
+			if clink, ok := res.LastBlockLink.(cidlink.Link); ok {
+				return send(clink.Cid)
+			}
+			return nil
+		})
+	}
+
+	if st.Pinned || st.Roots {
+		dkeys, err := src.Pinner.DirectKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("direct pins: %w", err)
+		}
+		for _, k := range dkeys {
+			if err := send(k); err != nil {
+				return err
+			}
+		}
+
+		rkeys, err := src.Pinner.RecursiveKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("recursive pins: %w", err)
+		}
+		for _, k := range rkeys {
+			if st.Pinned {
+				err = sendDAG(k)
+			} else {
+				err = send(k)
+			}
+			if err != nil {
+				return fmt.Errorf("recursive pins: %w", err)
+			}
+		}
+	}
+
+	if st.MFS {
+		root, err := src.FilesRoot.GetDirectory().GetNode()
+		if err != nil {
+			return fmt.Errorf("mfs root: %w", err)
+		}
+		if err := sendDAG(root.Cid()); err != nil {
+			return fmt.Errorf("mfs: %w", err)
+		}
+	}
+
+	if st.Flat {
+		keys, err := src.Blockstore.AllKeysChan(ctx)
+		if err != nil {
+			return fmt.Errorf("blockstore: %w", err)
+		}
+		for k := range keys {
+			// the blocks are not kept in seen, which would hold every key
+			// of the blockstore
+			if _, ok := seen[string(k.Hash())]; ok {
+				continue
+			}
+			select {
+			case out <- k:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bsfetcher "github.com/ipfs/go-fetcher/impl/blockservice"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	ft "github.com/ipfs/go-unixfs"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	ipldprime "github.com/ipld/go-ipld-prime"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+)
+
+type mockPinner struct {
+	direct, recursive []cid.Cid
+}
+
+func (p *mockPinner) DirectKeys(context.Context) ([]cid.Cid, error) {
+	return p.direct, nil
+}
+
+func (p *mockPinner) RecursiveKeys(context.Context) ([]cid.Cid, error) {
+	return p.recursive, nil
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, test := range []struct {
+		strategy string
+		expected Strategy
+		err      bool
+	}{
+		{"", Strategy{All: true}, false},
+		{"all", Strategy{All: true}, false},
+		{"pinned", Strategy{Pinned: true}, false},
+		{"roots", Strategy{Roots: true}, false},
+		{"mfs", Strategy{MFS: true}, false},
+		{"pinned+mfs", Strategy{Pinned: true, MFS: true}, false},
+		{"flat", Strategy{Flat: true}, false},
+		{"mfs+flat", Strategy{MFS: true, Flat: true}, false},
+		{"all+mfs", Strategy{}, true},
+		{"everything", Strategy{}, true},
+	} {
+		st, err := ParseStrategy(test.strategy)
+		if (err != nil) != test.err {
+			t.Fatalf("%q: unexpected error %v", test.strategy, err)
+		}
+		if err == nil && st != test.expected {
+			t.Fatalf("%q: expected %+v, got %+v", test.strategy, test.expected, st)
+		}
+	}
+}
+
+// testDAG adds a root with two children to dserv.
+func testDAG(t *testing.T, dserv ipld.DAGService, name string) (root *dag.ProtoNode, children []cid.Cid) {
+	ctx := context.Background()
+	root = ft.EmptyDirNode()
+	for _, data := range []string{name + "-a", name + "-b"} {
+		child := dag.NewRawNode([]byte(data))
+		if err := dserv.Add(ctx, child); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink(data, child); err != nil {
+			t.Fatal(err)
+		}
+		children = append(children, child.Cid())
+	}
+	if err := dserv.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	return root, children
+}
+
+func TestStrategyKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	bserv := blockservice.New(bs, offline.Exchange(bs))
+	dserv := dag.NewDAGService(bserv)
+
+	fetcherConfig := bsfetcher.NewFetcherConfig(bserv)
+	fetcherConfig.PrototypeChooser = dagpb.AddSupportToChooser(func(ipldprime.Link, ipldprime.LinkContext) (ipldprime.NodePrototype, error) {
+		return basicnode.Prototype.Any, nil
+	})
+
+	direct := dag.NewRawNode([]byte("direct"))
+	if err := dserv.Add(ctx, direct); err != nil {
+		t.Fatal(err)
+	}
+	pinned, pinnedChildren := testDAG(t, dserv, "pinned")
+	pinner := &mockPinner{direct: []cid.Cid{direct.Cid()}, recursive: []cid.Cid{pinned.Cid()}}
+
+	mfsDir, mfsChildren := testDAG(t, dserv, "mfs")
+	root, err := mfs.NewRoot(ctx, dserv, mfsDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	src := KeySources{
+		Blockstore: bs,
+		Pinner:     pinner,
+		Fetcher:    fetcherConfig,
+		FilesRoot:  root,
+	}
+
+	loose := dag.NewRawNode([]byte("loose"))
+	if err := dserv.Add(ctx, loose); err != nil {
+		t.Fatal(err)
+	}
+
+	pinnedKeys := append([]cid.Cid{direct.Cid(), pinned.Cid()}, pinnedChildren...)
+	mfsKeys := append([]cid.Cid{mfsDir.Cid()}, mfsChildren...)
+
+	allKeys := append(append([]cid.Cid{loose.Cid()}, pinnedKeys...), mfsKeys...)
+
+	for _, test := range []struct {
+		strategy string
+		expected []cid.Cid
+		first    int // the number of expected keys sent first, in any order
+	}{
+		{"roots", []cid.Cid{direct.Cid(), pinned.Cid()}, 0},
+		{"pinned", pinnedKeys, 0},
+		{"mfs", mfsKeys, 0},
+		{"pinned+mfs", append(append([]cid.Cid{}, pinnedKeys...), mfsKeys...), 0},
+		{"flat", allKeys, 0},
+		{"mfs+flat", append(append([]cid.Cid{}, mfsKeys...), loose.Cid(), direct.Cid(), pinned.Cid(), pinnedChildren[0], pinnedChildren[1]), len(mfsKeys)},
+	} {
+		st, err := ParseStrategy(test.strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := st.KeyProvider(src)(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the blockstore lists raw CIDs, so the keys are compared by
+		// multihash
+		found := make(map[string]bool)
+		for c := range keys {
+			if len(found) < test.first && !hashIn(c, test.expected[:test.first]) {
+				t.Fatalf("%s: %s was sent before %s", test.strategy, c, test.expected[:test.first])
+			}
+			if found[string(c.Hash())] {
+				t.Fatalf("%s: %s was sent twice", test.strategy, c)
+			}
+			found[string(c.Hash())] = true
+		}
+		if len(found) != len(test.expected) {
+			t.Fatalf("%s: expected %d keys, got %d", test.strategy, len(test.expected), len(found))
+		}
+		for _, c := range test.expected {
+			if !found[string(c.Hash())] {
+				t.Fatalf("%s: %s is missing", test.strategy, c)
+			}
+		}
+	}
+}
+
+func hashIn(c cid.Cid, cids []cid.Cid) bool {
+	for _, k := range cids {
+		if string(k.Hash()) == string(c.Hash()) {
+			return true
+		}
+	}
+	return false
+}
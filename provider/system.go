@@ -0,0 +1,275 @@
+// Package provider implements the provider system used with the default
+// (non-batched) providing: new keys are announced from a datastore backed
+// priority queue, and all keys are reprovided at an interval, while keeping
+// statistics about both.
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs-provider/simple"
+	logging "github.com/ipfs/go-log"
+	"github.com/ipfs/go-verifcid"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+var log = logging.Logger("provider")
+
+// ErrClosed is returned by Reprovide when the system is closed.
+var ErrClosed = errors.New("provider system stopped")
+
+const (
+	// provideWorkers is the number of keys announced concurrently from
+	// the queue.
+	provideWorkers = 8
+
+	// reprovideAttempts is the number of times providing a key is tried
+	// during a reprovide before it is counted as a failure.
+	reprovideAttempts = 3
+
+	// initialReprovideDelay delays the first reprovide after startup, so
+	// we don't reprovide when about to stop.
+	initialReprovideDelay = time.Minute
+)
+
+// reprovideRetryDelay is multiplied by the attempt number to get the delay
+// before retrying to provide a key.
+var reprovideRetryDelay = time.Second
+
+// System announces new keys and reprovides all keys given by a
+// simple.KeyChanFunc. It implements go-ipfs-provider's System.
+type System struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	queue *Queue
+
+	rsys        routing.ContentRouting
+	keyProvider simple.KeyChanFunc
+	interval    time.Duration
+
+	trigger chan reprovideRequest
+
+	stats *statsTracker
+}
+
+// New creates a provider system announcing the keys of queue. Keys are
+// reprovided every interval, or never if interval is 0.
+func New(ctx context.Context, queue *Queue, rsys routing.ContentRouting, keyProvider simple.KeyChanFunc, interval time.Duration) *System {
+	ctx, cancel := context.WithCancel(ctx)
+	return &System{
+		ctx:    ctx,
+		cancel: cancel,
+
+		queue: queue,
+
+		rsys:        rsys,
+		keyProvider: keyProvider,
+		interval:    interval,
+
+		trigger: make(chan reprovideRequest),
+
+		stats: newStatsTracker(interval),
+	}
+}
+
+// Run starts announcing queued keys and reproviding.
+func (s *System) Run() {
+	for i := 0; i < provideWorkers; i++ {
+		s.wg.Add(1)
+		go s.provideWorker()
+	}
+	s.wg.Add(1)
+	go s.reprovideLoop()
+}
+
+// Close stops the system and waits for it to finish.
+func (s *System) Close() error {
+	s.cancel()
+	err := s.queue.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Provide queues c to be announced with the normal priority.
+func (s *System) Provide(c cid.Cid) error {
+	return s.queue.Enqueue(c, PriorityNormal)
+}
+
+// ProvideWithPriority queues c to be announced with priority p.
+func (s *System) ProvideWithPriority(c cid.Cid, p Priority) error {
+	return s.queue.Enqueue(c, p)
+}
+
+// reprovideRequest triggers a reprovide of the given keys, or of the keys of
+// the configured strategy when nil.
+type reprovideRequest struct {
+	keys simple.KeyChanFunc
+	done chan<- error
+}
+
+// Reprovide triggers a reprovide and waits for it to finish. It returns an
+// error if a reprovide is already in progress.
+func (s *System) Reprovide(ctx context.Context) error {
+	return s.ReprovideKeys(ctx, nil)
+}
+
+// ReprovideKeys triggers a reprovide of the keys given by keyProvider instead
+// of those of the configured strategy, and waits for it to finish.
+func (s *System) ReprovideKeys(ctx context.Context, keyProvider simple.KeyChanFunc) error {
+	if s.stats.isReproviding() {
+		return fmt.Errorf("reprovider is already running")
+	}
+
+	resultCh := make(chan error, 1)
+	select {
+	case s.trigger <- reprovideRequest{keys: keyProvider, done: resultCh}:
+	case <-s.ctx.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-resultCh:
+		return err
+	case <-s.ctx.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stat returns statistics about the provider system.
+func (s *System) Stat(ctx context.Context) (*Stats, error) {
+	st := s.stats.snapshot(time.Now())
+	st.QueueLength = s.queue.Len()
+	st.QueueLengths = s.queue.Lengths()
+	return st, nil
+}
+
+// Pending returns up to limit keys waiting to be announced, from the highest
+// priority to the lowest, or all of them if limit is 0.
+func (s *System) Pending(ctx context.Context, limit int) ([]QueueEntry, error) {
+	return s.queue.Pending(ctx, limit)
+}
+
+func (s *System) provideWorker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case c, ok := <-s.queue.Dequeue():
+			if !ok {
+				return
+			}
+			err := s.rsys.Provide(s.ctx, c, true)
+			if err != nil {
+				log.Warnf("unable to provide %s: %s", c, err)
+			}
+			s.stats.provided(time.Now(), err == nil)
+		}
+	}
+}
+
+func (s *System) reprovideLoop() {
+	defer s.wg.Done()
+
+	var initialReprovideCh, reprovideCh <-chan time.Time
+	if s.interval > 0 {
+		reprovideTicker := time.NewTicker(s.interval)
+		defer reprovideTicker.Stop()
+		reprovideCh = reprovideTicker.C
+		next := time.Now().Add(s.interval)
+
+		if s.interval > initialReprovideDelay {
+			initialReprovideTimer := time.NewTimer(initialReprovideDelay)
+			defer initialReprovideTimer.Stop()
+			initialReprovideCh = initialReprovideTimer.C
+			next = time.Now().Add(initialReprovideDelay)
+		}
+		s.stats.scheduled(next)
+	}
+
+	for {
+		req := reprovideRequest{keys: s.keyProvider}
+		select {
+		case <-initialReprovideCh:
+		case <-reprovideCh:
+		case req = <-s.trigger:
+			if req.keys == nil {
+				req.keys = s.keyProvider
+			}
+		case <-s.ctx.Done():
+			return
+		}
+
+		err := s.reprovide(s.ctx, req.keys)
+		if s.ctx.Err() != nil {
+			err = ErrClosed
+		} else if err != nil {
+			log.Errorf("failed to reprovide: %s", err)
+		}
+		if s.interval > 0 {
+			s.stats.scheduled(time.Now().Add(s.interval))
+		}
+
+		if req.done != nil {
+			if err != nil {
+				req.done <- err
+			}
+			close(req.done)
+		}
+	}
+}
+
+// reprovide announces all the keys given by keyProvider, recording progress
+// as it goes. Keys that still fail after reprovideAttempts are counted and
+// skipped.
+func (s *System) reprovide(ctx context.Context, keyProvider simple.KeyChanFunc) (err error) {
+	s.stats.reprovideStarted(time.Now())
+	defer func() {
+		s.stats.reprovideEnded(time.Now(), err)
+	}()
+
+	keyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	keys, err := keyProvider(keyCtx)
+	if err != nil {
+		return fmt.Errorf("failed to get key chan: %s", err)
+	}
+
+	for c := range keys {
+		if err := verifcid.ValidateCid(c); err != nil {
+			log.Errorf("insecure hash in reprovider, %s (%s)", c, err)
+			continue
+		}
+
+		var perr error
+		for attempt := 0; attempt < reprovideAttempts; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-time.After(time.Duration(attempt) * reprovideRetryDelay):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if perr = s.rsys.Provide(ctx, c, true); perr == nil {
+				break
+			}
+			log.Debugf("failed to provide key: %s", perr)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.stats.reprovided(time.Now(), perr == nil)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// mockRouting records provided keys and fails for keys in fail.
+type mockRouting struct {
+	lk       sync.Mutex
+	provided map[cid.Cid]int
+	fail     map[cid.Cid]bool
+}
+
+func (r *mockRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if r.fail[c] {
+		return errors.New("provide failed")
+	}
+	r.provided[c]++
+	return nil
+}
+
+func (r *mockRouting) FindProvidersAsync(context.Context, cid.Cid, int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	close(ch)
+	return ch
+}
+
+func (r *mockRouting) count() int {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return len(r.provided)
+}
+
+func testCids(t *testing.T, n int) []cid.Cid {
+	var cids []cid.Cid
+	for i := 0; i < n; i++ {
+		h, err := mh.Sum([]byte{byte(i)}, mh.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, cid.NewCidV1(cid.Raw, h))
+	}
+	return cids
+}
+
+func sliceKeyProvider(cids []cid.Cid) func(context.Context) (<-chan cid.Cid, error) {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		ch := make(chan cid.Cid)
+		go func() {
+			defer close(ch)
+			for _, c := range cids {
+				select {
+				case ch <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	}
+}
+
+func newTestSystem(t *testing.T, rsys *mockRouting, keys []cid.Cid) *System {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	queue, err := NewQueue(ctx, "test", ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys := New(ctx, queue, rsys, sliceKeyProvider(keys), 0)
+	t.Cleanup(func() { sys.Close() })
+	return sys
+}
+
+func TestSystemProvideStats(t *testing.T) {
+	cids := testCids(t, 10)
+	rsys := &mockRouting{provided: make(map[cid.Cid]int)}
+	sys := newTestSystem(t, rsys, nil)
+
+	for _, c := range cids {
+		if err := sys.Provide(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, err := sys.Stat(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.QueueLength == len(cids) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued keys, got %d", len(cids), st.QueueLength)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sys.Run()
+	deadline = time.Now().Add(5 * time.Second)
+	var st *Stats
+	for {
+		var err error
+		st, err = sys.Stat(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.QueueLength == 0 && st.Provides == uint64(len(cids)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d keys were provided", rsys.count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if rsys.count() != len(cids) || st.ProvidesLastInterval != uint64(len(cids)) {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if st.LastReprovide != nil || st.ReprovideInterval != 0 {
+		t.Fatalf("expected no reprovide, got %+v", st)
+	}
+}
+
+func TestSystemReprovideStats(t *testing.T) {
+	cids := testCids(t, 5)
+	rsys := &mockRouting{
+		provided: make(map[cid.Cid]int),
+		fail:     map[cid.Cid]bool{cids[2]: true},
+	}
+	sys := newTestSystem(t, rsys, cids)
+	reprovideRetryDelay = time.Millisecond
+	sys.Run()
+
+	if err := sys.Reprovide(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := sys.Stat(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := st.LastReprovide
+	if st.Reproviding || r == nil || r.End.Before(r.Start) || r.Error != "" {
+		t.Fatalf("expected a finished reprovide, got %+v", st)
+	}
+	if r.Provides != 4 || r.Failures != 1 || st.Failures != 1 {
+		t.Fatalf("expected 4 provides and 1 failure, got %+v", r)
+	}
+}
+
+func TestStatsWindow(t *testing.T) {
+	st := newStatsTracker(time.Hour)
+	now := time.Now()
+	st.provided(now, true)
+	st.provided(now.Add(30*time.Minute), true)
+
+	if n := st.snapshot(now.Add(45 * time.Minute)).ProvidesLastInterval; n != 2 {
+		t.Fatalf("expected 2 provides in the window, got %d", n)
+	}
+	if n := st.snapshot(now.Add(80 * time.Minute)).ProvidesLastInterval; n != 1 {
+		t.Fatalf("expected 1 provide in the window, got %d", n)
+	}
+	if n := st.snapshot(now.Add(10 * time.Hour)).ProvidesLastInterval; n != 0 {
+		t.Fatalf("expected the window to be empty, got %d", n)
+	}
+}
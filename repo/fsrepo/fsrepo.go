@@ -176,7 +176,7 @@ func open(repoPath string) (repo.Repo, error) {
 	}
 
 	if r.config.Experimental.FilestoreEnabled || r.config.Experimental.UrlstoreEnabled {
-		r.filemgr = filestore.NewFileManager(r.ds, filepath.Dir(r.path))
+		r.filemgr = filestore.NewFileManager(r.ds, filestoreRoot(r.path))
 		r.filemgr.AllowFiles = r.config.Experimental.FilestoreEnabled
 		r.filemgr.AllowUrls = r.config.Experimental.UrlstoreEnabled
 	}
@@ -185,6 +185,20 @@ func open(repoPath string) (repo.Repo, error) {
 	return r, nil
 }
 
+// FilestoreRoot returns the directory the paths of the filestore of the repo
+// at repoPath are relative to.
+func FilestoreRoot(repoPath string) (string, error) {
+	expPath, err := homedir.Expand(filepath.Clean(repoPath))
+	if err != nil {
+		return "", err
+	}
+	return filestoreRoot(expPath), nil
+}
+
+func filestoreRoot(expPath string) string {
+	return filepath.Dir(expPath)
+}
+
 func newFSRepo(rpath string) (*FSRepo, error) {
 	expPath, err := homedir.Expand(filepath.Clean(rpath))
 	if err != nil {
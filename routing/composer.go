@@ -0,0 +1,59 @@
+package routing
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+)
+
+// Composer dispatches every routing method to the router composed for it,
+// so that e.g. peers can be found on the DHT while providers are looked up
+// in a delegated router.
+type Composer struct {
+	FindProvidersRouter routing.ContentRouting
+	ProvideRouter       routing.ContentRouting
+	FindPeersRouter     routing.PeerRouting
+	GetIPNSRouter       routing.ValueStore
+	PutIPNSRouter       routing.ValueStore
+
+	// Bootstrapper bootstraps all the routers
+	Bootstrapper routinghelpers.Bootstrap
+}
+
+var _ routing.Routing = (*Composer)(nil)
+
+func (c *Composer) FindProvidersAsync(ctx context.Context, k cid.Cid, count int) <-chan peer.AddrInfo {
+	return c.FindProvidersRouter.FindProvidersAsync(ctx, k, count)
+}
+
+func (c *Composer) Provide(ctx context.Context, k cid.Cid, announce bool) error {
+	return c.ProvideRouter.Provide(ctx, k, announce)
+}
+
+func (c *Composer) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	return c.FindPeersRouter.FindPeer(ctx, p)
+}
+
+func (c *Composer) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	return c.GetIPNSRouter.GetValue(ctx, key, opts...)
+}
+
+func (c *Composer) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	return c.GetIPNSRouter.SearchValue(ctx, key, opts...)
+}
+
+func (c *Composer) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	return c.PutIPNSRouter.PutValue(ctx, key, val, opts...)
+}
+
+func (c *Composer) GetPublicKey(ctx context.Context, p peer.ID) (ci.PubKey, error) {
+	return routing.GetPublicKey(c.GetIPNSRouter, ctx, p)
+}
+
+func (c *Composer) Bootstrap(ctx context.Context) error {
+	return c.Bootstrapper.Bootstrap(ctx)
+}
@@ -0,0 +1,294 @@
+// Package routing contains routers that can be used next to the DHT.
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var log = logging.Logger("routing/http")
+
+const (
+	providersPath = "/routing/v1/providers"
+	ipnsPath      = "/routing/v1/ipns/"
+
+	ipnsRecordContentType = "application/vnd.ipfs.ipns-record"
+
+	// maxIPNSRecordSize bounds the size of records accepted from the
+	// endpoint, like the DHT does.
+	maxIPNSRecordSize = 10 << 10
+
+	// maxProvidersResponseSize bounds the size of provider lookup
+	// responses.
+	maxProvidersResponseSize = 4 << 20
+)
+
+// ProviderRecord is an entry of a provider lookup response.
+type ProviderRecord struct {
+	ID    string
+	Addrs []string `json:",omitempty"`
+}
+
+// FindProvidersResponse is returned by GET /routing/v1/providers/{cid}.
+type FindProvidersResponse struct {
+	Providers []ProviderRecord
+}
+
+// ProvideRequest is the body of PUT /routing/v1/providers.
+type ProvideRequest struct {
+	Keys  []string
+	ID    string
+	Addrs []string `json:",omitempty"`
+}
+
+// HTTPRouter delegates content routing and IPNS to a remote endpoint over
+// HTTP:
+//
+//	GET  {endpoint}/routing/v1/providers/{cid}  find providers
+//	PUT  {endpoint}/routing/v1/providers        announce provider records
+//	GET  {endpoint}/routing/v1/ipns/{name}      get a signed IPNS record
+//	PUT  {endpoint}/routing/v1/ipns/{name}      publish a signed IPNS record
+//
+// Peer routing is not supported.
+type HTTPRouter struct {
+	endpoint string
+	client   *http.Client
+
+	// self returns the provider record announced by Provide
+	self func() peer.AddrInfo
+}
+
+var _ routing.Routing = (*HTTPRouter)(nil)
+
+// NewHTTPRouter creates a router for the given endpoint. Requests taking
+// longer than timeout are aborted, unless timeout is 0. self is called on
+// every Provide to get the addresses to announce.
+func NewHTTPRouter(endpoint string, timeout time.Duration, self func() peer.AddrInfo) (*HTTPRouter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delegated routing endpoint %q: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid delegated routing endpoint %q: scheme must be http or https", endpoint)
+	}
+	return &HTTPRouter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: timeout},
+		self:     self,
+	}, nil
+}
+
+func (r *HTTPRouter) String() string {
+	return r.endpoint
+}
+
+func (r *HTTPRouter) do(ctx context.Context, method, path, contentType string, body []byte, accept string) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.endpoint+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", accept)
+	return r.client.Do(req)
+}
+
+// statusError turns an unexpected response into an error, including the
+// start of the body which usually explains what went wrong.
+func statusError(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("delegated routing request failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func (r *HTTPRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		provs, err := r.findProviders(ctx, c)
+		if err != nil {
+			log.Debugw("find providers failed", "endpoint", r.endpoint, "cid", c, "error", err)
+			return
+		}
+		for i, p := range provs {
+			if count > 0 && i >= count {
+				return
+			}
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (r *HTTPRouter) findProviders(ctx context.Context, c cid.Cid) ([]peer.AddrInfo, error) {
+	resp, err := r.do(ctx, http.MethodGet, providersPath+"/"+c.String(), "", nil, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, statusError(resp)
+	}
+
+	var res FindProvidersResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxProvidersResponseSize)).Decode(&res); err != nil {
+		return nil, err
+	}
+
+	provs := make([]peer.AddrInfo, 0, len(res.Providers))
+	for _, p := range res.Providers {
+		id, err := peer.Decode(p.ID)
+		if err != nil {
+			log.Debugw("skipping provider with invalid peer ID", "endpoint", r.endpoint, "id", p.ID)
+			continue
+		}
+		ai := peer.AddrInfo{ID: id}
+		for _, s := range p.Addrs {
+			a, err := ma.NewMultiaddr(s)
+			if err != nil {
+				continue
+			}
+			ai.Addrs = append(ai.Addrs, a)
+		}
+		provs = append(provs, ai)
+	}
+	return provs, nil
+}
+
+// Provide announces the node as a provider of c. Nothing is sent when
+// announce is false, as the endpoint keeps no local state.
+func (r *HTTPRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if !announce {
+		return nil
+	}
+
+	self := r.self()
+	req := ProvideRequest{
+		Keys: []string{c.String()},
+		ID:   self.ID.String(),
+	}
+	for _, a := range self.Addrs {
+		req.Addrs = append(req.Addrs, a.String())
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.do(ctx, http.MethodPut, providersPath, "application/json", body, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError(resp)
+	}
+	return nil
+}
+
+func (r *HTTPRouter) FindPeer(context.Context, peer.ID) (peer.AddrInfo, error) {
+	return peer.AddrInfo{}, routing.ErrNotSupported
+}
+
+// ipnsName returns the name used in IPNS URLs for a /ipns/ routing key.
+func ipnsName(key string) (string, error) {
+	if !strings.HasPrefix(key, "/ipns/") {
+		return "", routing.ErrNotSupported
+	}
+	pid, err := peer.IDFromBytes([]byte(key[len("/ipns/"):]))
+	if err != nil {
+		return "", err
+	}
+	return peer.ToCid(pid).String(), nil
+}
+
+func (r *HTTPRouter) PutValue(ctx context.Context, key string, val []byte, _ ...routing.Option) error {
+	name, err := ipnsName(key)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.do(ctx, http.MethodPut, ipnsPath+name, ipnsRecordContentType, val, "*/*")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError(resp)
+	}
+	return nil
+}
+
+func (r *HTTPRouter) GetValue(ctx context.Context, key string, _ ...routing.Option) ([]byte, error) {
+	name, err := ipnsName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.do(ctx, http.MethodGet, ipnsPath+name, "", nil, ipnsRecordContentType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, routing.ErrNotFound
+	default:
+		return nil, statusError(resp)
+	}
+
+	val, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxIPNSRecordSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(val) > maxIPNSRecordSize {
+		return nil, fmt.Errorf("IPNS record from %s exceeds %d bytes", r.endpoint, maxIPNSRecordSize)
+	}
+	return val, nil
+}
+
+// SearchValue returns the single record held by the endpoint. Records are
+// validated by the router composing this one.
+func (r *HTTPRouter) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	val, err := r.GetValue(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []byte, 1)
+	out <- val
+	close(out)
+	return out, nil
+}
+
+func (r *HTTPRouter) Bootstrap(context.Context) error {
+	return nil
+}
@@ -0,0 +1,196 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	"github.com/libp2p/go-libp2p-core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// testEndpoint is an in-memory stand-in for a delegated routing endpoint.
+type testEndpoint struct {
+	lk        sync.Mutex
+	providers map[string][]ProviderRecord
+	records   map[string][]byte
+}
+
+func newTestEndpoint(t *testing.T) (*testEndpoint, *httptest.Server) {
+	e := &testEndpoint{
+		providers: make(map[string][]ProviderRecord),
+		records:   make(map[string][]byte),
+	}
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return e, srv
+}
+
+func (e *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == providersPath:
+		var req ProvideRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, k := range req.Keys {
+			e.providers[k] = append(e.providers[k], ProviderRecord{ID: req.ID, Addrs: req.Addrs})
+		}
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, providersPath+"/"):
+		provs, ok := e.providers[strings.TrimPrefix(r.URL.Path, providersPath+"/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(FindProvidersResponse{Providers: provs})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, ipnsPath):
+		if r.Header.Get("Content-Type") != ipnsRecordContentType {
+			http.Error(w, "unexpected content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		val, _ := ioutil.ReadAll(r.Body)
+		e.records[strings.TrimPrefix(r.URL.Path, ipnsPath)] = val
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, ipnsPath):
+		val, ok := e.records[strings.TrimPrefix(r.URL.Path, ipnsPath)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", ipnsRecordContentType)
+		w.Write(val)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	h, err := mh.Sum([]byte(data), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+func TestHTTPRouterProviders(t *testing.T) {
+	ctx := context.Background()
+	_, srv := newTestEndpoint(t)
+
+	self, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ma.StringCast("/ip4/127.0.0.1/tcp/4001")
+	r, err := NewHTTPRouter(srv.URL+"/", time.Second, func() peer.AddrInfo {
+		return peer.AddrInfo{ID: self, Addrs: []ma.Multiaddr{addr}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testCid(t, "provided")
+	var found []peer.AddrInfo
+	for ai := range r.FindProvidersAsync(ctx, c, 0) {
+		found = append(found, ai)
+	}
+	if len(found) != 0 {
+		t.Fatalf("expected no providers before providing, got %v", found)
+	}
+
+	if err := r.Provide(ctx, c, true); err != nil {
+		t.Fatal(err)
+	}
+	for ai := range r.FindProvidersAsync(ctx, c, 0) {
+		found = append(found, ai)
+	}
+	if len(found) != 1 || found[0].ID != self || len(found[0].Addrs) != 1 || !found[0].Addrs[0].Equal(addr) {
+		t.Fatalf("unexpected providers: %v", found)
+	}
+
+	if _, err := r.FindPeer(ctx, self); err != routing.ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestHTTPRouterIPNS(t *testing.T) {
+	ctx := context.Background()
+	_, srv := newTestEndpoint(t)
+
+	r, err := NewHTTPRouter(srv.URL, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "/ipns/" + string(pid)
+
+	if _, err := r.GetValue(ctx, key); err != routing.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := r.PutValue(ctx, key, []byte("record")); err != nil {
+		t.Fatal(err)
+	}
+	val, err := r.GetValue(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(val, []byte("record")) {
+		t.Fatalf("unexpected record %q", val)
+	}
+
+	if err := r.PutValue(ctx, "/pk/"+string(pid), []byte("key")); err != routing.ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported for non-IPNS keys, got %v", err)
+	}
+}
+
+func TestHTTPRouterTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	r, err := NewHTTPRouter(srv.URL, 50*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := r.GetValue(context.Background(), "/ipns/"+string(pid)); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("timeout was not applied")
+	}
+}
+
+func TestNewHTTPRouterInvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"", "ftp://example.com", "://nope"} {
+		if _, err := NewHTTPRouter(endpoint, 0, nil); err == nil {
+			t.Errorf("expected %q to be rejected", endpoint)
+		}
+	}
+}
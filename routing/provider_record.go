@@ -0,0 +1,40 @@
+package routing
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/binary"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+// dhtKeyEncoding is the encoding of the provider store keys of the DHT.
+var dhtKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ProviderRecordAge returns the age of the provider record of p for c kept by
+// the DHT provider store in dstore. ok is false when the DHT holds no such
+// record, e.g. when it was received by another DHT server.
+func ProviderRecordAge(ctx context.Context, dstore ds.Datastore, c cid.Cid, p peer.ID) (age time.Duration, ok bool, err error) {
+	key := ds.NewKey(providers.ProvidersKeyPrefix +
+		dhtKeyEncoding.EncodeToString(c.Hash()) + "/" +
+		dhtKeyEncoding.EncodeToString([]byte(p)))
+
+	val, err := dstore.Get(ctx, key)
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return 0, false, nil
+	default:
+		return 0, false, err
+	}
+
+	nsec, n := binary.Varint(val)
+	if n <= 0 {
+		return 0, false, nil
+	}
+	return time.Since(time.Unix(0, nsec)), true, nil
+}
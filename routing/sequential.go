@@ -0,0 +1,60 @@
+package routing
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+)
+
+// Sequential is a routinghelpers.Tiered that also looks up providers one
+// tier at a time: the next tier is only queried when the previous ones found
+// no provider. Routers that should be queried at the same time go in a
+// routinghelpers.Parallel tier.
+type Sequential struct {
+	routinghelpers.Tiered
+}
+
+func (r Sequential) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+
+		found := make(map[peer.ID]struct{})
+		for _, tier := range r.Routers {
+			if r.findFromTier(ctx, tier.FindProvidersAsync, c, count, found, out) || len(found) > 0 {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// findFromTier forwards the providers found by a single tier to out,
+// skipping duplicates. It returns true when the lookup is over, either because
+// count providers were found or because ctx is done.
+func (r Sequential) findFromTier(ctx context.Context, find func(context.Context, cid.Cid, int) <-chan peer.AddrInfo, c cid.Cid, count int, found map[peer.ID]struct{}, out chan<- peer.AddrInfo) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	remaining := 0
+	if count > 0 {
+		remaining = count - len(found)
+	}
+	for ai := range find(ctx, c, remaining) {
+		if _, ok := found[ai.ID]; ok {
+			continue
+		}
+		found[ai.ID] = struct{}{}
+		select {
+		case out <- ai:
+		case <-ctx.Done():
+			return true
+		}
+		if count > 0 && len(found) >= count {
+			return true
+		}
+	}
+	return ctx.Err() != nil
+}
@@ -0,0 +1,80 @@
+package routing
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	"github.com/libp2p/go-libp2p-core/test"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+)
+
+// staticProviders always finds the same providers and records whether it was
+// queried.
+type staticProviders struct {
+	routinghelpers.Null
+	providers []peer.ID
+	queried   bool
+}
+
+func (s *staticProviders) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	s.queried = true
+	out := make(chan peer.AddrInfo, len(s.providers))
+	for _, p := range s.providers {
+		out <- peer.AddrInfo{ID: p}
+	}
+	close(out)
+	return out
+}
+
+func TestSequentialFindProviders(t *testing.T) {
+	ctx := context.Background()
+	c := testCid(t, "sequential")
+
+	var ids []peer.ID
+	for i := 0; i < 3; i++ {
+		id, err := test.RandPeerID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	find := func(tiers ...routing.Routing) []peer.ID {
+		var found []peer.ID
+		r := Sequential{Tiered: routinghelpers.Tiered{Routers: tiers}}
+		for ai := range r.FindProvidersAsync(ctx, c, 0) {
+			found = append(found, ai.ID)
+		}
+		return found
+	}
+
+	empty := &staticProviders{}
+	first := &staticProviders{providers: ids[:2]}
+	second := &staticProviders{providers: ids[2:]}
+	if found := find(empty, first, second); len(found) != 2 || second.queried {
+		t.Fatalf("expected only the first tier with providers to be used, got %v", found)
+	}
+	if !empty.queried {
+		t.Fatal("expected the empty tier to be queried")
+	}
+
+	parallel := routinghelpers.Parallel{Routers: []routing.Routing{
+		&staticProviders{providers: ids[:2]},
+		&staticProviders{providers: ids[1:]},
+	}}
+	if found := find(parallel); len(found) != 3 {
+		t.Fatalf("expected the providers of a parallel tier to be merged, got %v", found)
+	}
+
+	var limited []peer.ID
+	r := Sequential{Tiered: routinghelpers.Tiered{Routers: []routing.Routing{&staticProviders{providers: ids}}}}
+	for ai := range r.FindProvidersAsync(ctx, c, 1) {
+		limited = append(limited, ai.ID)
+	}
+	if len(limited) != 1 {
+		t.Fatalf("expected count to be honored, got %v", limited)
+	}
+}
@@ -0,0 +1,148 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+// TraceEvent is a result of one of the routers composed in the node's
+// routing, reported to the TraceFunc of the context of the query.
+type TraceEvent struct {
+	// Router is the name of the router.
+	Router string
+	// Latency is the time from the start of the query to this result.
+	Latency time.Duration
+
+	// Provider is set for each provider found.
+	Provider *peer.AddrInfo
+	// Peer is set when a peer was found.
+	Peer *peer.AddrInfo
+	// Value is set when a value was found.
+	Value []byte
+	// Done is set when a Provide, PutValue, FindPeer or GetValue call
+	// returned, with Err its error.
+	Done bool
+	Err  error
+}
+
+// TraceFunc receives trace events. It may be called concurrently.
+type TraceFunc func(TraceEvent)
+
+type traceKey struct{}
+
+// WithTrace returns a context reporting the results of each traced router
+// queried with it to fn.
+func WithTrace(ctx context.Context, fn TraceFunc) context.Context {
+	return context.WithValue(ctx, traceKey{}, fn)
+}
+
+func traceFrom(ctx context.Context) TraceFunc {
+	fn, _ := ctx.Value(traceKey{}).(TraceFunc)
+	return fn
+}
+
+// Traced wraps r to report its results under name to the TraceFunc of the
+// queries' contexts, see WithTrace.
+func Traced(name string, r routing.Routing) routing.Routing {
+	return &traced{Routing: r, name: name}
+}
+
+type traced struct {
+	routing.Routing
+	name string
+}
+
+// report sends ev to the context's TraceFunc, if any. Routers not supporting
+// a method are not reported.
+func (t *traced) report(ctx context.Context, start time.Time, ev TraceEvent) {
+	fn := traceFrom(ctx)
+	if fn == nil || errors.Is(ev.Err, routing.ErrNotSupported) {
+		return
+	}
+	ev.Router = t.name
+	ev.Latency = time.Since(start)
+	fn(ev)
+}
+
+func (t *traced) FindProvidersAsync(ctx context.Context, k cid.Cid, count int) <-chan peer.AddrInfo {
+	in := t.Routing.FindProvidersAsync(ctx, k, count)
+	if traceFrom(ctx) == nil {
+		return in
+	}
+
+	start := time.Now()
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		for p := range in {
+			p := p
+			t.report(ctx, start, TraceEvent{Provider: &p})
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (t *traced) Provide(ctx context.Context, k cid.Cid, announce bool) error {
+	start := time.Now()
+	err := t.Routing.Provide(ctx, k, announce)
+	t.report(ctx, start, TraceEvent{Done: true, Err: err})
+	return err
+}
+
+func (t *traced) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	start := time.Now()
+	pi, err := t.Routing.FindPeer(ctx, p)
+	ev := TraceEvent{Done: true, Err: err}
+	if err == nil {
+		ev.Peer = &pi
+	}
+	t.report(ctx, start, ev)
+	return pi, err
+}
+
+func (t *traced) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	start := time.Now()
+	val, err := t.Routing.GetValue(ctx, key, opts...)
+	t.report(ctx, start, TraceEvent{Value: val, Done: true, Err: err})
+	return val, err
+}
+
+func (t *traced) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	start := time.Now()
+	in, err := t.Routing.SearchValue(ctx, key, opts...)
+	if err != nil || traceFrom(ctx) == nil {
+		t.report(ctx, start, TraceEvent{Done: true, Err: err})
+		return in, err
+	}
+
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		for val := range in {
+			t.report(ctx, start, TraceEvent{Value: val})
+			select {
+			case out <- val:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (t *traced) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	start := time.Now()
+	err := t.Routing.PutValue(ctx, key, val, opts...)
+	t.report(ctx, start, TraceEvent{Done: true, Err: err})
+	return err
+}
@@ -0,0 +1,111 @@
+package routing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	pstoremem "github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+)
+
+func TestTraced(t *testing.T) {
+	ctx := context.Background()
+	c := testCid(t, "traced")
+
+	id, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Sequential{Tiered: routinghelpers.Tiered{Routers: []routing.Routing{
+		Traced("empty", &staticProviders{}),
+		Traced("static", &staticProviders{providers: []peer.ID{id}}),
+	}}}
+
+	var lk sync.Mutex
+	var events []TraceEvent
+	tctx := WithTrace(ctx, func(ev TraceEvent) {
+		lk.Lock()
+		defer lk.Unlock()
+		events = append(events, ev)
+	})
+	for range r.FindProvidersAsync(tctx, c, 0) {
+	}
+	if len(events) != 1 || events[0].Router != "static" || events[0].Provider == nil || events[0].Provider.ID != id {
+		t.Fatalf("expected the provider to be reported by the static router, got %v", events)
+	}
+
+	events = nil
+	if _, err := r.FindPeer(tctx, id); err != routing.ErrNotFound {
+		t.Fatalf("expected the peer not to be found, got %v", err)
+	}
+	if len(events) != 2 || !events[0].Done || events[0].Err != routing.ErrNotFound {
+		t.Fatalf("expected both routers to report their lookup, got %v", events)
+	}
+
+	events = nil
+	_ = r.Provide(tctx, c, true)
+	if len(events) != 0 {
+		t.Fatalf("expected routers not supporting provides not to be reported, got %v", events)
+	}
+
+	events = nil
+	for range r.FindProvidersAsync(ctx, c, 0) {
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected untraced queries not to be reported, got %v", events)
+	}
+}
+
+func TestProviderRecordAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := testCid(t, "record")
+
+	self, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prov, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	pm, err := providers.NewProviderManager(ctx, self, ps, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Process().Close()
+
+	if _, ok, err := ProviderRecordAge(ctx, dstore, c, prov); err != nil || ok {
+		t.Fatalf("expected no record, got ok=%t err=%v", ok, err)
+	}
+
+	if err := pm.AddProvider(ctx, c.Hash(), peer.AddrInfo{ID: prov}); err != nil {
+		t.Fatal(err)
+	}
+	// AddProvider is processed asynchronously, GetProviders waits for it
+	if _, err := pm.GetProviders(ctx, c.Hash()); err != nil {
+		t.Fatal(err)
+	}
+
+	age, ok, err := ProviderRecordAge(ctx, dstore, c, prov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || age < 0 || age > time.Minute {
+		t.Fatalf("expected a fresh record, got ok=%t age=%s", ok, age)
+	}
+}
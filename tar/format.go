@@ -44,6 +44,10 @@ func ImportTar(ctx context.Context, r io.Reader, ds ipld.DAGService) (*dag.Proto
 
 	e := dagutils.NewDagEditor(root, ds)
 
+	// the records of the global headers, which apply to the entries after
+	// them
+	var global map[string]string
+
 	for {
 		h, err := tr.Next()
 		if err != nil {
@@ -53,6 +57,12 @@ func ImportTar(ctx context.Context, r io.Reader, ds ipld.DAGService) (*dag.Proto
 			return nil, err
 		}
 
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			global = mergeGlobalRecords(global, h.PAXRecords)
+			continue
+		}
+		applyGlobalRecords(h, global)
+
 		header := new(dag.ProtoNode)
 
 		headerBytes, err := marshalHeader(h)
@@ -90,6 +100,51 @@ func ImportTar(ctx context.Context, r io.Reader, ds ipld.DAGService) (*dag.Proto
 	return e.Finalize(ctx, ds)
 }
 
+// headerRecords are the PAX records of the fields of tar.Header, which are
+// not kept from the global headers.
+var headerRecords = map[string]bool{
+	"path": true, "linkpath": true, "size": true,
+	"uid": true, "gid": true, "uname": true, "gname": true,
+	"mtime": true, "atime": true, "ctime": true,
+}
+
+func mergeGlobalRecords(global, records map[string]string) map[string]string {
+	merged := make(map[string]string, len(global)+len(records))
+	for k, v := range global {
+		merged[k] = v
+	}
+	for k, v := range records {
+		if headerRecords[k] {
+			continue
+		}
+		// an empty value deletes the record
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// applyGlobalRecords adds the records of the global headers to h, whose own
+// records take precedence, as the global headers are not kept in the archive.
+func applyGlobalRecords(h *tar.Header, global map[string]string) {
+	if len(global) == 0 {
+		return
+	}
+	records := make(map[string]string, len(global)+len(h.PAXRecords))
+	for k, v := range global {
+		records[k] = v
+	}
+	for k, v := range h.PAXRecords {
+		records[k] = v
+	}
+	h.PAXRecords = records
+	// the records may not fit the format the entry was read in
+	h.Format = tar.FormatPAX
+}
+
 // adds a '-' to the beginning of each path element so we can use 'data' as a
 // special link in the structure without having to worry about
 func escapePath(pth string) string {
@@ -0,0 +1,196 @@
+package tarfmt
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core/coreunix/metadata"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	ft "github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+)
+
+type tarEntry struct {
+	hdr  *tar.Header
+	data string
+}
+
+func writeTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		e.hdr.Size = int64(len(e.data))
+		if err := tw.WriteHeader(e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]*tar.Header {
+	t.Helper()
+	hdrs := make(map[string]*tar.Header)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return hdrs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdrs[h.Name] = h
+	}
+}
+
+var mtime = time.Unix(1600000000, 0)
+
+func TestImportTarPAX(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock()
+
+	data := writeTar(t, []tarEntry{
+		{hdr: &tar.Header{
+			Typeflag:   tar.TypeXGlobalHeader,
+			Name:       "pax_global_header",
+			PAXRecords: map[string]string{"comment": "v1.0", "IPFS.test": "global"},
+		}},
+		{hdr: &tar.Header{Typeflag: tar.TypeDir, Name: "a/", Mode: 0755, ModTime: mtime}},
+		{hdr: &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       "a/file",
+			Mode:       0644,
+			ModTime:    mtime,
+			PAXRecords: map[string]string{"SCHILY.xattr.user.key": "value", "IPFS.test": "entry"},
+		}, data: "content"},
+	})
+
+	root, err := ImportTar(ctx, bytes.NewReader(data), ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ExportTar(ctx, root, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdrs := readTar(t, r)
+
+	if len(hdrs) != 2 {
+		t.Fatalf("expected the global header not to be an entry, got %d entries", len(hdrs))
+	}
+	dir := hdrs["a/"]
+	if dir == nil || dir.PAXRecords["comment"] != "v1.0" || dir.PAXRecords["IPFS.test"] != "global" {
+		t.Fatalf("expected the global records in the directory, got %+v", dir)
+	}
+	file := hdrs["a/file"]
+	if file == nil {
+		t.Fatal("the file was not exported")
+	}
+	if file.PAXRecords["comment"] != "v1.0" || file.PAXRecords["IPFS.test"] != "entry" {
+		t.Fatalf("expected the records of the file to override the global ones, got %v", file.PAXRecords)
+	}
+	if file.Xattrs["user.key"] != "value" {
+		t.Fatalf("expected the xattrs of the file to be kept, got %v", file.Xattrs)
+	}
+}
+
+func TestImportTarUnixFS(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock()
+
+	data := writeTar(t, []tarEntry{
+		{hdr: &tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0700, ModTime: mtime}},
+		{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "a/file", Mode: 0640, ModTime: mtime}, data: "content"},
+		{hdr: &tar.Header{Typeflag: tar.TypeDir, Name: "a/", Mode: 0750, ModTime: mtime}},
+		{hdr: &tar.Header{Typeflag: tar.TypeSymlink, Name: "a/link", Linkname: "file", ModTime: mtime}},
+		{hdr: &tar.Header{Typeflag: tar.TypeLink, Name: "hard", Linkname: "a/file"}},
+		{hdr: &tar.Header{Typeflag: tar.TypeFifo, Name: "fifo"}},
+	})
+
+	root, err := ImportTarUnixFS(ctx, bytes.NewReader(data), ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkMetadata := func(nd ipld.Node, mode os.FileMode) {
+		t.Helper()
+		md, err := metadata.Read(nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.HasMode != (mode != 0) || md.Mode != mode || !md.ModTime.Equal(mtime) {
+			t.Fatalf("expected mode %s and mtime %s, got %+v", mode, mtime, md)
+		}
+	}
+	child := func(nd ipld.Node, name string) ipld.Node {
+		t.Helper()
+		dir, err := uio.NewDirectoryFromNode(ds, nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := dir.Find(ctx, name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		return c
+	}
+
+	checkMetadata(root, 0700)
+	dir, err := uio.NewDirectoryFromNode(ds, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := dir.Links(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 {
+		t.Fatalf("expected the fifo to be skipped, got %d entries", len(links))
+	}
+
+	a := child(root, "a")
+	checkMetadata(a, 0750)
+
+	file := child(a, "file")
+	checkMetadata(file, 0640)
+	dr, err := uio.NewDagReader(ctx, file, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "content" {
+		t.Fatalf("unexpected content %q", content)
+	}
+
+	link := child(a, "link")
+	checkMetadata(link, 0)
+	fsn, err := ft.ExtractFSNode(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsn.Type() != ft.TSymlink || string(fsn.Data()) != "file" {
+		t.Fatalf("expected a symlink to file, got %s %q", fsn.Type(), fsn.Data())
+	}
+
+	if hard := child(root, "hard"); !hard.Cid().Equals(file.Cid()) {
+		t.Fatal("expected the hard link to be the file")
+	}
+}
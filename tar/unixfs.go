@@ -0,0 +1,157 @@
+package tarfmt
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	gopath "path"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/core/coreunix/metadata"
+
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	importer "github.com/ipfs/go-unixfs/importer"
+	uio "github.com/ipfs/go-unixfs/io"
+)
+
+// unixfsEntry is an entry of the tree of a tar file imported as UnixFS.
+type unixfsEntry struct {
+	nd       ipld.Node // nil for directories
+	md       metadata.FileMetadata
+	children map[string]*unixfsEntry
+}
+
+func newUnixfsDir() *unixfsEntry {
+	return &unixfsEntry{children: make(map[string]*unixfsEntry)}
+}
+
+// mkdir returns the directory at pth, creating it and its parents, and
+// replacing the files in the way, as extracting the tar file would.
+func (e *unixfsEntry) mkdir(pth string) *unixfsEntry {
+	dir := e
+	for _, name := range strings.Split(strings.Trim(pth, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		child, ok := dir.children[name]
+		if !ok || child.children == nil {
+			child = newUnixfsDir()
+			dir.children[name] = child
+		}
+		dir = child
+	}
+	return dir
+}
+
+func (e *unixfsEntry) build(ctx context.Context, ds ipld.DAGService) (ipld.Node, error) {
+	if e.children == nil {
+		return e.nd, nil
+	}
+
+	names := make([]string, 0, len(e.children))
+	for name := range e.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dir := uio.NewDirectory(ds)
+	for _, name := range names {
+		nd, err := e.children[name].build(ctx, ds)
+		if err != nil {
+			return nil, err
+		}
+		if err := dir.AddChild(ctx, name, nd); err != nil {
+			return nil, err
+		}
+	}
+	nd, err := dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if !e.md.IsZero() {
+		if nd, err = metadata.Set(nd, e.md); err != nil {
+			return nil, err
+		}
+	}
+	return nd, ds.Add(ctx, nd)
+}
+
+// ImportTarUnixFS imports a tar file into the given DAGService as a UnixFS
+// directory, browsable like any other, and returns its root node. The files
+// are chunked the way 'ipfs add' does, and the mode and mtime of the entries
+// are kept. Devices and FIFOs are skipped.
+func ImportTarUnixFS(ctx context.Context, r io.Reader, ds ipld.DAGService) (ipld.Node, error) {
+	tr := tar.NewReader(r)
+	root := newUnixfsDir()
+	// the files imported, for the hard links
+	files := make(map[string]ipld.Node)
+
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		pth := gopath.Clean("/" + h.Name)
+		md := metadata.FileMetadata{
+			Mode:    metadata.FileModeFromPosix(uint32(h.Mode)),
+			HasMode: true,
+			ModTime: h.ModTime,
+		}
+
+		var nd ipld.Node
+		switch h.Typeflag {
+		case tar.TypeDir:
+			root.mkdir(pth).md = md
+			continue
+		case tar.TypeReg:
+			nd, err = importer.BuildDagFromReader(ds, chunker.DefaultSplitter(tr))
+			if err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			data, err := ft.SymlinkData(h.Linkname)
+			if err != nil {
+				return nil, err
+			}
+			nd = dag.NodeWithData(data)
+			// the mode of symlinks is meaningless
+			md.HasMode = false
+		case tar.TypeLink:
+			target, ok := files[gopath.Clean("/"+h.Linkname)]
+			if !ok {
+				return nil, fmt.Errorf("%s: hard link to %s, which is not a file before it", h.Name, h.Linkname)
+			}
+			nd = target
+		default:
+			log.Infof("skipping %s: unsupported type %q", h.Name, h.Typeflag)
+			continue
+		}
+
+		if pth == "/" {
+			return nil, fmt.Errorf("%s: the root of the archive is not a directory", h.Name)
+		}
+		if h.Typeflag != tar.TypeLink {
+			if nd, err = metadata.Set(nd, md); err != nil {
+				return nil, err
+			}
+			if err := ds.Add(ctx, nd); err != nil {
+				return nil, err
+			}
+		}
+		files[pth] = nd
+
+		dir, name := gopath.Split(pth)
+		root.mkdir(dir).children[name] = &unixfsEntry{nd: nd}
+	}
+
+	return root.build(ctx, ds)
+}
@@ -55,4 +55,31 @@ test_expect_success "'ipfs tar add --cid-base=base32' has correct hash" '
   test_cmp expected actual
 '
 
+test_expect_success "'ipfs tar add --unixfs' succeeds" '
+  UNIXFS_HASH=$(ipfs tar add --unixfs files.tar)
+'
+
+test_expect_success "the tar file is a UnixFS directory" '
+  ipfs cat $UNIXFS_HASH/foo/a > actual &&
+  test_cmp foo/a actual &&
+  ipfs files stat --format="<type> <mode>" /ipfs/$UNIXFS_HASH/foo/script > actual &&
+  grep "^file 07[57]5$" actual
+'
+
+test_expect_success "'ipfs tar cat' exports the UnixFS directory" '
+  mkdir output-unixfs &&
+  ipfs tar cat $UNIXFS_HASH > output-unixfs/out.tar &&
+  tar xf output-unixfs/out.tar -C output-unixfs/ &&
+  diff foo/a output-unixfs/foo/a &&
+  diff foo/bar/baz output-unixfs/foo/bar/baz &&
+  [ -L output-unixfs/foo/bar/link ] &&
+  [ -x output-unixfs/foo/script ]
+'
+
+test_expect_success "the exported tar file imports to the same directory" '
+  ipfs tar add --unixfs output-unixfs/out.tar > actual &&
+  echo $UNIXFS_HASH > expected &&
+  test_cmp expected actual
+'
+
 test_done
@@ -922,6 +922,33 @@ test_expect_success "add a few entries to big_dir/ to retrigger sharding" '
   test_cmp shard_exp shard_dir_hash
 '
 
+test_expect_success "files sync mirrors a local directory" '
+  mkdir -p sync_dir/sub &&
+  echo a > sync_dir/a &&
+  echo b > sync_dir/sub/b &&
+  ipfs files sync sync_dir /sync/dir > sync_out &&
+  ipfs add -r -Q sync_dir > sync_exp &&
+  test_cmp sync_exp sync_out &&
+  ipfs files stat --hash /sync/dir > sync_hash &&
+  test_cmp sync_exp sync_hash
+'
+
+test_expect_success "files sync mirrors the removed and renamed files" '
+  rm sync_dir/a &&
+  mv sync_dir/sub sync_dir/moved &&
+  ipfs files sync sync_dir /sync/dir > sync_out &&
+  ipfs add -r -Q sync_dir > sync_exp &&
+  test_cmp sync_exp sync_out &&
+  ipfs files ls /sync/dir > sync_ls &&
+  echo moved > sync_ls_exp &&
+  test_cmp sync_ls_exp sync_ls
+'
+
+test_expect_success "files sync cannot replace the root" '
+  test_must_fail ipfs files sync sync_dir / 2> sync_err &&
+  grep "cannot replace the root" sync_err
+'
+
 test_kill_ipfs_daemon
 
 test_done
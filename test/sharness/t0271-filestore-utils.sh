@@ -167,6 +167,71 @@ test_filestore_dups() {
   '
 }
 
+test_filestore_relocate() {
+  test_filestore_state
+
+  test_expect_success "move the dataset" '
+    mv somedir movedir
+  '
+
+  test_expect_success "'$IPFS_CMD filestore relocate' rewrites the paths" '
+    $IPFS_CMD filestore relocate somedir movedir > relocate_actual &&
+    sed -e "s/somedir/movedir/" verify_expect_file_order > relocate_expect &&
+    test_cmp relocate_expect relocate_actual
+  '
+
+  test_expect_success "blocks can be read from the new location" '
+    $IPFS_CMD cat $FILE3_HASH > file3.data &&
+    test_cmp movedir/file3 file3.data
+  '
+
+  test_expect_success "move the dataset back" '
+    mv movedir somedir &&
+    $IPFS_CMD filestore relocate movedir somedir
+  '
+
+  test_expect_success "'$IPFS_CMD filestore relocate' keeps the objects that do not match" '
+    mkdir otherdir &&
+    cp somedir/* otherdir &&
+    random 1000 9 > otherdir/file1 &&
+    $IPFS_CMD filestore relocate somedir otherdir > relocate_actual &&
+    grep changed relocate_actual | grep -q otherdir/file1 &&
+    $IPFS_CMD filestore ls $FILE1_HASH | grep -q somedir/file1 &&
+    $IPFS_CMD filestore relocate otherdir somedir &&
+    rm -r otherdir
+  '
+
+  test_filestore_state
+}
+
+test_filestore_gc() {
+  test_filestore_state
+
+  test_expect_success "remove a backing file" '
+    rm somedir/file1
+  '
+
+  test_expect_success "'$IPFS_CMD filestore gc --unpinned-only' keeps pinned objects" '
+    $IPFS_CMD filestore gc --unpinned-only > gc_actual &&
+    test_must_be_empty gc_actual
+  '
+
+  test_expect_success "'$IPFS_CMD filestore gc' removes the invalid objects" '
+    $IPFS_CMD filestore gc > gc_actual &&
+    echo "removed no-file $FILE1_HASH   1000 somedir/file1 0" > gc_expect &&
+    test_cmp gc_expect gc_actual &&
+    $IPFS_CMD filestore verify > verify_actual &&
+    test_must_fail grep -q somedir/file1 verify_actual
+  '
+
+  # reset the state for the next test
+  test_init_dataset
+
+  test_expect_success "add the dataset again" '
+    $IPFS_CMD add --raw-leaves --nocopy -r -Q somedir
+  '
+}
+
 #
 # No daemon
 #
@@ -179,6 +244,10 @@ test_filestore_verify
 
 test_filestore_dups
 
+test_filestore_relocate
+
+test_filestore_gc
+
 #
 # With daemon
 #
@@ -195,6 +264,10 @@ test_filestore_verify
 
 test_filestore_dups
 
+test_filestore_relocate
+
+test_filestore_gc
+
 test_kill_ipfs_daemon
 
 ##
@@ -243,6 +316,10 @@ test_filestore_verify
 
 test_filestore_dups
 
+test_filestore_relocate
+
+test_filestore_gc
+
 #
 # With daemon
 #
@@ -259,6 +336,10 @@ test_filestore_verify
 
 test_filestore_dups
 
+test_filestore_relocate
+
+test_filestore_gc
+
 test_kill_ipfs_daemon
 
 test_done
@@ -0,0 +1,117 @@
+package urlstore
+
+import (
+	"context"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	filestore "github.com/ipfs/go-filestore"
+)
+
+// Blockstore is a Filestore reading the blocks of the urlstore whose server
+// requires headers itself, with the headers of their origin.
+type Blockstore struct {
+	*filestore.Filestore
+	store *Store
+}
+
+// NewBlockstore returns a Blockstore over fs, with the origins of s.
+func NewBlockstore(fs *filestore.Filestore, s *Store) *Blockstore {
+	return &Blockstore{Filestore: fs, store: s}
+}
+
+// Get returns the block c.
+func (b *Blockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := b.Filestore.Get(ctx, c)
+	// only the URLs whose server rejected the request are read again
+	cerr, ok := err.(*filestore.CorruptReferenceError)
+	if !ok || cerr.Code != filestore.StatusFileError {
+		return blk, err
+	}
+	r := filestore.List(ctx, b.Filestore, c)
+	if r.Status != filestore.StatusOk || !filestore.IsURL(r.FilePath) {
+		return nil, err
+	}
+	o, oerr := b.store.Get(ctx, r.FilePath)
+	if oerr != nil {
+		return nil, oerr
+	}
+	if o == nil || len(o.Header) == 0 {
+		return nil, err
+	}
+
+	data, err := b.store.ReadBlock(ctx, o, c, r)
+	if err != nil {
+		log.Debugf("reading %s from %s: %s", c, r.FilePath, err)
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// Verifier checks the objects of the urlstore whose server requires headers,
+// which the filestore cannot read, and the validators of the URLs of all of
+// them.
+type Verifier struct {
+	store *Store
+	// checked are the results of the checks of the validators of the URLs
+	// already seen.
+	checked map[string]error
+}
+
+// NewVerifier returns a Verifier with the origins of s.
+func NewVerifier(s *Store) *Verifier {
+	return &Verifier{store: s, checked: make(map[string]error)}
+}
+
+// Verify returns r, the verification of an object by the filestore, or the
+// one of the block read again with the headers of its origin when the
+// filestore failed to read it. The objects read are reported changed when the
+// validators of their URL are not the ones seen when it was added; the hashes
+// of their blocks are the only check of the servers sending none.
+func (v *Verifier) Verify(ctx context.Context, r *filestore.ListRes) *filestore.ListRes {
+	if !filestore.IsURL(r.FilePath) {
+		return r
+	}
+	o, err := v.store.Get(ctx, r.FilePath)
+	if err != nil {
+		return withError(r, filestore.StatusOtherError, err)
+	}
+	if o == nil {
+		return r
+	}
+
+	if r.Status == filestore.StatusFileError && len(o.Header) > 0 {
+		if _, err := v.store.ReadBlock(ctx, o, r.Key, r); err != nil {
+			if cerr, ok := err.(*filestore.CorruptReferenceError); ok {
+				return withError(r, cerr.Code, cerr.Err)
+			}
+			return withError(r, filestore.StatusOtherError, err)
+		}
+		out := *r
+		out.Status, out.ErrorMsg = filestore.StatusOk, ""
+		r = &out
+	}
+	if r.Status != filestore.StatusOk && r.Status != filestore.StatusFileChanged {
+		return r
+	}
+
+	err, ok := v.checked[r.FilePath]
+	if !ok {
+		err = v.store.Check(ctx, r.FilePath, o)
+		v.checked[r.FilePath] = err
+	}
+	switch {
+	case err == ErrOriginChanged:
+		return withError(r, filestore.StatusFileChanged, err)
+	case err != nil:
+		// the block was just read, the hash check stands
+		log.Debugf("checking the validators of %s: %s", r.FilePath, err)
+	}
+	return r
+}
+
+func withError(r *filestore.ListRes, status filestore.Status, err error) *filestore.ListRes {
+	out := *r
+	out.Status, out.ErrorMsg = status, err.Error()
+	return &out
+}
@@ -0,0 +1,287 @@
+// Package urlstore keeps what was seen of the URLs the blocks of the urlstore
+// are read from, so that the changes of their content are reported, and the
+// headers their servers require are sent.
+package urlstore
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	filestore "github.com/ipfs/go-filestore"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("urlstore")
+
+// ErrOriginChanged is returned when the data of a block does not match it
+// anymore, and the validators of its URL changed since it was added.
+var ErrOriginChanged = errors.New("the content of the URL changed since it was added")
+
+var originsKey = ds.NewKey("/urlstore/origins")
+
+// Origin is what was seen of a URL when it was added.
+type Origin struct {
+	// ETag and LastModified are the validators of the content, if the
+	// server sent them.
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	// Header are the custom headers sent with the requests. They are stored
+	// in the repo as given: the references to environment variables of the
+	// daemon, such as ${TOKEN}, keep the secrets out of it, as they are only
+	// replaced when the requests are sent.
+	Header http.Header `json:",omitempty"`
+}
+
+// envRef matches the references to environment variables in the values of
+// the headers.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// envAllowed returns whether the environment variable name matches one of
+// allowed, the names of the variables the headers may reference, or their
+// prefixes followed by '*'.
+func envAllowed(allowed []string, name string) bool {
+	for _, a := range allowed {
+		if prefix := strings.TrimSuffix(a, "*"); prefix != a {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// expandHeader returns header with the references to environment variables
+// in its values replaced with theirs. Referencing a variable the store is not
+// allowed to read is an error, as anyone adding a URL chooses its headers.
+func (s *Store) expandHeader(header http.Header) (http.Header, error) {
+	out := make(http.Header, len(header))
+	for k, vs := range header {
+		for _, v := range vs {
+			var err error
+			v = envRef.ReplaceAllStringFunc(v, func(ref string) string {
+				if err != nil {
+					return ""
+				}
+				name := envRef.FindStringSubmatch(ref)[1]
+				if !envAllowed(s.env, name) {
+					err = fmt.Errorf("the environment variable %s of the header %s is not allowed by Experimental.UrlstoreHeaderEnv", name, k)
+					return ""
+				}
+				val, ok := os.LookupEnv(name)
+				if !ok {
+					err = fmt.Errorf("the environment variable %s of the header %s is not set", name, k)
+				}
+				return val
+			})
+			if err != nil {
+				return nil, err
+			}
+			out.Add(k, v)
+		}
+	}
+	return out, nil
+}
+
+// matches returns whether h, the headers of a response, are the ones of the
+// content seen, when the server sends validators.
+func (o *Origin) matches(h http.Header) bool {
+	if etag := h.Get("ETag"); o.ETag != "" && etag != "" {
+		return etag == o.ETag
+	}
+	if lm := h.Get("Last-Modified"); o.LastModified != "" && lm != "" {
+		return lm == o.LastModified
+	}
+	return true
+}
+
+// Store keeps the origins of the URLs in a datastore.
+type Store struct {
+	ds     ds.Datastore
+	client *http.Client
+	// env are the environment variables the headers may reference.
+	env []string
+}
+
+// NewStore returns a Store keeping the origins in d. The headers may only
+// reference the environment variables of env, given by name or by a prefix
+// followed by '*'.
+func NewStore(d ds.Datastore, env []string) *Store {
+	return &Store{
+		ds:     namespace.Wrap(d, originsKey),
+		client: http.DefaultClient,
+		env:    env,
+	}
+}
+
+func originKey(u string) ds.Key {
+	return ds.NewKey(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(u)))
+}
+
+// Get returns the origin of u, or nil if it is not known.
+func (s *Store) Get(ctx context.Context, u string) (*Origin, error) {
+	data, err := s.ds.Get(ctx, originKey(u))
+	if err == ds.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var o Origin
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Put stores the origin of u.
+func (s *Store) Put(ctx context.Context, u string, o *Origin) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(ctx, originKey(u), data)
+}
+
+// Record requests u with header, and stores the origin seen.
+func (s *Store) Record(ctx context.Context, u string, header http.Header) (*Origin, error) {
+	res, err := s.probe(ctx, u, header)
+	if err != nil {
+		return nil, err
+	}
+	o := &Origin{
+		ETag:         res.Get("ETag"),
+		LastModified: res.Get("Last-Modified"),
+		Header:       header,
+	}
+	return o, s.Put(ctx, u, o)
+}
+
+// probe returns the headers of the response to a request of the first byte
+// of u, as HEAD is not allowed by every server.
+func (s *Store) probe(ctx context.Context, u string, header http.Header) (http.Header, error) {
+	res, err := s.do(ctx, u, header, "bytes=0-0")
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res.Header, nil
+}
+
+func (s *Store) do(ctx context.Context, u string, header http.Header, rng string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	header, err = s.expandHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("expected HTTP 200 or 206 got %d", res.StatusCode)
+	}
+	return res, nil
+}
+
+// read reads size bytes at offset of u, with the headers of its origin o. It
+// returns them with the headers of the response.
+func (s *Store) read(ctx context.Context, o *Origin, u string, offset, size uint64) ([]byte, http.Header, error) {
+	res, err := s.do(ctx, u, o.Header, fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		// the server ignored the range, and sent the whole content
+		if _, err := io.CopyN(ioutil.Discard, res.Body, int64(offset)); err != nil {
+			return nil, nil, err
+		}
+	} else if start, ok := rangeStart(res.Header.Get("Content-Range")); !ok || start != offset {
+		return nil, nil, fmt.Errorf("expected a range starting at %d, got %q", offset, res.Header.Get("Content-Range"))
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(res.Body, data); err != nil {
+		return nil, nil, err
+	}
+	return data, res.Header, nil
+}
+
+// rangeStart returns the first byte of a Content-Range header.
+func rangeStart(h string) (uint64, bool) {
+	h = strings.TrimPrefix(h, "bytes ")
+	i := strings.IndexByte(h, '-')
+	if i < 0 {
+		return 0, false
+	}
+	start, err := strconv.ParseUint(h[:i], 10, 64)
+	return start, err == nil
+}
+
+// ReadBlock reads the data of the block c, referenced by r, from its origin
+// o, and checks it against c. It returns a filestore.CorruptReferenceError if
+// the data cannot be read or changed. The data still matching c is returned
+// even if the validators of the URL changed.
+func (s *Store) ReadBlock(ctx context.Context, o *Origin, c cid.Cid, r *filestore.ListRes) ([]byte, error) {
+	data, h, err := s.read(ctx, o, r.FilePath, r.Offset, r.Size)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return nil, &filestore.CorruptReferenceError{Code: filestore.StatusFileChanged, Err: err}
+	case err != nil:
+		return nil, &filestore.CorruptReferenceError{Code: filestore.StatusFileError, Err: err}
+	}
+
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !sum.Equals(c) {
+		err := fmt.Errorf("data in %s did not match. offset %d", r.FilePath, r.Offset)
+		if !o.matches(h) {
+			err = ErrOriginChanged
+		}
+		return nil, &filestore.CorruptReferenceError{Code: filestore.StatusFileChanged, Err: err}
+	}
+	return data, nil
+}
+
+// Check returns ErrOriginChanged if the validators of u, requested with the
+// headers of its origin o, are not the ones seen when it was added.
+func (s *Store) Check(ctx context.Context, u string, o *Origin) error {
+	h, err := s.probe(ctx, u, o.Header)
+	if err != nil {
+		return err
+	}
+	if !o.matches(h) {
+		return ErrOriginChanged
+	}
+	return nil
+}
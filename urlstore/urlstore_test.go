@@ -0,0 +1,301 @@
+package urlstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	filestore "github.com/ipfs/go-filestore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	posinfo "github.com/ipfs/go-ipfs-posinfo"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// origin serves content at any path, to the requests with the header
+// X-Token: secret unless it is public.
+type origin struct {
+	lk          sync.Mutex
+	content     []byte
+	etag        string
+	ignoreRange bool
+	public      bool
+}
+
+func (o *origin) set(content []byte, etag string) {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+	o.content, o.etag = content, etag
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.lk.Lock()
+	content, etag, ignoreRange, public := o.content, o.etag, o.ignoreRange, o.public
+	o.lk.Unlock()
+
+	if !public && r.Header.Get("X-Token") != "secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if ignoreRange {
+		w.Write(content)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+var testHeader = http.Header{"X-Token": {"secret"}}
+
+type testStore struct {
+	fs    *filestore.Filestore
+	store *Store
+	url   string
+	keys  []cid.Cid
+}
+
+// newTestStore adds the blocks of content, split at 1000, at the URL of srv
+// requested with header.
+func newTestStore(t *testing.T, srv *httptest.Server, content []byte, header http.Header) *testStore {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	fm := filestore.NewFileManager(d, "/")
+	fm.AllowUrls = true
+	ts := &testStore{
+		fs:    filestore.NewFilestore(blockstore.NewBlockstore(d), fm),
+		store: NewStore(d, nil),
+		url:   srv.URL + "/file",
+	}
+
+	if _, err := ts.store.Record(ctx, ts.url, header); err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range []int{0, 1000} {
+		end := len(content)
+		if off == 0 {
+			end = 1000
+		}
+		nd := dag.NewRawNode(content[off:end])
+		err := ts.fs.Put(ctx, &posinfo.FilestoreNode{
+			Node:    nd,
+			PosInfo: &posinfo.PosInfo{Offset: uint64(off), FullPath: ts.url},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.keys = append(ts.keys, nd.Cid())
+	}
+	return ts
+}
+
+func randomContent(n int, seed int64) []byte {
+	content := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(content)
+	return content
+}
+
+func TestBlockstore(t *testing.T) {
+	ctx := context.Background()
+	content := randomContent(3000, 1)
+	o := &origin{content: content, etag: `"v1"`}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	ts := newTestStore(t, srv, content, testHeader)
+	bs := NewBlockstore(ts.fs, ts.store)
+
+	// the filestore does not send the headers
+	if _, err := ts.fs.Get(ctx, ts.keys[0]); err == nil {
+		t.Fatal("expected the filestore to fail without the headers")
+	}
+	var got []byte
+	for _, k := range ts.keys {
+		blk, err := bs.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, blk.RawData()...)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("the blocks do not match the content")
+	}
+
+	// the same content with another version is still read, and the
+	// version seen when it was added kept
+	o.set(content, `"v2"`)
+	if _, err := bs.Get(ctx, ts.keys[1]); err != nil {
+		t.Fatal(err)
+	}
+	if so, err := ts.store.Get(ctx, ts.url); err != nil || so.ETag != `"v1"` {
+		t.Fatalf("expected the version seen to be kept, got %+v, %v", so, err)
+	}
+
+	// another content with another version
+	o.set(randomContent(3000, 2), `"v3"`)
+	_, err := bs.Get(ctx, ts.keys[1])
+	cerr, ok := err.(*filestore.CorruptReferenceError)
+	if !ok || cerr.Code != filestore.StatusFileChanged || cerr.Err != ErrOriginChanged {
+		t.Fatalf("expected the origin to have changed, got %v", err)
+	}
+
+	// another content without validators
+	o.set(randomContent(3000, 3), "")
+	_, err = bs.Get(ctx, ts.keys[0])
+	if cerr, ok := err.(*filestore.CorruptReferenceError); !ok || cerr.Code != filestore.StatusFileChanged {
+		t.Fatalf("expected the data to have changed, got %v", err)
+	}
+}
+
+func TestBlockstoreIgnoredRange(t *testing.T) {
+	ctx := context.Background()
+	content := randomContent(3000, 1)
+	o := &origin{content: content, ignoreRange: true}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	ts := newTestStore(t, srv, content, testHeader)
+	bs := NewBlockstore(ts.fs, ts.store)
+
+	blk, err := bs.Get(ctx, ts.keys[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blk.RawData(), content[1000:]) {
+		t.Fatal("the block does not match the content at its offset")
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	ctx := context.Background()
+	content := randomContent(3000, 1)
+	o := &origin{content: content, etag: `"v1"`}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	ts := newTestStore(t, srv, content, testHeader)
+
+	verify := func(expected filestore.Status) {
+		t.Helper()
+		v := NewVerifier(ts.store)
+		for _, k := range ts.keys {
+			r := filestore.Verify(ctx, ts.fs, k)
+			if r.Status != filestore.StatusFileError {
+				t.Fatalf("expected the filestore to fail without the headers, got %s", r.Status)
+			}
+			if r = v.Verify(ctx, r); r.Status != expected {
+				t.Fatalf("expected %s, got %s: %s", expected, r.Status, r.ErrorMsg)
+			}
+		}
+	}
+	verify(filestore.StatusOk)
+	o.set(content, `"v2"`)
+	verify(filestore.StatusFileChanged)
+	o.set(randomContent(3000, 2), `"v3"`)
+	verify(filestore.StatusFileChanged)
+}
+
+func TestVerifierPublic(t *testing.T) {
+	ctx := context.Background()
+	content := randomContent(3000, 1)
+	o := &origin{content: content, etag: `"v1"`, public: true}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	ts := newTestStore(t, srv, content, nil)
+
+	// the filestore reads the blocks, the verifier checks the validators
+	verify := func(fsStatus, expected filestore.Status) {
+		t.Helper()
+		v := NewVerifier(ts.store)
+		for _, k := range ts.keys {
+			r := filestore.Verify(ctx, ts.fs, k)
+			if r.Status != fsStatus {
+				t.Fatalf("expected the filestore to report %s, got %s", fsStatus, r.Status)
+			}
+			if r = v.Verify(ctx, r); r.Status != expected {
+				t.Fatalf("expected %s, got %s: %s", expected, r.Status, r.ErrorMsg)
+			}
+		}
+	}
+	verify(filestore.StatusOk, filestore.StatusOk)
+	o.set(content, `"v2"`)
+	verify(filestore.StatusOk, filestore.StatusFileChanged)
+
+	// without validators, only the hashes are checked
+	o.set(content, "")
+	verify(filestore.StatusOk, filestore.StatusOk)
+	o.set(randomContent(3000, 2), "")
+	verify(filestore.StatusFileChanged, filestore.StatusFileChanged)
+}
+
+func TestWebFile(t *testing.T) {
+	ctx := context.Background()
+	content := randomContent(3000, 1)
+	srv := httptest.NewServer(&origin{content: content, etag: `"v1"`})
+	defer srv.Close()
+
+	s := NewStore(dssync.MutexWrap(ds.NewMapDatastore()), []string{"IPFS_URLSTORE_*"})
+	u, err := url.Parse(srv.URL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the token is read from the environment, and not stored
+	os.Setenv("IPFS_URLSTORE_TEST_TOKEN", "secret")
+	defer os.Unsetenv("IPFS_URLSTORE_TEST_TOKEN")
+	wf := NewWebFile(ctx, s, u, http.Header{"X-Token": {"${IPFS_URLSTORE_TEST_TOKEN}"}})
+	defer wf.Close()
+	data, err := ioutil.ReadAll(wf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("the file does not match the content")
+	}
+
+	o, err := s.Get(ctx, u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o == nil || o.ETag != `"v1"` || o.Header.Get("X-Token") != "${IPFS_URLSTORE_TEST_TOKEN}" {
+		t.Fatalf("unexpected origin %+v", o)
+	}
+
+	os.Unsetenv("IPFS_URLSTORE_TEST_TOKEN")
+	if _, err := s.Record(ctx, u.String(), o.Header); err == nil {
+		t.Fatal("expected the unset environment variable to fail the request")
+	}
+
+	// the variables that are not allowed are not read, even when set
+	os.Setenv("URLSTORE_TEST_TOKEN", "secret")
+	defer os.Unsetenv("URLSTORE_TEST_TOKEN")
+	if _, err := s.Record(ctx, u.String(), http.Header{"X-Token": {"${URLSTORE_TEST_TOKEN}"}}); err == nil {
+		t.Fatal("expected the environment variable not allowed to fail the request")
+	}
+}
+
+func TestEnvAllowed(t *testing.T) {
+	allowed := []string{"IPFS_URLSTORE_*", "TOKEN"}
+	for name, expected := range map[string]bool{
+		"IPFS_URLSTORE_TOKEN": true,
+		"IPFS_URLSTORE_":      true,
+		"TOKEN":               true,
+		"TOKEN2":              false,
+		"IPFS_PATH":           false,
+		"HOME":                false,
+	} {
+		if envAllowed(allowed, name) != expected {
+			t.Errorf("expected envAllowed(%q) to be %t", name, expected)
+		}
+	}
+	if envAllowed(nil, "HOME") {
+		t.Error("expected no variable to be allowed by default")
+	}
+}
@@ -0,0 +1,137 @@
+package urlstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	filestore "github.com/ipfs/go-filestore"
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// WebFile is a file read from a URL, requested with custom headers, whose
+// origin is recorded when it is read.
+type WebFile struct {
+	ctx    context.Context
+	store  *Store
+	url    *url.URL
+	header http.Header
+
+	body io.ReadCloser
+	size int64
+}
+
+// NewWebFile returns a WebFile reading u with header, and recording its
+// origin in s.
+func NewWebFile(ctx context.Context, s *Store, u *url.URL, header http.Header) *WebFile {
+	return &WebFile{ctx: ctx, store: s, url: u, header: header}
+}
+
+func (wf *WebFile) start() error {
+	if wf.body != nil {
+		return nil
+	}
+	u := wf.url.String()
+	res, err := wf.store.do(wf.ctx, u, wf.header, "")
+	if err != nil {
+		return err
+	}
+	err = wf.store.Put(wf.ctx, u, &Origin{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Header:       wf.header,
+	})
+	if err != nil {
+		res.Body.Close()
+		return err
+	}
+	wf.body = res.Body
+	wf.size = res.ContentLength
+	return nil
+}
+
+func (wf *WebFile) Read(b []byte) (int, error) {
+	if err := wf.start(); err != nil {
+		return 0, err
+	}
+	return wf.body.Read(b)
+}
+
+func (wf *WebFile) Close() error {
+	if wf.body == nil {
+		return nil
+	}
+	return wf.body.Close()
+}
+
+func (wf *WebFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, files.ErrNotSupported
+}
+
+func (wf *WebFile) Size() (int64, error) {
+	if err := wf.start(); err != nil {
+		return 0, err
+	}
+	if wf.size < 0 {
+		return -1, errors.New("Content-Length header was not set")
+	}
+	return wf.size, nil
+}
+
+func (wf *WebFile) AbsPath() string {
+	return wf.url.String()
+}
+
+func (wf *WebFile) Stat() os.FileInfo {
+	return nil
+}
+
+var _ files.File = &WebFile{}
+var _ files.FileInfo = &WebFile{}
+
+// RecordOrigins returns dir, recording the origin of its files read from URLs
+// as they are iterated.
+func RecordOrigins(ctx context.Context, s *Store, dir files.Directory) files.Directory {
+	return &originDirectory{Directory: dir, ctx: ctx, store: s}
+}
+
+type originDirectory struct {
+	files.Directory
+	ctx   context.Context
+	store *Store
+}
+
+func (d *originDirectory) Entries() files.DirIterator {
+	return &originIterator{DirIterator: d.Directory.Entries(), dir: d}
+}
+
+type originIterator struct {
+	files.DirIterator
+	dir *originDirectory
+	err error
+}
+
+func (it *originIterator) Next() bool {
+	if !it.DirIterator.Next() {
+		return false
+	}
+	fi, ok := it.Node().(files.FileInfo)
+	if !ok || !filestore.IsURL(fi.AbsPath()) {
+		return true
+	}
+	if _, err := it.dir.store.Record(it.dir.ctx, fi.AbsPath(), nil); err != nil {
+		it.err = err
+		return false
+	}
+	return true
+}
+
+func (it *originIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.DirIterator.Err()
+}